@@ -28,10 +28,34 @@ type BookServiceClient interface {
 	GetBook(ctx context.Context, in *GetBookRequest, opts ...grpc.CallOption) (*Book, error)
 	// CreateBook adds a new book to the database.
 	CreateBook(ctx context.Context, in *CreateBookRequest, opts ...grpc.CallOption) (*Book, error)
-	// UpdateBook modifies an existing book's details.
+	// UpdateBook modifies an existing book's details, only those listed in
+	// the update mask if one is given. If an expected version is given, it
+	// fails with ABORTED should the book be at another version.
 	UpdateBook(ctx context.Context, in *UpdateBookRequest, opts ...grpc.CallOption) (*Book, error)
 	// DeleteBook removes a book from the database by its ID.
 	DeleteBook(ctx context.Context, in *DeleteBookRequest, opts ...grpc.CallOption) (*DeleteBookResponse, error)
+	// StreamBooks streams all books in the database, one book per message.
+	StreamBooks(ctx context.Context, in *StreamBooksRequest, opts ...grpc.CallOption) (BookService_StreamBooksClient, error)
+	// SearchBooks performs a full-text search over book titles and authors.
+	SearchBooks(ctx context.Context, in *SearchBooksRequest, opts ...grpc.CallOption) (*SearchBooksResponse, error)
+	// BatchCreateBooks adds up to 1000 books within a single transaction,
+	// reporting the outcome of each one. Unless best effort is asked for,
+	// no book is added if any of them is invalid or already exists.
+	BatchCreateBooks(ctx context.Context, in *BatchCreateBooksRequest, opts ...grpc.CallOption) (*BatchCreateBooksResponse, error)
+	// BatchDeleteBooks removes up to 1000 books by their IDs within a single
+	// transaction, reporting the outcome of each one. Unless best effort is
+	// asked for, no book is removed if any of the IDs is invalid.
+	BatchDeleteBooks(ctx context.Context, in *BatchDeleteBooksRequest, opts ...grpc.CallOption) (*BatchDeleteBooksResponse, error)
+	// ImportBooks adds the books streamed by the client, in transactions of
+	// the configured size. Invalid books and those that already exist are
+	// skipped. Once the client closes the stream, it replies with how many
+	// books were created, already existed or were invalid.
+	ImportBooks(ctx context.Context, opts ...grpc.CallOption) (BookService_ImportBooksClient, error)
+	// WatchBooks streams the changes made to books as they happen, one
+	// change per message, until the client cancels. A client reconnecting
+	// resumes from the sequence of the last change it received, so that
+	// it misses none of those made in the meantime.
+	WatchBooks(ctx context.Context, in *WatchBooksRequest, opts ...grpc.CallOption) (BookService_WatchBooksClient, error)
 }
 
 type bookServiceClient struct {
@@ -87,6 +111,131 @@ func (c *bookServiceClient) DeleteBook(ctx context.Context, in *DeleteBookReques
 	return out, nil
 }
 
+func (c *bookServiceClient) StreamBooks(ctx context.Context, in *StreamBooksRequest, opts ...grpc.CallOption) (BookService_StreamBooksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BookService_ServiceDesc.Streams[0], "/books.BookService/StreamBooks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bookServiceStreamBooksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BookService_StreamBooksClient interface {
+	Recv() (*Book, error)
+	grpc.ClientStream
+}
+
+type bookServiceStreamBooksClient struct {
+	grpc.ClientStream
+}
+
+func (x *bookServiceStreamBooksClient) Recv() (*Book, error) {
+	m := new(Book)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bookServiceClient) SearchBooks(ctx context.Context, in *SearchBooksRequest, opts ...grpc.CallOption) (*SearchBooksResponse, error) {
+	out := new(SearchBooksResponse)
+	err := c.cc.Invoke(ctx, "/books.BookService/SearchBooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) BatchCreateBooks(ctx context.Context, in *BatchCreateBooksRequest, opts ...grpc.CallOption) (*BatchCreateBooksResponse, error) {
+	out := new(BatchCreateBooksResponse)
+	err := c.cc.Invoke(ctx, "/books.BookService/BatchCreateBooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) BatchDeleteBooks(ctx context.Context, in *BatchDeleteBooksRequest, opts ...grpc.CallOption) (*BatchDeleteBooksResponse, error) {
+	out := new(BatchDeleteBooksResponse)
+	err := c.cc.Invoke(ctx, "/books.BookService/BatchDeleteBooks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *bookServiceClient) ImportBooks(ctx context.Context, opts ...grpc.CallOption) (BookService_ImportBooksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BookService_ServiceDesc.Streams[1], "/books.BookService/ImportBooks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bookServiceImportBooksClient{stream}
+	return x, nil
+}
+
+type BookService_ImportBooksClient interface {
+	Send(*CreateBookRequest) error
+	CloseAndRecv() (*ImportSummary, error)
+	grpc.ClientStream
+}
+
+type bookServiceImportBooksClient struct {
+	grpc.ClientStream
+}
+
+func (x *bookServiceImportBooksClient) Send(m *CreateBookRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *bookServiceImportBooksClient) CloseAndRecv() (*ImportSummary, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(ImportSummary)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *bookServiceClient) WatchBooks(ctx context.Context, in *WatchBooksRequest, opts ...grpc.CallOption) (BookService_WatchBooksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BookService_ServiceDesc.Streams[2], "/books.BookService/WatchBooks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &bookServiceWatchBooksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BookService_WatchBooksClient interface {
+	Recv() (*BookChange, error)
+	grpc.ClientStream
+}
+
+type bookServiceWatchBooksClient struct {
+	grpc.ClientStream
+}
+
+func (x *bookServiceWatchBooksClient) Recv() (*BookChange, error) {
+	m := new(BookChange)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // BookServiceServer is the server API for BookService service.
 // All implementations should embed UnimplementedBookServiceServer
 // for forward compatibility
@@ -97,10 +246,34 @@ type BookServiceServer interface {
 	GetBook(context.Context, *GetBookRequest) (*Book, error)
 	// CreateBook adds a new book to the database.
 	CreateBook(context.Context, *CreateBookRequest) (*Book, error)
-	// UpdateBook modifies an existing book's details.
+	// UpdateBook modifies an existing book's details, only those listed in
+	// the update mask if one is given. If an expected version is given, it
+	// fails with ABORTED should the book be at another version.
 	UpdateBook(context.Context, *UpdateBookRequest) (*Book, error)
 	// DeleteBook removes a book from the database by its ID.
 	DeleteBook(context.Context, *DeleteBookRequest) (*DeleteBookResponse, error)
+	// StreamBooks streams all books in the database, one book per message.
+	StreamBooks(*StreamBooksRequest, BookService_StreamBooksServer) error
+	// SearchBooks performs a full-text search over book titles and authors.
+	SearchBooks(context.Context, *SearchBooksRequest) (*SearchBooksResponse, error)
+	// BatchCreateBooks adds up to 1000 books within a single transaction,
+	// reporting the outcome of each one. Unless best effort is asked for,
+	// no book is added if any of them is invalid or already exists.
+	BatchCreateBooks(context.Context, *BatchCreateBooksRequest) (*BatchCreateBooksResponse, error)
+	// BatchDeleteBooks removes up to 1000 books by their IDs within a single
+	// transaction, reporting the outcome of each one. Unless best effort is
+	// asked for, no book is removed if any of the IDs is invalid.
+	BatchDeleteBooks(context.Context, *BatchDeleteBooksRequest) (*BatchDeleteBooksResponse, error)
+	// ImportBooks adds the books streamed by the client, in transactions of
+	// the configured size. Invalid books and those that already exist are
+	// skipped. Once the client closes the stream, it replies with how many
+	// books were created, already existed or were invalid.
+	ImportBooks(BookService_ImportBooksServer) error
+	// WatchBooks streams the changes made to books as they happen, one
+	// change per message, until the client cancels. A client reconnecting
+	// resumes from the sequence of the last change it received, so that
+	// it misses none of those made in the meantime.
+	WatchBooks(*WatchBooksRequest, BookService_WatchBooksServer) error
 }
 
 // UnimplementedBookServiceServer should be embedded to have forward compatible implementations.
@@ -122,6 +295,24 @@ func (UnimplementedBookServiceServer) UpdateBook(context.Context, *UpdateBookReq
 func (UnimplementedBookServiceServer) DeleteBook(context.Context, *DeleteBookRequest) (*DeleteBookResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method DeleteBook not implemented")
 }
+func (UnimplementedBookServiceServer) StreamBooks(*StreamBooksRequest, BookService_StreamBooksServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamBooks not implemented")
+}
+func (UnimplementedBookServiceServer) SearchBooks(context.Context, *SearchBooksRequest) (*SearchBooksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SearchBooks not implemented")
+}
+func (UnimplementedBookServiceServer) BatchCreateBooks(context.Context, *BatchCreateBooksRequest) (*BatchCreateBooksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchCreateBooks not implemented")
+}
+func (UnimplementedBookServiceServer) BatchDeleteBooks(context.Context, *BatchDeleteBooksRequest) (*BatchDeleteBooksResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchDeleteBooks not implemented")
+}
+func (UnimplementedBookServiceServer) ImportBooks(BookService_ImportBooksServer) error {
+	return status.Errorf(codes.Unimplemented, "method ImportBooks not implemented")
+}
+func (UnimplementedBookServiceServer) WatchBooks(*WatchBooksRequest, BookService_WatchBooksServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchBooks not implemented")
+}
 
 // UnsafeBookServiceServer may be embedded to opt out of forward compatibility for this service.
 // Use of this interface is not recommended, as added methods to BookServiceServer will
@@ -224,6 +415,128 @@ func _BookService_DeleteBook_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BookService_StreamBooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamBooksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BookServiceServer).StreamBooks(m, &bookServiceStreamBooksServer{stream})
+}
+
+type BookService_StreamBooksServer interface {
+	Send(*Book) error
+	grpc.ServerStream
+}
+
+type bookServiceStreamBooksServer struct {
+	grpc.ServerStream
+}
+
+func (x *bookServiceStreamBooksServer) Send(m *Book) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BookService_SearchBooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SearchBooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).SearchBooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/books.BookService/SearchBooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).SearchBooks(ctx, req.(*SearchBooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_BatchCreateBooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchCreateBooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).BatchCreateBooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/books.BookService/BatchCreateBooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).BatchCreateBooks(ctx, req.(*BatchCreateBooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_BatchDeleteBooks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchDeleteBooksRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BookServiceServer).BatchDeleteBooks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/books.BookService/BatchDeleteBooks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BookServiceServer).BatchDeleteBooks(ctx, req.(*BatchDeleteBooksRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BookService_ImportBooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BookServiceServer).ImportBooks(&bookServiceImportBooksServer{stream})
+}
+
+type BookService_ImportBooksServer interface {
+	SendAndClose(*ImportSummary) error
+	Recv() (*CreateBookRequest, error)
+	grpc.ServerStream
+}
+
+type bookServiceImportBooksServer struct {
+	grpc.ServerStream
+}
+
+func (x *bookServiceImportBooksServer) SendAndClose(m *ImportSummary) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *bookServiceImportBooksServer) Recv() (*CreateBookRequest, error) {
+	m := new(CreateBookRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _BookService_WatchBooks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchBooksRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BookServiceServer).WatchBooks(m, &bookServiceWatchBooksServer{stream})
+}
+
+type BookService_WatchBooksServer interface {
+	Send(*BookChange) error
+	grpc.ServerStream
+}
+
+type bookServiceWatchBooksServer struct {
+	grpc.ServerStream
+}
+
+func (x *bookServiceWatchBooksServer) Send(m *BookChange) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 // BookService_ServiceDesc is the grpc.ServiceDesc for BookService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -251,7 +564,35 @@ var BookService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "DeleteBook",
 			Handler:    _BookService_DeleteBook_Handler,
 		},
+		{
+			MethodName: "SearchBooks",
+			Handler:    _BookService_SearchBooks_Handler,
+		},
+		{
+			MethodName: "BatchCreateBooks",
+			Handler:    _BookService_BatchCreateBooks_Handler,
+		},
+		{
+			MethodName: "BatchDeleteBooks",
+			Handler:    _BookService_BatchDeleteBooks_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamBooks",
+			Handler:       _BookService_StreamBooks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "ImportBooks",
+			Handler:       _BookService_ImportBooks_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "WatchBooks",
+			Handler:       _BookService_WatchBooks_Handler,
+			ServerStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "book.proto",
 }
@@ -0,0 +1,110 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/db/books/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/db/books/models"
+)
+
+// Statements that undo a failed operation of a batch without undoing
+// the others, which are supported by both SQLite and PostgreSQL.
+const (
+	savepointQuery           = "SAVEPOINT batch_operation"
+	rollbackToSavepointQuery = "ROLLBACK TO SAVEPOINT batch_operation"
+	releaseSavepointQuery    = "RELEASE SAVEPOINT batch_operation"
+)
+
+// beginFunc begins a transaction changing books in the way of a given backend.
+type beginFunc func(ctx context.Context, db *sql.DB) (*sql.Tx, error)
+
+// createFunc adds a new book record in the way of a given backend.
+type createFunc func(ctx context.Context, db Querier, newBook *models.NewBook) (*models.NewBook, error)
+
+// beginTx begins a transaction, which needs nothing more with SQLite,
+// as it only lets a single transaction write at a time.
+func beginTx(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	return db.BeginTx(ctx, nil)
+}
+
+// Batch runs the given operations within a single transaction, returning
+// their results in the same order. An operation creating a duplicate book
+// is undone on its own and the error is reported in its result. Any other
+// error rolls the whole batch back and is returned. Unless bestEffort is
+// set, the batch is also rolled back if any operation failed.
+func Batch(ctx context.Context, db *sql.DB, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	return batch(ctx, db, ops, bestEffort, beginTx, Create)
+}
+
+// batch runs a batch within a transaction begun by the given begin
+// function, adding books with the given create function.
+func batch(ctx context.Context, db *sql.DB, ops []*models.BatchOperation, bestEffort bool, begin beginFunc, create createFunc) ([]*models.BatchResult, error) {
+	// The span covers the whole transaction, the spans of the
+	// operations being its children.
+	ctx, span := startSpan(ctx, "Batch", "BEGIN")
+	defer span.End()
+	tx, err := begin(ctx, db)
+	if err != nil {
+		return nil, spanError(span, errors.Wrap(err, "beginning transaction"))
+	}
+	defer tx.Rollback()
+	results := make([]*models.BatchResult, len(ops))
+	failed := false
+	for i, op := range ops {
+		if _, err := tx.ExecContext(ctx, savepointQuery); err != nil {
+			return nil, spanError(span, errors.Wrap(err, "creating savepoint"))
+		}
+		book, err := runOperation(ctx, tx, op, create)
+		if err != nil {
+			var errDuplicateBook *bookErrors.ErrDuplicateBook
+			if !errors.As(err, &errDuplicateBook) {
+				return nil, spanError(span, errors.Wrapf(err, "running operation %d", i))
+			}
+			if _, err := tx.ExecContext(ctx, rollbackToSavepointQuery); err != nil {
+				return nil, spanError(span, errors.Wrap(err, "rolling back to savepoint"))
+			}
+			results[i] = &models.BatchResult{Err: err}
+			failed = true
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, releaseSavepointQuery); err != nil {
+			return nil, spanError(span, errors.Wrap(err, "releasing savepoint"))
+		}
+		results[i] = &models.BatchResult{Book: book}
+	}
+	if failed && !bestEffort {
+		return results, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, spanError(span, errors.Wrap(err, "committing transaction"))
+	}
+	return results, nil
+}
+
+// runOperation runs an operation of a batch within the given transaction,
+// returning the book it created, if any.
+func runOperation(ctx context.Context, tx *sql.Tx, op *models.BatchOperation, create createFunc) (*models.Book, error) {
+	switch {
+	case op.Create != nil:
+		newBook, err := create(ctx, tx, op.Create)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Book{
+			Id:      newBook.Id,
+			Title:   newBook.Title,
+			Author:  newBook.Author,
+			Pages:   newBook.Pages,
+			Version: newBook.Version,
+		}, nil
+	case op.Delete != nil:
+		return nil, DeleteById(ctx, tx, op.Delete.Id)
+	}
+	return nil, errors.New("empty batch operation")
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStore(t *testing.T) {
+	testCases := []struct {
+		name           string
+		driver         string
+		expectedOutput BookStore
+		expectedError  error
+	}{
+		{
+			name:           "sqlite",
+			driver:         "sqlite3",
+			expectedOutput: NewSqliteStore(nil),
+		},
+		{
+			name:           "postgres",
+			driver:         "postgres",
+			expectedOutput: NewPostgresStore(nil),
+		},
+		{
+			name:          "unsupported driver",
+			driver:        "mysql",
+			expectedError: errors.New(`no book store for database driver "mysql"`),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			output, err := NewStore(tc.driver, nil)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	testCases := []struct {
+		name          string
+		newStore      func(db *sql.DB) BookStore
+		mockClosure   func(mock sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "sqlite",
+			newStore: func(db *sql.DB) BookStore {
+				return NewSqliteStore(db)
+			},
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+			},
+		},
+		{
+			name: "postgres",
+			newStore: func(db *sql.DB) BookStore {
+				return NewPostgresStore(db)
+			},
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+			},
+		},
+		{
+			name: "error",
+			newStore: func(db *sql.DB) BookStore {
+				return NewSqliteStore(db)
+			},
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing().WillReturnError(errors.New("ping error"))
+			},
+			expectedError: errors.New("pinging database: ping error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			require.NoError(t, err)
+			defer db.Close()
+			tc.mockClosure(mock)
+			err = tc.newStore(db).Ping(context.TODO())
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
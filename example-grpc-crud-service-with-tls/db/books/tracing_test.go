@@ -0,0 +1,132 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+
+	testCases := []struct {
+		name           string
+		mockClosure    func() *sql.DB
+		call           func(ctx context.Context, db *sql.DB) error
+		expectedName   string
+		expectedStatus codes.Code
+		expectedError  error
+	}{
+		{
+			name: "query",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(listQuery)).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "pages"}))
+				return db
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				_, err := List(ctx, db)
+				return err
+			},
+			expectedName:   "books.List",
+			expectedStatus: codes.Unset,
+		},
+		{
+			name: "exec error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1).
+					WillReturnError(errors.New("delete error"))
+				return db
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				return DeleteById(ctx, db, 1)
+			},
+			expectedName:   "books.DeleteById",
+			expectedStatus: codes.Error,
+			expectedError:  errors.New("deleting book with id 1: delete error"),
+		},
+		{
+			name: "not found is not an error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(getByIdQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				return db
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetById(ctx, db, 1)
+				return err
+			},
+			expectedName:   "books.GetById",
+			expectedStatus: codes.Unset,
+			expectedError:  errors.New("no book with id 1 found"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+			ctx, parent := provider.Tracer("test").Start(context.TODO(), "parent")
+			err := tc.call(ctx, tc.mockClosure())
+			parent.End()
+			if tc.expectedError != nil {
+				require.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				require.NoError(t, err)
+			}
+
+			spans := exporter.GetSpans()
+			require.Len(t, spans, 2)
+			span := spans[0]
+			require.Equal(t, tc.expectedName, span.Name)
+			require.Equal(t, trace.SpanKindClient, span.SpanKind)
+			require.Equal(t, tc.expectedStatus, span.Status.Code)
+			require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+			require.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+			require.Contains(t, span.Attributes, attribute.String("db.sql.table", "books"))
+		})
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+
+	_, span := startSpan(context.TODO(), "Update", updateQuery)
+	span.End()
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("db.operation", "UPDATE"),
+		attribute.String("db.sql.table", "books"),
+		attribute.String("db.statement", "UPDATE books SET title = $1, author = $2, pages = $3, version = version + 1 WHERE id = $4 AND ($5 = 0 OR version = $5) RETURNING version"),
+	}, spans[0].Attributes)
+}
@@ -15,7 +15,7 @@ import (
 func TestConnectToSqlite(t *testing.T) {
 	testCases := []struct {
 		name          string
-		mockSqlOpen   func(driverName string, dataSourceName string) (*sql.DB, error)
+		mockSqlOpen   openFunc
 		expectedError error
 	}{
 		{
@@ -33,9 +33,61 @@ func TestConnectToSqlite(t *testing.T) {
 		},
 	}
 	for _, tc := range testCases {
-		sqlOpen = tc.mockSqlOpen
 		t.Run(tc.name, func(t *testing.T) {
-			db, err := ConnectToSqlite("path/to/file.db")
+			db, err := connectToSqlite(tc.mockSqlOpen, "path/to/file.db")
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.NotNil(t, db)
+			}
+		})
+	}
+}
+
+func TestOpen(t *testing.T) {
+	testCases := []struct {
+		name          string
+		driver        string
+		mockSqlOpen   openFunc
+		expectedError error
+	}{
+		{
+			name:   "happy path, sqlite",
+			driver: SqliteDriver,
+			mockSqlOpen: func(driverName, dataSourceName string) (*sql.DB, error) {
+				return new(sql.DB), nil
+			},
+		},
+		{
+			name:   "happy path, postgres",
+			driver: PostgresDriver,
+			mockSqlOpen: func(driverName, dataSourceName string) (*sql.DB, error) {
+				return new(sql.DB), nil
+			},
+		},
+		{
+			name:          "unsupported driver",
+			driver:        "mysql",
+			expectedError: errors.New(`unsupported database driver "mysql"`),
+		},
+		{
+			name:   "error",
+			driver: PostgresDriver,
+			mockSqlOpen: func(driverName, dataSourceName string) (*sql.DB, error) {
+				return nil, errors.New("open error")
+			},
+			expectedError: errors.New("opening postgres database: open error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, err := open(tc.mockSqlOpen, tc.driver, "some dsn")
 			if err != nil {
 				if tc.expectedError == nil {
 					t.Fatalf(`expected no error, got "%v"`, err)
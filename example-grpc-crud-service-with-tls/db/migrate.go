@@ -0,0 +1,356 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// migrationsFS holds the SQL migrations of every supported driver.
+//
+//go:embed migrations
+var migrationsFS embed.FS
+
+// migrationsDirs maps each supported driver to its migrations directory.
+var migrationsDirs = map[string]string{
+	SqliteDriver:   "migrations/sqlite",
+	PostgresDriver: "migrations/postgres",
+}
+
+// SQL queries used to keep track of the applied migrations. The table
+// layout is the one used by golang-migrate, so databases migrated by
+// its CLI and by Migrate are interchangeable.
+const (
+	createSchemaMigrationsQuery = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		dirty BOOLEAN NOT NULL
+	)
+	`
+
+	getVersionQuery = `
+	SELECT version, dirty
+	FROM schema_migrations
+	LIMIT 1
+	`
+
+	deleteVersionQuery = `
+	DELETE FROM schema_migrations
+	`
+
+	setVersionQuery = `
+	INSERT INTO schema_migrations (version, dirty)
+	VALUES ($1, false)
+	`
+)
+
+// fts5Query tells whether SQLite was compiled with the FTS5 extension,
+// which the full-text search migrations need.
+const fts5Query = `SELECT sqlite_compileoption_used('ENABLE_FTS5')`
+
+// schemaMigrationsExistsQueries tell, for each supported driver, whether
+// the schema_migrations table exists.
+var schemaMigrationsExistsQueries = map[string]string{
+	SqliteDriver:   `SELECT COUNT(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`,
+	PostgresDriver: `SELECT to_regclass('schema_migrations') IS NOT NULL`,
+}
+
+// Statements that keep concurrent processes, such as replicas starting
+// together, from migrating the same database at once. A migration run
+// is a single transaction on a single connection, which takes an
+// advisory lock on PostgreSQL, while SQLite, which has no such lock,
+// locks the whole database. Each migration runs in a savepoint, so that
+// those applied before a failed one are kept.
+const (
+	beginSqliteQuery   = `BEGIN EXCLUSIVE`
+	beginPostgresQuery = `BEGIN`
+	lockPostgresQuery  = `SELECT pg_advisory_xact_lock($1)`
+	commitQuery        = `COMMIT`
+	rollbackQuery      = `ROLLBACK`
+	savepointQuery     = `SAVEPOINT migration`
+	releaseQuery       = `RELEASE SAVEPOINT migration`
+	rollbackToQuery    = `ROLLBACK TO SAVEPOINT migration`
+)
+
+// migrationLockKey is the key of the PostgreSQL advisory lock held while
+// migrating. Any value does, as long as every process uses the same.
+const migrationLockKey = 7325147062511394816
+
+// migration is a schema version along with the SQL that applies and
+// reverts it.
+type migration struct {
+	version int
+	up      string
+	down    string
+}
+
+// execer runs SQL statements, as a database or one of its connections
+// does.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// Migrate applies every pending migration, in order, each one along
+// with the version bump. The run holds a lock that keeps other processes
+// from migrating the database meanwhile.
+func Migrate(ctx context.Context, db *sql.DB) error {
+	return runMigrations(ctx, db, func(conn *sql.Conn, migrations []migration, version int) error {
+		for _, m := range migrations {
+			if m.version <= version {
+				continue
+			}
+			if err := applyMigration(ctx, conn, m.up, m.version); err != nil {
+				return errors.Wrapf(err, "applying migration %d", m.version)
+			}
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts the latest applied migration, if any. Like Migrate,
+// it holds a lock that keeps other processes from migrating meanwhile.
+func MigrateDown(ctx context.Context, db *sql.DB) error {
+	return runMigrations(ctx, db, func(conn *sql.Conn, migrations []migration, version int) error {
+		previous := 0
+		for _, m := range migrations {
+			if m.version < version {
+				previous = m.version
+				continue
+			}
+			if m.version == version {
+				if err := applyMigration(ctx, conn, m.down, previous); err != nil {
+					return errors.Wrapf(err, "reverting migration %d", m.version)
+				}
+				return nil
+			}
+		}
+		if version != 0 {
+			return errors.Errorf("no migration found for version %d", version)
+		}
+		return nil
+	})
+}
+
+// MigrationVersion returns the version of the latest applied migration,
+// or zero if none was applied yet. It only reads the database, so that
+// it can be called as often as readiness probes are.
+func MigrationVersion(ctx context.Context, db *sql.DB) (int, error) {
+	driver, err := driverName(db)
+	if err != nil {
+		return 0, err
+	}
+	var exists bool
+	if err := db.QueryRowContext(ctx, schemaMigrationsExistsQueries[driver]).Scan(&exists); err != nil {
+		return 0, errors.Wrap(err, "checking for schema_migrations table")
+	}
+	if !exists {
+		return 0, nil
+	}
+	return readMigrationVersion(ctx, db)
+}
+
+// migrationVersion returns the version of the latest applied migration,
+// creating the schema_migrations table if need be.
+func migrationVersion(ctx context.Context, db execer) (int, error) {
+	if _, err := db.ExecContext(ctx, createSchemaMigrationsQuery); err != nil {
+		return 0, errors.Wrap(err, "creating schema_migrations table")
+	}
+	return readMigrationVersion(ctx, db)
+}
+
+// readMigrationVersion reads the version of the latest applied migration
+// from the schema_migrations table.
+func readMigrationVersion(ctx context.Context, db execer) (int, error) {
+	var (
+		version int
+		dirty   bool
+	)
+	if err := db.QueryRowContext(ctx, getVersionQuery).Scan(&version, &dirty); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, errors.Wrap(err, "getting migration version")
+	}
+	if dirty {
+		return 0, errors.Errorf("database is dirty at version %d, fix it and force the version", version)
+	}
+	return version, nil
+}
+
+// runMigrations loads the migrations matching the database driver and
+// calls fn with them and the current version, within a transaction
+// holding the migration lock. The transaction is committed even if fn
+// fails, as applyMigration only undoes the failed migration.
+func runMigrations(ctx context.Context, db *sql.DB, fn func(conn *sql.Conn, migrations []migration, version int) error) error {
+	driver, err := driverName(db)
+	if err != nil {
+		return err
+	}
+	if driver == SqliteDriver {
+		if err := checkFts5(ctx, db); err != nil {
+			return err
+		}
+	}
+	migrations, err := loadMigrations(migrationsFS, migrationsDirs[driver])
+	if err != nil {
+		return errors.Wrap(err, "loading migrations")
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting connection")
+	}
+	defer conn.Close()
+	if err := lockMigrations(ctx, conn, driver); err != nil {
+		return err
+	}
+	version, err := migrationVersion(ctx, conn)
+	if err == nil {
+		err = fn(conn, migrations, version)
+	}
+	if _, commitErr := conn.ExecContext(ctx, commitQuery); commitErr != nil {
+		// Do not hand the connection back to the pool in a transaction.
+		conn.ExecContext(context.Background(), rollbackQuery)
+		if err == nil {
+			err = errors.Wrap(commitErr, "committing migrations")
+		}
+	}
+	return err
+}
+
+// lockMigrations begins the transaction of a migration run on conn and
+// takes the migration lock, waiting for any other run to end.
+func lockMigrations(ctx context.Context, conn *sql.Conn, driver string) error {
+	if driver == SqliteDriver {
+		if _, err := conn.ExecContext(ctx, beginSqliteQuery); err != nil {
+			return errors.Wrap(err, "locking database")
+		}
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, beginPostgresQuery); err != nil {
+		return errors.Wrap(err, "beginning transaction")
+	}
+	if _, err := conn.ExecContext(ctx, lockPostgresQuery, migrationLockKey); err != nil {
+		conn.ExecContext(context.Background(), rollbackQuery)
+		return errors.Wrap(err, "taking migration lock")
+	}
+	return nil
+}
+
+// applyMigration runs the given SQL and records the resulting version
+// in a savepoint of the migration run, which is rolled back should
+// either fail. A zero version means no migration is applied.
+func applyMigration(ctx context.Context, conn *sql.Conn, stmt string, version int) error {
+	if _, err := conn.ExecContext(ctx, savepointQuery); err != nil {
+		return errors.Wrap(err, "creating savepoint")
+	}
+	if err := runMigration(ctx, conn, stmt, version); err != nil {
+		if _, rollbackErr := conn.ExecContext(ctx, rollbackToQuery); rollbackErr != nil {
+			return errors.Wrapf(err, "rolling back to savepoint: %v", rollbackErr)
+		}
+		return err
+	}
+	if _, err := conn.ExecContext(ctx, releaseQuery); err != nil {
+		return errors.Wrap(err, "releasing savepoint")
+	}
+	return nil
+}
+
+// runMigration runs the given SQL and records the resulting version.
+func runMigration(ctx context.Context, conn *sql.Conn, stmt string, version int) error {
+	if _, err := conn.ExecContext(ctx, stmt); err != nil {
+		return errors.Wrap(err, "running migration")
+	}
+	if _, err := conn.ExecContext(ctx, deleteVersionQuery); err != nil {
+		return errors.Wrap(err, "deleting migration version")
+	}
+	if version > 0 {
+		if _, err := conn.ExecContext(ctx, setVersionQuery, version); err != nil {
+			return errors.Wrap(err, "setting migration version")
+		}
+	}
+	return nil
+}
+
+// checkFts5 fails with an error naming the missing build tag should
+// SQLite lack FTS5, which go-sqlite3 only compiles in with the
+// sqlite_fts5 tag, rather than letting the migrations fail with
+// "no such module: fts5".
+func checkFts5(ctx context.Context, db *sql.DB) error {
+	var enabled bool
+	if err := db.QueryRowContext(ctx, fts5Query).Scan(&enabled); err != nil {
+		return errors.Wrap(err, "checking for FTS5")
+	}
+	if !enabled {
+		return errors.New("SQLite lacks the FTS5 extension needed by full-text search, build with -tags sqlite_fts5")
+	}
+	return nil
+}
+
+// driverName tells which of the supported drivers the database uses.
+func driverName(db *sql.DB) (string, error) {
+	switch db.Driver().(type) {
+	case *sqlite3.SQLiteDriver:
+		return SqliteDriver, nil
+	case *pq.Driver:
+		return PostgresDriver, nil
+	}
+	return "", errors.Errorf("unsupported database driver %T", db.Driver())
+}
+
+// loadMigrations reads the migrations in dir, named after golang-migrate's
+// convention, such as 0001_create_books_table.up.sql, sorted by version.
+func loadMigrations(fsys fs.FS, dir string) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading directory %s", dir)
+	}
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, ok := strings.Cut(name, "_")
+		if !ok || entry.IsDir() || !strings.HasSuffix(name, ".sql") {
+			continue
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parsing version of %s", name)
+		}
+		content, err := fs.ReadFile(fsys, path.Join(dir, name))
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", name)
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version}
+			byVersion[version] = m
+		}
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			m.up = string(content)
+		case strings.HasSuffix(name, ".down.sql"):
+			m.down = string(content)
+		}
+	}
+	migrations := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
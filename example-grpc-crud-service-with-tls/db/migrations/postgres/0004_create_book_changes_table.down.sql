@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS book_changes_after_change ON books;
+DROP FUNCTION IF EXISTS record_book_change();
+DROP TABLE IF EXISTS book_changes;
\ No newline at end of file
@@ -0,0 +1,29 @@
+CREATE TABLE IF NOT EXISTS book_changes (
+    sequence BIGSERIAL PRIMARY KEY,
+    operation TEXT NOT NULL,
+    book_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    pages INTEGER NOT NULL,
+    version INTEGER NOT NULL
+);
+
+-- Sequences are handed out on insert, not on commit, so the transactions
+-- changing books take an advisory lock first, which makes them take turns
+-- and changes become visible in sequence order.
+CREATE OR REPLACE FUNCTION record_book_change() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP = 'DELETE' THEN
+        INSERT INTO book_changes (operation, book_id, title, author, pages, version)
+        VALUES ('delete', OLD.id, OLD.title, OLD.author, OLD.pages, OLD.version);
+        RETURN OLD;
+    END IF;
+    INSERT INTO book_changes (operation, book_id, title, author, pages, version)
+    VALUES (CASE TG_OP WHEN 'INSERT' THEN 'create' ELSE 'update' END, NEW.id, NEW.title, NEW.author, NEW.pages, NEW.version);
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS book_changes_after_change ON books;
+CREATE TRIGGER book_changes_after_change AFTER INSERT OR UPDATE OR DELETE ON books
+    FOR EACH ROW EXECUTE FUNCTION record_book_change();
\ No newline at end of file
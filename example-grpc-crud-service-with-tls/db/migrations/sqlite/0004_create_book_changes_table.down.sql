@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS book_changes_after_insert;
+DROP TRIGGER IF EXISTS book_changes_after_update;
+DROP TRIGGER IF EXISTS book_changes_after_delete;
+DROP TABLE IF EXISTS book_changes;
\ No newline at end of file
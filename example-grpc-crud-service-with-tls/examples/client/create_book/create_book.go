@@ -13,6 +13,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/examples/client/details"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
@@ -69,6 +70,7 @@ func run() error {
 func main() {
 	if err := run(); err != nil {
 		fmt.Println(err)
+		details.Print(err)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,29 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+// Package details prints the machine-readable details that the server
+// attaches to its errors.
+package details
+
+import (
+	"fmt"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+)
+
+// Print prints the details of err, if any: the field violations of a
+// google.rpc.BadRequest and the reason and metadata of a google.rpc.ErrorInfo.
+func Print(err error) {
+	for _, detail := range status.Convert(err).Details() {
+		switch d := detail.(type) {
+		case *errdetails.BadRequest:
+			for _, violation := range d.GetFieldViolations() {
+				fmt.Printf("  invalid field %s: %s\n", violation.GetField(), violation.GetDescription())
+			}
+		case *errdetails.ErrorInfo:
+			fmt.Printf("  reason: %s, domain: %s, metadata: %v\n", d.GetReason(), d.GetDomain(), d.GetMetadata())
+		}
+	}
+}
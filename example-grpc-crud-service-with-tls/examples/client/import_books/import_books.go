@@ -0,0 +1,87 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/examples/client/details"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+func run() error {
+	ctx := context.Background()
+	const serverHost = "localhost:4444"
+
+	// Load certificate of the CA who signed server's certificate
+	pemServerCA, err := os.ReadFile("cert/ca-cert.pem")
+	if err != nil {
+		return errors.Wrap(err, "loading CA's certificate")
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemServerCA) {
+		return errors.New("failed to add server CA's certificate")
+	}
+
+	// Load client's certificate and private key
+	clientCert, err := tls.LoadX509KeyPair("cert/client-cert.pem", "cert/client-key.pem")
+	if err != nil {
+		return errors.Wrap(err, "loading client's certificate and private key")
+	}
+
+	// Create the credentials and return it
+	config := &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      certPool,
+	}
+	conn, err := grpc.DialContext(ctx, serverHost, grpc.WithBlock(), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		return errors.Wrap(err, "dialing")
+	}
+
+	// Create the client
+	client := book.NewBookServiceClient(conn)
+
+	stream, err := client.ImportBooks(ctx)
+	if err != nil {
+		return errors.Wrap(err, "importing books")
+	}
+	for i := 1; i <= 1000; i++ {
+		newBook := &book.Book{
+			Title:  fmt.Sprintf("title %d", i),
+			Author: "author",
+			Pages:  100,
+		}
+		// The server stopped receiving: the reason comes with the reply.
+		if err := stream.Send(&book.CreateBookRequest{Book: newBook}); err == io.EOF {
+			break
+		} else if err != nil {
+			return errors.Wrap(err, "sending book")
+		}
+	}
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		return errors.Wrap(err, "importing books")
+	}
+	fmt.Printf("import summary: %v\n", summary)
+
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Println(err)
+		details.Print(err)
+		os.Exit(1)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/examples/client/details"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
 )
@@ -50,6 +51,12 @@ func run() error {
 	// Create the client
 	client := book.NewBookServiceClient(conn)
 
+	// The update is based on the current version of the book, so that it
+	// fails with ABORTED should someone else change the book meanwhile.
+	currentBook, err := client.GetBook(ctx, &book.GetBookRequest{Id: 1})
+	if err != nil {
+		return errors.Wrap(err, "getting book")
+	}
 	bookToBeUpdated := &book.Book{
 		Id:     1,
 		Title:  "new title",
@@ -57,7 +64,8 @@ func run() error {
 		Pages:  150,
 	}
 	updatedBook, err := client.UpdateBook(ctx, &book.UpdateBookRequest{
-		Book: bookToBeUpdated,
+		Book:            bookToBeUpdated,
+		ExpectedVersion: currentBook.GetVersion(),
 	})
 	if err != nil {
 		return errors.Wrap(err, "updating book")
@@ -70,6 +78,7 @@ func run() error {
 func main() {
 	if err := run(); err != nil {
 		fmt.Println(err)
+		details.Print(err)
 		os.Exit(1)
 	}
 }
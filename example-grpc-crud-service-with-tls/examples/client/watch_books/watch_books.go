@@ -0,0 +1,91 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/examples/client/details"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+func run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	const serverHost = "localhost:4444"
+
+	// Load certificate of the CA who signed server's certificate
+	pemServerCA, err := os.ReadFile("cert/ca-cert.pem")
+	if err != nil {
+		return errors.Wrap(err, "loading CA's certificate")
+	}
+	certPool := x509.NewCertPool()
+	if !certPool.AppendCertsFromPEM(pemServerCA) {
+		return errors.New("failed to add server CA's certificate")
+	}
+
+	// Load client's certificate and private key
+	clientCert, err := tls.LoadX509KeyPair("cert/client-cert.pem", "cert/client-key.pem")
+	if err != nil {
+		return errors.Wrap(err, "loading client's certificate and private key")
+	}
+
+	// Create the credentials and return it
+	config := &tls.Config{
+		Certificates: []tls.Certificate{clientCert},
+		RootCAs:      certPool,
+	}
+	conn, err := grpc.DialContext(ctx, serverHost, grpc.WithBlock(), grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		return errors.Wrap(err, "dialing")
+	}
+
+	// Create the client
+	client := book.NewBookServiceClient(conn)
+
+	// Resume after the sequence given as argument, if any
+	var afterSequence int64
+	if len(os.Args) > 1 {
+		afterSequence, err = strconv.ParseInt(os.Args[1], 10, 64)
+		if err != nil {
+			return errors.Wrap(err, "parsing sequence")
+		}
+	}
+	stream, err := client.WatchBooks(ctx, &book.WatchBooksRequest{AfterSequence: afterSequence})
+	if err != nil {
+		return errors.Wrap(err, "watching books")
+	}
+	for {
+		change, err := stream.Recv()
+		if status.Code(err) == codes.Canceled {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "receiving change")
+		}
+		fmt.Printf("%v\n", change)
+	}
+
+	return nil
+}
+
+func main() {
+	if err := run(); err != nil {
+		fmt.Println(err)
+		details.Print(err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	pkgErrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestUnaryErrors(t *testing.T) {
+	testCases := []struct {
+		name            string
+		handlerErr      error
+		verbose         bool
+		expectedCode    codes.Code
+		expectedMessage string
+		expectedLog     string
+		expectedDetails []proto.Message
+	}{
+		{
+			name:         "no error",
+			expectedCode: codes.OK,
+		},
+		{
+			name:            "status error",
+			handlerErr:      status.Error(codes.NotFound, "no book with id 1 found"),
+			expectedCode:    codes.NotFound,
+			expectedMessage: "no book with id 1 found",
+		},
+		{
+			name:            "canceled",
+			handlerErr:      pkgErrors.Wrap(context.Canceled, "getting book with id 1"),
+			expectedCode:    codes.Canceled,
+			expectedMessage: "getting book with id 1: context canceled",
+		},
+		{
+			name:            "internal error",
+			handlerErr:      pkgErrors.Wrap(errors.New("database is locked"), "getting book with id 1"),
+			expectedCode:    codes.Internal,
+			expectedMessage: "internal error",
+			expectedLog:     `level=ERROR msg="internal error" method=/books.BookService/GetBook incident=0123456789abcdef err="database is locked\ngetting book with id 1\n`,
+			expectedDetails: []proto.Message{
+				&errdetails.ErrorInfo{
+					Reason:   "INTERNAL",
+					Domain:   "books.BookService",
+					Metadata: map[string]string{"incident": "0123456789abcdef"},
+				},
+			},
+		},
+		{
+			name:            "verbose internal error",
+			handlerErr:      pkgErrors.Wrap(errors.New("database is locked"), "getting book with id 1"),
+			verbose:         true,
+			expectedCode:    codes.Internal,
+			expectedMessage: "getting book with id 1: database is locked",
+			expectedLog:     `level=ERROR msg="internal error" method=/books.BookService/GetBook incident=0123456789abcdef err="database is locked\ngetting book with id 1\n`,
+			expectedDetails: []proto.Message{
+				&errdetails.ErrorInfo{
+					Reason:   "INTERNAL",
+					Domain:   "books.BookService",
+					Metadata: map[string]string{"incident": "0123456789abcdef"},
+				},
+			},
+		},
+	}
+	newIncidentId := func() string {
+		return "0123456789abcdef"
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			interceptor := unaryErrors(newLogger(&out), tc.verbose, newIncidentId)
+			info := &grpc.UnaryServerInfo{FullMethod: "/books.BookService/GetBook"}
+			resp, err := interceptor(context.TODO(), "request", info, func(ctx context.Context, req any) (any, error) {
+				return "response", tc.handlerErr
+			})
+			require.Equal(t, "response", resp)
+			st := status.Convert(err)
+			require.Equal(t, tc.expectedCode, st.Code())
+			require.Equal(t, tc.expectedMessage, st.Message())
+			// The error is logged along with its stack trace.
+			require.True(t, strings.HasPrefix(out.String(), tc.expectedLog), out.String())
+			details := st.Details()
+			require.Len(t, details, len(tc.expectedDetails))
+			for i, expected := range tc.expectedDetails {
+				require.True(t, proto.Equal(expected, details[i].(proto.Message)), "%v", details[i])
+			}
+		})
+	}
+}
+
+func TestStreamErrors(t *testing.T) {
+	var out bytes.Buffer
+	interceptor := StreamErrors(newLogger(&out), false)
+	info := &grpc.StreamServerInfo{FullMethod: "/books.BookService/StreamBooks", IsServerStream: true}
+	err := interceptor(nil, &mockServerStream{ctx: context.TODO()}, info, func(srv any, stream grpc.ServerStream) error {
+		return errors.New("streaming books: database is locked")
+	})
+	require.Equal(t, "rpc error: code = Internal desc = internal error", err.Error())
+	require.Contains(t, out.String(), `level=ERROR msg="internal error" method=/books.BookService/StreamBooks incident=`)
+	require.Contains(t, out.String(), `err="streaming books: database is locked"`)
+}
+
+func TestNewIncidentId(t *testing.T) {
+	id := newIncidentId()
+	require.Len(t, id, 16)
+	require.NotEqual(t, id, newIncidentId())
+}
@@ -0,0 +1,232 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package interceptors
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/api/proto/gen/book"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// mockServerStream is a grpc.ServerStream carrying the given context.
+type mockServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (m *mockServerStream) Context() context.Context {
+	return m.ctx
+}
+
+// peerContext returns a context holding the address of a client.
+func peerContext() context.Context {
+	return peer.NewContext(context.TODO(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 50000},
+	})
+}
+
+// newLogger returns a text logger writing to out without the time,
+// so that its entries are predictable.
+func newLogger(out io.Writer) *slog.Logger {
+	return slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey && len(groups) == 0 {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+}
+
+func TestUnaryLogger(t *testing.T) {
+	testCases := []struct {
+		name           string
+		ctx            context.Context
+		handlerErr     error
+		expectedOutput string
+		expectedSuffix string
+	}{
+		{
+			name:           "happy path",
+			ctx:            peerContext(),
+			expectedOutput: `level=INFO msg="rpc completed" method=/books.BookService/GetBook code=OK duration=`,
+			expectedSuffix: "peer=127.0.0.1:50000 bookid=1\n",
+		},
+		{
+			name:           "client error",
+			ctx:            peerContext(),
+			handlerErr:     status.Error(codes.NotFound, "no book with id 1 found"),
+			expectedOutput: `level=INFO msg="rpc completed" method=/books.BookService/GetBook code=NotFound duration=`,
+			expectedSuffix: `peer=127.0.0.1:50000 err="no book with id 1 found" bookid=1` + "\n",
+		},
+		{
+			name:           "server error",
+			ctx:            peerContext(),
+			handlerErr:     status.Error(codes.Internal, "getting book with id 1: db error"),
+			expectedOutput: `level=ERROR msg="rpc completed" method=/books.BookService/GetBook code=Internal duration=`,
+			expectedSuffix: `peer=127.0.0.1:50000 err="getting book with id 1: db error" bookid=1` + "\n",
+		},
+		{
+			name:           "no peer",
+			ctx:            context.TODO(),
+			expectedOutput: `level=INFO msg="rpc completed" method=/books.BookService/GetBook code=OK duration=`,
+			expectedSuffix: "peer=unknown bookid=1\n",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			interceptor := UnaryLogger(newLogger(&out))
+			info := &grpc.UnaryServerInfo{FullMethod: "/books.BookService/GetBook"}
+			resp, err := interceptor(tc.ctx, &book.GetBookRequest{Id: 1}, info, func(ctx context.Context, req any) (any, error) {
+				return "response", tc.handlerErr
+			})
+			require.Equal(t, tc.handlerErr, err)
+			require.Equal(t, "response", resp)
+			require.True(t, strings.HasPrefix(out.String(), tc.expectedOutput), out.String())
+			require.True(t, strings.HasSuffix(out.String(), tc.expectedSuffix), out.String())
+		})
+	}
+}
+
+func TestBookId(t *testing.T) {
+	testCases := []struct {
+		name       string
+		req        any
+		resp       any
+		expectedId int32
+		expectedOk bool
+	}{
+		{
+			name:       "id in request",
+			req:        &book.DeleteBookRequest{Id: 2},
+			resp:       &book.DeleteBookResponse{Id: 2},
+			expectedId: 2,
+			expectedOk: true,
+		},
+		{
+			name:       "book in request",
+			req:        &book.UpdateBookRequest{Book: &book.Book{Id: 3}},
+			expectedId: 3,
+			expectedOk: true,
+		},
+		{
+			name:       "book in response",
+			req:        &book.CreateBookRequest{Book: &book.Book{Title: "some title"}},
+			resp:       &book.Book{Id: 4, Title: "some title"},
+			expectedId: 4,
+			expectedOk: true,
+		},
+		{
+			name: "failed creation",
+			req:  &book.CreateBookRequest{Book: &book.Book{Title: "some title"}},
+			resp: (*book.Book)(nil),
+		},
+		{
+			name: "no book",
+			req:  &book.GetAllBooksRequest{},
+			resp: &book.GetAllBooksResponse{},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			id, ok := bookId(tc.req, tc.resp)
+			require.Equal(t, tc.expectedId, id)
+			require.Equal(t, tc.expectedOk, ok)
+		})
+	}
+}
+
+func TestStreamLogger(t *testing.T) {
+	var out bytes.Buffer
+	interceptor := StreamLogger(newLogger(&out))
+	info := &grpc.StreamServerInfo{FullMethod: "/books.BookService/StreamBooks", IsServerStream: true}
+	err := interceptor(nil, &mockServerStream{ctx: peerContext()}, info, func(srv any, stream grpc.ServerStream) error {
+		return status.Error(codes.Canceled, "context canceled")
+	})
+	require.Equal(t, codes.Canceled, status.Code(err))
+	require.True(t, strings.HasPrefix(out.String(), `level=INFO msg="rpc completed" method=/books.BookService/StreamBooks code=Canceled duration=`), out.String())
+	require.True(t, strings.HasSuffix(out.String(), `peer=127.0.0.1:50000 err="context canceled"`+"\n"), out.String())
+}
+
+func TestUnaryRecovery(t *testing.T) {
+	testCases := []struct {
+		name          string
+		handler       grpc.UnaryHandler
+		expectedLog   string
+		expectedError error
+	}{
+		{
+			name: "happy path",
+			handler: func(ctx context.Context, req any) (any, error) {
+				return "response", nil
+			},
+		},
+		{
+			name: "error",
+			handler: func(ctx context.Context, req any) (any, error) {
+				return nil, errors.New("handler error")
+			},
+			expectedError: errors.New("handler error"),
+		},
+		{
+			name: "panic",
+			handler: func(ctx context.Context, req any) (any, error) {
+				panic("handler panic")
+			},
+			expectedLog:   `level=ERROR msg="panic recovered" method=/books.BookService/GetBook panic="handler panic" stack=`,
+			expectedError: errors.New("rpc error: code = Internal desc = internal error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			interceptor := UnaryRecovery(newLogger(&out))
+			info := &grpc.UnaryServerInfo{FullMethod: "/books.BookService/GetBook"}
+			resp, err := interceptor(context.TODO(), "request", info, tc.handler)
+			require.True(t, strings.HasPrefix(out.String(), tc.expectedLog), out.String())
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, "response", resp)
+			}
+		})
+	}
+}
+
+func TestStreamRecovery(t *testing.T) {
+	var out bytes.Buffer
+	interceptor := StreamRecovery(newLogger(&out))
+	info := &grpc.StreamServerInfo{FullMethod: "/books.BookService/StreamBooks", IsServerStream: true}
+	err := interceptor(nil, &mockServerStream{ctx: context.TODO()}, info, func(srv any, stream grpc.ServerStream) error {
+		panic("handler panic")
+	})
+	require.Equal(t, "rpc error: code = Internal desc = internal error", err.Error())
+	require.True(t, strings.HasPrefix(out.String(), `level=ERROR msg="panic recovered" method=/books.BookService/StreamBooks panic="handler panic" stack=`), out.String())
+}
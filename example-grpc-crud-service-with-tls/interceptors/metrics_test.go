@@ -0,0 +1,106 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package interceptors
+
+import (
+	"context"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestUnaryMetrics(t *testing.T) {
+	m := NewRpcMetrics(prometheus.NewRegistry())
+	interceptor := UnaryMetrics(m)
+	info := &grpc.UnaryServerInfo{FullMethod: "/books.BookService/GetBook"}
+	handler := func(ctx context.Context, req any) (any, error) {
+		inFlight := testutil.ToFloat64(m.inFlight.WithLabelValues("books.BookService", "GetBook", "unary"))
+		require.Equal(t, float64(1), inFlight)
+		if req == "missing" {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		return "response", nil
+	}
+	for _, req := range []string{"found", "found", "missing"} {
+		interceptor(context.TODO(), req, info, handler)
+	}
+	require.Equal(t, float64(2), testutil.ToFloat64(m.handled.WithLabelValues("books.BookService", "GetBook", "unary", "OK")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.handled.WithLabelValues("books.BookService", "GetBook", "unary", "NotFound")))
+	require.Equal(t, 2, testutil.CollectAndCount(m.duration))
+	require.Equal(t, float64(0), testutil.ToFloat64(m.inFlight.WithLabelValues("books.BookService", "GetBook", "unary")))
+}
+
+func TestStreamMetrics(t *testing.T) {
+	testCases := []struct {
+		name         string
+		info         *grpc.StreamServerInfo
+		expectedType string
+	}{
+		{
+			name:         "server stream",
+			info:         &grpc.StreamServerInfo{FullMethod: "/books.BookService/StreamBooks", IsServerStream: true},
+			expectedType: "server_stream",
+		},
+		{
+			name:         "client stream",
+			info:         &grpc.StreamServerInfo{FullMethod: "/books.BookService/StreamBooks", IsClientStream: true},
+			expectedType: "client_stream",
+		},
+		{
+			name:         "bidi stream",
+			info:         &grpc.StreamServerInfo{FullMethod: "/books.BookService/StreamBooks", IsClientStream: true, IsServerStream: true},
+			expectedType: "bidi_stream",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			m := NewRpcMetrics(prometheus.NewRegistry())
+			interceptor := StreamMetrics(m)
+			err := interceptor(nil, &mockServerStream{ctx: context.TODO()}, tc.info, func(srv any, stream grpc.ServerStream) error {
+				return nil
+			})
+			require.NoError(t, err)
+			require.Equal(t, float64(1), testutil.ToFloat64(m.handled.WithLabelValues("books.BookService", "StreamBooks", tc.expectedType, "OK")))
+		})
+	}
+}
+
+func TestSplitMethod(t *testing.T) {
+	testCases := []struct {
+		name            string
+		fullMethod      string
+		expectedService string
+		expectedMethod  string
+	}{
+		{
+			name:            "happy path",
+			fullMethod:      "/books.BookService/GetBook",
+			expectedService: "books.BookService",
+			expectedMethod:  "GetBook",
+		},
+		{
+			name:            "malformed",
+			fullMethod:      "GetBook",
+			expectedService: "unknown",
+			expectedMethod:  "unknown",
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			service, method := splitMethod(tc.fullMethod)
+			require.Equal(t, tc.expectedService, service)
+			require.Equal(t, tc.expectedMethod, method)
+		})
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+// Package logging creates the structured logger used by the gRPC server.
+package logging
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/pkg/errors"
+)
+
+// Output formats.
+const (
+	TextFormat = "text"
+	JsonFormat = "json"
+)
+
+// Levels.
+const (
+	DebugLevel = "debug"
+	InfoLevel  = "info"
+	WarnLevel  = "warn"
+	ErrorLevel = "error"
+)
+
+// levels maps the level names to the slog levels.
+var levels = map[string]slog.Level{
+	DebugLevel: slog.LevelDebug,
+	InfoLevel:  slog.LevelInfo,
+	WarnLevel:  slog.LevelWarn,
+	ErrorLevel: slog.LevelError,
+}
+
+// New creates a logger that writes the entries of the given level,
+// or above, to w, either as text or JSON.
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	lvl, ok := levels[level]
+	if !ok {
+		return nil, errors.Errorf("unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch format {
+	case TextFormat:
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case JsonFormat:
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+	return nil, errors.Errorf("unknown log format %q", format)
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/api/proto/gen/book"
+	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/db/books/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestErrorDetails(t *testing.T) {
+	testCases := []struct {
+		name            string
+		err             error
+		expectedCode    codes.Code
+		expectedMessage string
+		expectedDetails []proto.Message
+	}{
+		{
+			name: "field errors",
+			err: invalidArgumentError(validate.FieldErrors{
+				{Field: "title", Error: "title is a required field"},
+				{Field: "pages", Error: "pages must be 1 or greater"},
+			}, "book"),
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "the request has invalid fields",
+			expectedDetails: []proto.Message{
+				&errdetails.BadRequest{
+					FieldViolations: []*errdetails.BadRequest_FieldViolation{
+						{Field: "book.title", Description: "title is a required field"},
+						{Field: "book.pages", Description: "pages must be 1 or greater"},
+					},
+				},
+			},
+		},
+		{
+			name: "field errors without parent",
+			err: invalidArgumentError(validate.FieldErrors{
+				{Field: "query", Error: "query is a required field"},
+			}, ""),
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "the request has invalid fields",
+			expectedDetails: []proto.Message{
+				&errdetails.BadRequest{
+					FieldViolations: []*errdetails.BadRequest_FieldViolation{
+						{Field: "query", Description: "query is a required field"},
+					},
+				},
+			},
+		},
+		{
+			name:            "other invalid argument",
+			err:             invalidArgumentError(errors.New("invalid argument"), "book"),
+			expectedCode:    codes.InvalidArgument,
+			expectedMessage: "invalid argument",
+		},
+		{
+			name:            "not found",
+			err:             notFoundError(&bookErrors.ErrBookNotFound{Id: 1}),
+			expectedCode:    codes.NotFound,
+			expectedMessage: "no book with id 1 found",
+			expectedDetails: []proto.Message{
+				&errdetails.ErrorInfo{
+					Reason:   "BOOK_NOT_FOUND",
+					Domain:   "books.BookService",
+					Metadata: map[string]string{"id": "1"},
+				},
+			},
+		},
+		{
+			name:            "already exists",
+			err:             alreadyExistsError(&bookErrors.ErrDuplicateBook{Title: "title", Author: "author"}),
+			expectedCode:    codes.AlreadyExists,
+			expectedMessage: `book with title "title" from author "author" already exists`,
+			expectedDetails: []proto.Message{
+				&errdetails.ErrorInfo{
+					Reason:   "DUPLICATE_BOOK",
+					Domain:   "books.BookService",
+					Metadata: map[string]string{"title": "title", "author": "author"},
+				},
+			},
+		},
+		{
+			name:            "aborted",
+			err:             abortedError(&bookErrors.ErrVersionMismatch{Id: 1, Version: 3, Expected: 2}),
+			expectedCode:    codes.Aborted,
+			expectedMessage: "book with id 1 is at version 3, not 2",
+			expectedDetails: []proto.Message{
+				&errdetails.ErrorInfo{
+					Reason:   "VERSION_MISMATCH",
+					Domain:   "books.BookService",
+					Metadata: map[string]string{"id": "1", "version": "3", "expected_version": "2"},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			st := status.Convert(tc.err)
+			require.Equal(t, tc.expectedCode, st.Code())
+			require.Equal(t, tc.expectedMessage, st.Message())
+			details := st.Details()
+			require.Len(t, details, len(tc.expectedDetails))
+			for i, expected := range tc.expectedDetails {
+				require.True(t, proto.Equal(expected, details[i].(proto.Message)), "%v", details[i])
+			}
+		})
+	}
+}
+
+func TestErrorDetailsSentToClient(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := newBufconnClient(t, newServer(logger, &mockStore{}, newRpcMetrics(), testImportBatchSize, false, false))
+	_, err := client.CreateBook(context.TODO(), &book.CreateBookRequest{
+		Book: &book.Book{Title: "title", Author: "author"},
+	})
+	st := status.Convert(err)
+	require.Equal(t, codes.InvalidArgument, st.Code())
+	require.Len(t, st.Details(), 1)
+	badRequest, ok := st.Details()[0].(*errdetails.BadRequest)
+	require.True(t, ok, "%T", st.Details()[0])
+	require.Len(t, badRequest.FieldViolations, 1)
+	require.Equal(t, "book.pages", badRequest.FieldViolations[0].Field)
+	require.Equal(t, "pages is a required field", badRequest.FieldViolations[0].Description)
+}
@@ -6,66 +6,183 @@ package server
 
 import (
 	"context"
-	"database/sql"
-	"log"
+	"fmt"
+	"io"
+	"log/slog"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/api/proto/gen/book"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/db/books"
 	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/db/books/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/db/books/models"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/interceptors"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/mapper"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/tlscreds"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service-with-tls/validate"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
 )
 
-// For ease of unit testing.
-var (
-	tlsCreds = tlscreds.New
+// tlsCredsFunc loads TLS credentials from the given files, as
+// tlscreds.New does.
+type tlsCredsFunc func(files tlscreds.Files) (credentials.TransportCredentials, error)
 
-	listBooks   = books.List
-	getBookById = books.GetById
-	createBook  = books.Create
-	updateBook  = books.Update
-	deleteBook  = books.DeleteById
-)
+// maxBatchSize is the maximum number of items of a batch.
+const maxBatchSize = 1000
+
+// healthCheckInterval is how often MonitorHealth pings the database.
+const healthCheckInterval = 5 * time.Second
 
 // server implements BookServiceServer.
 type server struct {
 	book.UnimplementedBookServiceServer
 	GrpcSrv *grpc.Server
 
-	logger *log.Logger
-	db     *sql.DB
+	health          *health.Server
+	logger          *slog.Logger
+	store           books.BookStore
+	importBatchSize int
+
+	// stopping is canceled on shutdown, ending the WatchBooks streams,
+	// which would otherwise only end when their clients leave.
+	stopping     context.Context
+	stopWatching context.CancelFunc
 }
 
 // New creates and returns a new server instance.
-// It initializes the gRPC server and registers the BookService.
-func New(logger *log.Logger, db *sql.DB) (*server, error) {
-	creds, err := tlsCreds()
+// It initializes the gRPC server with the TLS credentials found in the
+// given files and registers the BookService along with the standard
+// health service and, if enabled, server reflection. Every RPC is
+// traced, continuing the trace propagated by the client, if any, and
+// goes through the logging, metrics and panic recovery interceptors.
+// Unexpected errors are logged and reported to the client as internal
+// errors identified by an incident id, along with their text only if
+// verboseErrors is set. ImportBooks inserts importBatchSize books per
+// transaction.
+func New(logger *slog.Logger, store books.BookStore, metrics *interceptors.RpcMetrics, tlsFiles tlscreds.Files, importBatchSize int, enableReflection, verboseErrors bool) (*server, error) {
+	return newTlsServer(tlscreds.New, logger, store, metrics, tlsFiles, importBatchSize, enableReflection, verboseErrors)
+}
+
+// newTlsServer is New with the function loading the TLS credentials
+// given.
+func newTlsServer(tlsCreds tlsCredsFunc, logger *slog.Logger, store books.BookStore, metrics *interceptors.RpcMetrics, tlsFiles tlscreds.Files, importBatchSize int, enableReflection, verboseErrors bool) (*server, error) {
+	creds, err := tlsCreds(tlsFiles)
 	if err != nil {
 		return nil, errors.Wrap(err, "loading TLS creds")
 	}
-	opts := []grpc.ServerOption{grpc.Creds(creds)}
+	return newServer(logger, store, metrics, importBatchSize, enableReflection, verboseErrors, grpc.Creds(creds)), nil
+}
+
+// newServer creates the gRPC server with the given options and
+// registers the services.
+func newServer(logger *slog.Logger, store books.BookStore, metrics *interceptors.RpcMetrics, importBatchSize int, enableReflection, verboseErrors bool, opts ...grpc.ServerOption) *server {
+	opts = append(opts,
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(
+			interceptors.UnaryLogger(logger),
+			interceptors.UnaryMetrics(metrics),
+			interceptors.UnaryErrors(logger, verboseErrors),
+			interceptors.UnaryRecovery(logger),
+		),
+		grpc.ChainStreamInterceptor(
+			interceptors.StreamLogger(logger),
+			interceptors.StreamMetrics(metrics),
+			interceptors.StreamErrors(logger, verboseErrors),
+			interceptors.StreamRecovery(logger),
+		),
+	)
 	grpServer := grpc.NewServer(opts...)
+	stopping, stopWatching := context.WithCancel(context.Background())
 	srv := &server{
-		GrpcSrv: grpServer,
-		logger:  logger,
-		db:      db,
+		GrpcSrv:         grpServer,
+		health:          health.NewServer(),
+		logger:          logger,
+		store:           store,
+		importBatchSize: importBatchSize,
+		stopping:        stopping,
+		stopWatching:    stopWatching,
 	}
 	book.RegisterBookServiceServer(grpServer, srv)
-	return srv, nil
+	healthpb.RegisterHealthServer(grpServer, srv.health)
+	// Nothing is served until the database is known to be reachable.
+	srv.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+	if enableReflection {
+		reflection.Register(grpServer)
+	}
+	return srv
+}
+
+// MonitorHealth pings the database right away and then periodically,
+// until ctx is done, reporting the BookService, and the server as a
+// whole, as SERVING only while the database is reachable.
+func (s *server) MonitorHealth(ctx context.Context) {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		s.checkHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth updates the health status after pinging the database.
+func (s *server) checkHealth(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, healthCheckInterval)
+	defer cancel()
+	if err := s.store.Ping(ctx); err != nil {
+		s.logger.WarnContext(ctx, "health check failed", slog.Any("err", err))
+		s.setServingStatus(healthpb.HealthCheckResponse_NOT_SERVING)
+		return
+	}
+	s.setServingStatus(healthpb.HealthCheckResponse_SERVING)
+}
+
+// setServingStatus sets the status of both the BookService and the
+// server as a whole, which is the one checked by default.
+func (s *server) setServingStatus(status healthpb.HealthCheckResponse_ServingStatus) {
+	s.health.SetServingStatus("", status)
+	s.health.SetServingStatus(book.BookService_ServiceDesc.ServiceName, status)
+}
+
+// Shutdown stops the server gracefully. It first reports NOT_SERVING
+// through the health service, so that clients and load balancers stop
+// sending new calls, and ends the WatchBooks streams, then waits for
+// the in-flight RPCs to complete. If ctx is done before that, the
+// remaining RPCs are canceled and ctx's error is returned.
+func (s *server) Shutdown(ctx context.Context) error {
+	s.health.Shutdown()
+	s.stopWatching()
+	stopped := make(chan struct{})
+	go func() {
+		s.GrpcSrv.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		s.GrpcSrv.Stop()
+		<-stopped
+		return ctx.Err()
+	}
 }
 
 // GetAllBooks handles the GetAllBooks gRPC call.
 // It retrieves all books from the database and returns them.
 func (s *server) GetAllBooks(ctx context.Context, in *book.GetAllBooksRequest) (*book.GetAllBooksResponse, error) {
-	books, err := listBooks(ctx, s.db)
+	books, err := s.store.List(ctx)
 	if err != nil {
-		s.logger.Println(err)
-		return nil, status.Error(codes.Internal, errors.Wrap(err, "getting all books").Error())
+		return nil, errors.Wrap(err, "getting all books")
 	}
 	return &book.GetAllBooksResponse{
 		Books: mapper.BookProtoList(books),
@@ -75,15 +192,13 @@ func (s *server) GetAllBooks(ctx context.Context, in *book.GetAllBooksRequest) (
 // GetBook handles the GetBook gRPC call.
 // It retrieves a single book by its ID and returns it.
 func (s *server) GetBook(ctx context.Context, in *book.GetBookRequest) (*book.Book, error) {
-	book, err := getBookById(ctx, s.db, int(in.GetId()))
+	book, err := s.store.GetById(ctx, int(in.GetId()))
 	if err != nil {
 		var errNotFound *bookErrors.ErrBookNotFound
 		if errors.As(err, &errNotFound) {
-			s.logger.Println(err)
-			return nil, status.Error(codes.NotFound, errNotFound.Error())
+			return nil, notFoundError(errNotFound)
 		}
-		s.logger.Println(err)
-		return nil, status.Error(codes.Internal, errors.Wrapf(err, "getting book with id %d", in.GetId()).Error())
+		return nil, errors.Wrapf(err, "getting book with id %d", in.GetId())
 	}
 	return mapper.BookProto(book), nil
 }
@@ -93,53 +208,341 @@ func (s *server) GetBook(ctx context.Context, in *book.GetBookRequest) (*book.Bo
 func (s *server) CreateBook(ctx context.Context, in *book.CreateBookRequest) (*book.Book, error) {
 	newBook := mapper.NewBookDbModel(in.Book)
 	if err := validate.Check(newBook); err != nil {
-		s.logger.Printf("create book validation error: %v", err)
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, invalidArgumentError(err, "book")
 	}
-	createdBook, err := createBook(ctx, s.db, newBook)
+	createdBook, err := s.store.Create(ctx, newBook)
 	if err != nil {
 		var errDuplicateBook *bookErrors.ErrDuplicateBook
 		if errors.As(err, &errDuplicateBook) {
-			s.logger.Println(errDuplicateBook)
-			return nil, status.Error(codes.AlreadyExists, errDuplicateBook.Error())
+			return nil, alreadyExistsError(errDuplicateBook)
 		}
-		s.logger.Printf("error when creating book: %v", err)
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, err
 	}
 	in.Book.Id = int32(createdBook.Id)
+	in.Book.Version = int32(createdBook.Version)
 	return in.Book, nil
 }
 
 // UpdateBook handles the UpdateBook gRPC call.
-// It updates an existing book record in the database.
+// It updates an existing book record in the database, or only the
+// fields listed in the update mask, if any, provided that it is still
+// at the expected version, if any.
 func (s *server) UpdateBook(ctx context.Context, in *book.UpdateBookRequest) (*book.Book, error) {
-	updatedBook := mapper.UpdatedBookDbModel(in.Book)
+	if len(in.GetUpdateMask().GetPaths()) > 0 {
+		return s.patchBook(ctx, in)
+	}
+	updatedBook := mapper.UpdatedBookDbModel(in.Book, in.GetExpectedVersion())
 	if err := validate.Check(updatedBook); err != nil {
-		s.logger.Printf("update book validation error: %v", err)
-		return nil, status.Error(codes.InvalidArgument, err.Error())
+		return nil, invalidArgumentError(err, "book")
 	}
-	_, err := updateBook(ctx, s.db, updatedBook)
+	updatedBook, err := s.store.Update(ctx, updatedBook)
 	if err != nil {
-		var errBookNotFound *bookErrors.ErrBookNotFound
-		if errors.As(err, &errBookNotFound) {
-			s.logger.Println(errBookNotFound)
-			return nil, status.Error(codes.NotFound, errBookNotFound.Error())
-		}
-		s.logger.Printf("error when updating book: %v", err)
-		return nil, status.Error(codes.Internal, err.Error())
+		return nil, updateError(err)
 	}
+	in.Book.Version = int32(updatedBook.Version)
 	return in.Book, nil
 }
 
+// patchBook updates the fields of a book listed in the update mask
+// of an UpdateBook call, leaving the others as they are.
+func (s *server) patchBook(ctx context.Context, in *book.UpdateBookRequest) (*book.Book, error) {
+	if err := checkUpdateMask(in.GetUpdateMask().GetPaths()); err != nil {
+		return nil, invalidArgumentError(err, "update_mask")
+	}
+	bookPatch := mapper.BookPatchDbModel(in.GetBook(), in.GetUpdateMask().GetPaths(), in.GetExpectedVersion())
+	if err := validate.Check(bookPatch); err != nil {
+		return nil, invalidArgumentError(err, "book")
+	}
+	patchedBook, err := s.store.Patch(ctx, bookPatch)
+	if err != nil {
+		return nil, updateError(err)
+	}
+	return mapper.BookProto(patchedBook), nil
+}
+
+// updateError translates the errors of updating a book that are the
+// client's into their status, namely a missing book, a book that is not
+// at the expected version anymore or one that would duplicate another.
+func updateError(err error) error {
+	var (
+		errBookNotFound    *bookErrors.ErrBookNotFound
+		errVersionMismatch *bookErrors.ErrVersionMismatch
+		errDuplicateBook   *bookErrors.ErrDuplicateBook
+	)
+	switch {
+	case errors.As(err, &errBookNotFound):
+		return notFoundError(errBookNotFound)
+	case errors.As(err, &errDuplicateBook):
+		return alreadyExistsError(errDuplicateBook)
+	case errors.As(err, &errVersionMismatch):
+		return abortedError(errVersionMismatch)
+	}
+	return err
+}
+
+// maskableFields are the fields of a book an update mask may list.
+var maskableFields = map[string]bool{
+	"title":  true,
+	"author": true,
+	"pages":  true,
+}
+
+// checkUpdateMask ensures that every path of an update mask is a field
+// of a book that can be updated.
+func checkUpdateMask(paths []string) error {
+	var fieldErrors validate.FieldErrors
+	for _, path := range paths {
+		if !maskableFields[path] {
+			fieldErrors = append(fieldErrors, validate.FieldError{
+				Field: "paths",
+				Error: fmt.Sprintf("%q is not a field that can be updated", path),
+			})
+		}
+	}
+	if len(fieldErrors) > 0 {
+		return fieldErrors
+	}
+	return nil
+}
+
 // DeleteBook handles the DeleteBook gRPC call.
 // It deletes a book record from the database by its ID.
 func (s *server) DeleteBook(ctx context.Context, in *book.DeleteBookRequest) (*book.DeleteBookResponse, error) {
-	if err := deleteBook(ctx, s.db, int(in.GetId())); err != nil {
-		s.logger.Printf("error when deleting book with id %d: %v", in.GetId(), err)
-		return nil, status.Error(codes.Internal, err.Error())
-
+	if err := s.store.DeleteById(ctx, int(in.GetId())); err != nil {
+		return nil, err
 	}
 	return &book.DeleteBookResponse{
 		Id: in.GetId(),
 	}, nil
 }
+
+// StreamBooks handles the StreamBooks gRPC call.
+// It walks the books table and sends one book per message, stopping
+// as soon as the client cancels the call.
+func (s *server) StreamBooks(in *book.StreamBooksRequest, stream book.BookService_StreamBooksServer) error {
+	ctx := stream.Context()
+	err := s.store.Stream(ctx, func(b *models.Book) error {
+		return stream.Send(mapper.BookProto(b))
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return status.FromContextError(ctx.Err()).Err()
+		}
+		return errors.Wrap(err, "streaming books")
+	}
+	return nil
+}
+
+// WatchBooks handles the WatchBooks gRPC call.
+// It streams the changes made to books after the requested sequence,
+// then every new one as it is made, until the client cancels or the
+// server shuts down, in which case it fails with codes.Unavailable.
+func (s *server) WatchBooks(in *book.WatchBooksRequest, stream book.BookService_WatchBooksServer) error {
+	params := &models.WatchParams{AfterSequence: in.GetAfterSequence()}
+	if err := validate.Check(params); err != nil {
+		return invalidArgumentError(err, "")
+	}
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.stopping, cancel)
+	defer stop()
+	err := s.store.Watch(ctx, params.AfterSequence, func(c *models.Change) error {
+		return stream.Send(mapper.BookChangeProto(c))
+	})
+	if err != nil {
+		if streamErr := stream.Context().Err(); streamErr != nil {
+			return status.FromContextError(streamErr).Err()
+		}
+		if s.stopping.Err() != nil {
+			// The client is to resume from the last change it received.
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+		return errors.Wrap(err, "watching books")
+	}
+	return nil
+}
+
+// SearchBooks handles the SearchBooks gRPC call.
+// It performs a full-text search over book titles and authors.
+func (s *server) SearchBooks(ctx context.Context, in *book.SearchBooksRequest) (*book.SearchBooksResponse, error) {
+	params := &models.SearchParams{Query: in.GetQuery()}
+	if err := validate.Check(params); err != nil {
+		return nil, invalidArgumentError(err, "")
+	}
+	results, err := s.store.Search(ctx, params.Query)
+	if err != nil {
+		return nil, err
+	}
+	return &book.SearchBooksResponse{
+		Results: mapper.SearchResultProtoList(results),
+	}, nil
+}
+
+// BatchCreateBooks handles the BatchCreateBooks gRPC call.
+// It adds the given books within a single transaction.
+func (s *server) BatchCreateBooks(ctx context.Context, in *book.BatchCreateBooksRequest) (*book.BatchCreateBooksResponse, error) {
+	if err := checkBatchSize("books", len(in.GetBooks())); err != nil {
+		return nil, err
+	}
+	ops := make([]*models.BatchOperation, len(in.GetBooks()))
+	invalid := make([]error, len(in.GetBooks()))
+	for i, b := range in.GetBooks() {
+		newBook := mapper.NewBookDbModel(b)
+		if err := validate.Check(newBook); err != nil {
+			invalid[i] = invalidArgumentError(err, fmt.Sprintf("books[%d]", i))
+			continue
+		}
+		ops[i] = &models.BatchOperation{Create: newBook}
+	}
+	results, err := s.runBatch(ctx, ops, invalid, in.GetBestEffort())
+	if err != nil {
+		return nil, err
+	}
+	return &book.BatchCreateBooksResponse{Results: results}, nil
+}
+
+// BatchDeleteBooks handles the BatchDeleteBooks gRPC call.
+// It removes the books with the given IDs within a single transaction.
+// As with DeleteBook, removing a book that does not exist is not an error.
+func (s *server) BatchDeleteBooks(ctx context.Context, in *book.BatchDeleteBooksRequest) (*book.BatchDeleteBooksResponse, error) {
+	if err := checkBatchSize("ids", len(in.GetIds())); err != nil {
+		return nil, err
+	}
+	ops := make([]*models.BatchOperation, len(in.GetIds()))
+	invalid := make([]error, len(in.GetIds()))
+	for i, id := range in.GetIds() {
+		if id <= 0 {
+			invalid[i] = invalidArgumentError(validate.FieldErrors{
+				{Field: fmt.Sprintf("ids[%d]", i), Error: "id must be greater than 0"},
+			}, "")
+			continue
+		}
+		ops[i] = &models.BatchOperation{Delete: &models.DeletedBook{Id: int(id)}}
+	}
+	results, err := s.runBatch(ctx, ops, invalid, in.GetBestEffort())
+	if err != nil {
+		return nil, err
+	}
+	return &book.BatchDeleteBooksResponse{Results: results}, nil
+}
+
+// checkBatchSize checks that the repeated field of a batch request
+// holding its items has between 1 and maxBatchSize of them.
+func checkBatchSize(field string, size int) error {
+	var fieldError string
+	switch {
+	case size == 0:
+		fieldError = field + " must contain at least 1 item"
+	case size > maxBatchSize:
+		fieldError = fmt.Sprintf("%s must contain at most %d items", field, maxBatchSize)
+	default:
+		return nil
+	}
+	return invalidArgumentError(validate.FieldErrors{{Field: field, Error: fieldError}}, "")
+}
+
+// runBatch runs the operations of a batch, but the invalid ones, whose
+// errors are held at the same index, and returns the result of each item.
+// Unless bestEffort is set, the batch is only run if all of its items are
+// valid, and the items that did not fail are reported as rolled back if
+// any other did.
+func (s *server) runBatch(ctx context.Context, ops []*models.BatchOperation, invalid []error, bestEffort bool) ([]*book.BatchResult, error) {
+	results := make([]*book.BatchResult, len(ops))
+	var (
+		validOps []*models.BatchOperation
+		indexes  []int
+	)
+	failed := false
+	for i, err := range invalid {
+		if err != nil {
+			results[i] = &book.BatchResult{Index: int32(i), Status: status.Convert(err).Proto()}
+			failed = true
+			continue
+		}
+		validOps = append(validOps, ops[i])
+		indexes = append(indexes, i)
+	}
+	if len(validOps) > 0 && (bestEffort || !failed) {
+		dbResults, err := s.store.Batch(ctx, validOps, bestEffort)
+		if err != nil {
+			return nil, err
+		}
+		for j, dbResult := range dbResults {
+			i := indexes[j]
+			var errDuplicateBook *bookErrors.ErrDuplicateBook
+			if errors.As(dbResult.Err, &errDuplicateBook) {
+				results[i] = &book.BatchResult{Index: int32(i), Status: status.Convert(alreadyExistsError(errDuplicateBook)).Proto()}
+				failed = true
+				continue
+			}
+			result := &book.BatchResult{Index: int32(i), Status: status.New(codes.OK, "").Proto()}
+			if dbResult.Book != nil {
+				result.Book = mapper.BookProto(dbResult.Book)
+			}
+			results[i] = result
+		}
+	}
+	if failed && !bestEffort {
+		rolledBack := status.New(codes.Aborted, "rolled back as other items of the batch failed").Proto()
+		for i, result := range results {
+			if result == nil || result.Status.GetCode() == int32(codes.OK) {
+				results[i] = &book.BatchResult{Index: int32(i), Status: rolledBack}
+			}
+		}
+	}
+	return results, nil
+}
+
+// ImportBooks handles the ImportBooks gRPC call.
+// It adds the books streamed by the client in batches of importBatchSize,
+// each within its own transaction, so that the batches committed before
+// an error, or before the client cancels, are kept.
+func (s *server) ImportBooks(stream book.BookService_ImportBooksServer) error {
+	ctx := stream.Context()
+	summary := &book.ImportSummary{}
+	ops := make([]*models.BatchOperation, 0, s.importBatchSize)
+	for {
+		in, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		newBook := mapper.NewBookDbModel(in.GetBook())
+		if err := validate.Check(newBook); err != nil {
+			summary.Invalid++
+			continue
+		}
+		ops = append(ops, &models.BatchOperation{Create: newBook})
+		if len(ops) == s.importBatchSize {
+			if err := s.importBatch(ctx, ops, summary); err != nil {
+				return err
+			}
+			ops = ops[:0]
+		}
+	}
+	if len(ops) > 0 {
+		if err := s.importBatch(ctx, ops, summary); err != nil {
+			return err
+		}
+	}
+	return stream.SendAndClose(summary)
+}
+
+// importBatch adds a batch of imported books, skipping the duplicates,
+// and counts them in summary.
+func (s *server) importBatch(ctx context.Context, ops []*models.BatchOperation, summary *book.ImportSummary) error {
+	results, err := s.store.Batch(ctx, ops, true)
+	if err != nil {
+		return errors.Wrap(err, "importing books")
+	}
+	for _, result := range results {
+		if result.Err != nil {
+			summary.Duplicates++
+			continue
+		}
+		summary.Created++
+	}
+	return nil
+}
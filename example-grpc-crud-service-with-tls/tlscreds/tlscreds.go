@@ -9,41 +9,49 @@ import (
 	"google.golang.org/grpc/credentials"
 )
 
-const (
-	// caCert is the path to the CA's certificate file. This certificate
+// Files holds the paths to the certificate files used to set up TLS.
+type Files struct {
+	// CaCert is the path to the CA's certificate file. This certificate
 	// is used to verify the client's certificates.
-	caCert = "cert/ca-cert.pem"
+	CaCert string
 
-	// serverCert is the path to the server's certificate file.
+	// ServerCert is the path to the server's certificate file.
 	// This certificate is presented to clients during TLS handshake.
-	serverCert = "cert/server-cert.pem"
+	ServerCert string
 
-	// serverKey is the path to the server's private key file.
+	// ServerKey is the path to the server's private key file.
 	// This key is used for TLS encryption and must be kept secure.
-	serverKey = "cert/server-key.pem"
-)
+	ServerKey string
+}
 
-// For ease of unit testing.
-var (
-	readFile           = os.ReadFile
-	loadX509KeyPair    = tls.LoadX509KeyPair
-	appendCertsFromPEM = func(certPool *x509.CertPool, pemCerts []byte) (ok bool) {
-		return certPool.AppendCertsFromPEM(pemCerts)
-	}
-)
+// loader holds the functions New reads the certificate files with.
+type loader struct {
+	readFile           func(name string) ([]byte, error)
+	loadX509KeyPair    func(certFile, keyFile string) (tls.Certificate, error)
+	appendCertsFromPEM func(certPool *x509.CertPool, pemCerts []byte) bool
+}
+
+func New(files Files) (credentials.TransportCredentials, error) {
+	return newCreds(files, loader{
+		readFile:           os.ReadFile,
+		loadX509KeyPair:    tls.LoadX509KeyPair,
+		appendCertsFromPEM: (*x509.CertPool).AppendCertsFromPEM,
+	})
+}
 
-func New() (credentials.TransportCredentials, error) {
+// newCreds is New with the functions reading the certificate files given.
+func newCreds(files Files, l loader) (credentials.TransportCredentials, error) {
 	// Load certificate of the CA who signed client's certificate
-	pemClientCA, err := readFile(caCert)
+	pemClientCA, err := l.readFile(files.CaCert)
 	if err != nil {
 		return nil, errors.Wrap(err, "loading CA's certificate")
 	}
 	certPool := x509.NewCertPool()
-	if !appendCertsFromPEM(certPool, pemClientCA) {
+	if !l.appendCertsFromPEM(certPool, pemClientCA) {
 		return nil, errors.New("failed to add client CA's certificate")
 	}
 	// Load server's certificate and private key
-	serverCert, err := loadX509KeyPair(serverCert, serverKey)
+	serverCert, err := l.loadX509KeyPair(files.ServerCert, files.ServerKey)
 	if err != nil {
 		return nil, errors.Wrap(err, "loading server's certificate and private key")
 	}
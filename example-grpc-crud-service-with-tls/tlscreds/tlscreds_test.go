@@ -62,10 +62,11 @@ func TestNew(t *testing.T) {
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			readFile = tc.mockReadFile
-			loadX509KeyPair = tc.mockLoadX509KeyPair
-			appendCertsFromPEM = tc.mockAppendCertsFromPEM
-			tls, err := New()
+			tls, err := newCreds(Files{CaCert: "ca-cert.pem", ServerCert: "server-cert.pem", ServerKey: "server-key.pem"}, loader{
+				readFile:           tc.mockReadFile,
+				loadX509KeyPair:    tc.mockLoadX509KeyPair,
+				appendCertsFromPEM: tc.mockAppendCertsFromPEM,
+			})
 			if err != nil {
 				if tc.expectedError == nil {
 					t.Fatalf(`expected no error, got "%v"`, err)
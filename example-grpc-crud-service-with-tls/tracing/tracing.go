@@ -0,0 +1,95 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+// Package tracing sets up OpenTelemetry tracing, which follows an RPC
+// from the gRPC server down to the database queries it runs.
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+)
+
+// Span exporters.
+const (
+	NoneExporter   = "none"
+	StdoutExporter = "stdout"
+	OtlpExporter   = "otlp"
+)
+
+// serviceName identifies the server in the exported spans.
+const serviceName = "books-grpc-service"
+
+// Setup installs the W3C trace-context propagator and a tracer provider
+// that sends spans to the given exporter: none, stdout or otlp, in which
+// case they are sent over gRPC to the collector at endpoint, defaulting
+// to localhost:4317. It returns the function that flushes pending spans
+// and releases the provider.
+func Setup(ctx context.Context, exporter, endpoint string, insecure bool) (func(context.Context) error, error) {
+	return setup(ctx, os.Stdout, exporter, endpoint, insecure)
+}
+
+// setup is Setup with the writer the stdout exporter sends spans to given.
+func setup(ctx context.Context, stdout io.Writer, exporter, endpoint string, insecure bool) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	spanExporter, err := newExporter(ctx, stdout, exporter, endpoint, insecure)
+	if err != nil {
+		return nil, err
+	}
+	if spanExporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(
+		semconv.SchemaURL,
+		semconv.ServiceName(serviceName),
+	))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating tracing resource")
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// newExporter creates the given span exporter, or nil if spans
+// are not to be exported.
+func newExporter(ctx context.Context, stdout io.Writer, exporter, endpoint string, insecure bool) (sdktrace.SpanExporter, error) {
+	switch exporter {
+	case NoneExporter:
+		return nil, nil
+	case StdoutExporter:
+		spanExporter, err := stdouttrace.New(stdouttrace.WithWriter(stdout))
+		if err != nil {
+			return nil, errors.Wrap(err, "creating stdout exporter")
+		}
+		return spanExporter, nil
+	case OtlpExporter:
+		var opts []otlptracegrpc.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(endpoint))
+		}
+		if insecure {
+			opts = append(opts, otlptracegrpc.WithInsecure())
+		}
+		spanExporter, err := otlptracegrpc.New(ctx, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating otlp exporter")
+		}
+		return spanExporter, nil
+	}
+	return nil, errors.Errorf("unknown span exporter %q", exporter)
+}
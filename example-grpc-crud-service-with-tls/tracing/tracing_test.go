@@ -0,0 +1,66 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+)
+
+func TestSetup(t *testing.T) {
+	testCases := []struct {
+		name           string
+		exporter       string
+		expectedOutput string
+		expectedError  error
+	}{
+		{
+			name:     "none",
+			exporter: NoneExporter,
+		},
+		{
+			name:           "stdout",
+			exporter:       StdoutExporter,
+			expectedOutput: `"Name":"test span"`,
+		},
+		{
+			name:     "otlp",
+			exporter: OtlpExporter,
+		},
+		{
+			name:          "unknown exporter",
+			exporter:      "jaeger",
+			expectedError: errors.New(`unknown span exporter "jaeger"`),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			shutdown, err := setup(context.TODO(), &out, tc.exporter, "localhost:4317", true)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Contains(t, otel.GetTextMapPropagator().Fields(), "traceparent")
+				if tc.expectedOutput != "" {
+					_, span := otel.Tracer("test").Start(context.TODO(), "test span")
+					span.End()
+				}
+				require.NoError(t, shutdown(context.TODO()))
+				require.Contains(t, out.String(), tc.expectedOutput)
+			}
+		})
+	}
+}
@@ -5,40 +5,91 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"log"
+	"log/slog"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/config"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/interceptors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/logging"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/server"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/tracing"
 )
 
-type options struct {
-	Port int `short:"p" long:"port" description:"server's port" required:"true"`
-}
-
-func run(logger *log.Logger, serverPort int) error {
-	logger.Println("main: initializing gRPC server")
-	defer logger.Println("main: Completed")
+func run(logger *slog.Logger, opts *config.Options) error {
+	logger.Info("main: initializing gRPC server")
+	defer logger.Info("main: completed")
 
 	// =========================================================================
 	// Database support
 
-	const sqlitePath = "db/booksGrpcService.db"
-	db, err := db.ConnectToSqlite(sqlitePath)
+	sqlDb, err := db.Open(opts.Db.Driver, opts.Db.Dsn)
 	if err != nil {
 		return errors.Wrap(err, "connecting to database")
 	}
+	defer func() {
+		logger.Info("main: closing database")
+		sqlDb.Close()
+	}()
+	if opts.Migrate != "" {
+		return migrate(logger, sqlDb, opts.Migrate)
+	}
+	if err := db.Migrate(context.Background(), sqlDb); err != nil {
+		return errors.Wrap(err, "migrating database")
+	}
+	store, err := books.NewStore(opts.Db.Driver, sqlDb)
+	if err != nil {
+		return errors.Wrap(err, "creating book store")
+	}
+
+	// =========================================================================
+	// Tracing
+
+	shutdownTracing, err := tracing.Setup(context.Background(), opts.Tracing.Exporter, opts.Tracing.Endpoint, opts.Tracing.Insecure)
+	if err != nil {
+		return errors.Wrap(err, "setting up tracing")
+	}
+	defer func() {
+		logger.Info("main: flushing traces")
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.Error("main: flushing traces", slog.Any("err", err))
+		}
+	}()
+
+	// =========================================================================
+	// Metrics
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(sqlDb, opts.Db.Driver),
+	)
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	metricsSrv := http.Server{
+		Addr:    fmt.Sprintf(":%d", opts.MetricsPort),
+		Handler: metricsMux,
+	}
 
 	// =========================================================================
 	// Listener init
 
-	port := fmt.Sprintf(":%d", serverPort)
+	port := fmt.Sprintf(":%d", opts.Port)
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		return errors.Wrap(err, "tcp listening")
@@ -47,45 +98,105 @@ func run(logger *log.Logger, serverPort int) error {
 	// =========================================================================
 	// Server init
 
-	srv := server.New(logger, db)
+	srv := server.New(logger, store, interceptors.NewRpcMetrics(registry), opts.ImportBatchSize, opts.Reflection, opts.VerboseErrors)
 
 	// Make a channel to listen for an interrupt or terminate signal from the OS.
 	// Use a buffered channel because the signal package requires it.
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// Make a channel to listen for errors coming from the listener. Use a
-	// buffered channel so the goroutine can exit if we don't collect this error.
-	serverErrors := make(chan error, 1)
+	// Make a channel to listen for errors coming from the listeners. Use a
+	// buffered channel so the goroutines can exit if we don't collect these errors.
+	serverErrors := make(chan error, 2)
+
+	// Keep the health status in sync with the database.
+	healthCtx, stopHealth := context.WithCancel(context.Background())
+	defer stopHealth()
+	go srv.MonitorHealth(healthCtx)
 
 	// Start the service listening for requests.
 	go func() {
-		logger.Printf("main: gRPC server listening on %s", port)
+		logger.Info("main: gRPC server listening", slog.String("addr", port))
 		serverErrors <- srv.GrpcSrv.Serve(lis)
 	}()
 
+	// Start the service listening for metrics scrapes.
+	go func() {
+		logger.Info("main: metrics listening", slog.String("addr", metricsSrv.Addr))
+		serverErrors <- metricsSrv.ListenAndServe()
+	}()
+
 	// =========================================================================
 	// Shutdown
 	select {
 	case err := <-serverErrors:
 		return fmt.Errorf("server error: %w", err)
 	case sig := <-shutdown:
-		logger.Println("main: received signal for shutdown: ", sig)
-		srv.GrpcSrv.Stop()
+		logger.Info("main: received signal for shutdown", slog.String("signal", sig.String()))
+
+		// Give in-flight RPCs a deadline for completion.
+		ctx, cancel := context.WithTimeout(context.Background(), opts.ShutdownTimeout)
+		defer cancel()
+
+		// Asking the server to drain and stop. The metrics server is
+		// stopped as well even if the gRPC server could not drain.
+		srvErr := srv.Shutdown(ctx)
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			metricsSrv.Close()
+			if srvErr == nil {
+				return errors.Wrap(err, "could not stop metrics server gracefully")
+			}
+		}
+		if srvErr != nil {
+			return errors.Wrap(srvErr, "could not stop server gracefully")
+		}
 	}
 	return nil
 }
 
+// migrate runs the given migration command against the database.
+func migrate(logger *slog.Logger, sqlDb *sql.DB, command string) error {
+	ctx := context.Background()
+	switch command {
+	case "up":
+		if err := db.Migrate(ctx, sqlDb); err != nil {
+			return errors.Wrap(err, "applying migrations")
+		}
+	case "down":
+		if err := db.MigrateDown(ctx, sqlDb); err != nil {
+			return errors.Wrap(err, "reverting migration")
+		}
+	}
+	version, err := db.MigrationVersion(ctx, sqlDb)
+	if err != nil {
+		return errors.Wrap(err, "getting migration version")
+	}
+	logger.Info("main: database at migration version", slog.Int("version", version))
+	return nil
+}
+
 func main() {
-	var opts options
-	parser := flags.NewParser(&opts, flags.Default)
-	_, err := parser.Parse()
+	opts, err := config.Load(os.Args[1:])
 	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
-	logger := log.New(os.Stdout, "BOOKS GRPC SERVER : ", log.LstdFlags|log.Lmicroseconds|log.Lshortfile)
-	if err := run(logger, opts.Port); err != nil {
+	if opts.PrintConfig {
+		out, err := opts.Config.Dump()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(out)
+		return
+	}
+	logger, err := logging.New(os.Stdout, opts.Log.Format, opts.Log.Level)
+	if err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
+	if err := run(logger, opts); err != nil {
+		logger.Error("main: error", slog.Any("err", err))
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,233 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+// Package config loads the gRPC server's configuration.
+package config
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/logging"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/tracing"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/validate"
+	"gopkg.in/yaml.v3"
+)
+
+// Default values.
+const (
+	defaultMetricsPort     = 2112
+	defaultShutdownTimeout = 5 * time.Second
+	defaultImportBatchSize = 100
+	defaultSqliteDsn       = "db/booksGrpcService.db"
+)
+
+// redacted replaces secrets when printing the configuration.
+const redacted = "xxxxx"
+
+// passwordParam matches the password in key/value connection strings,
+// such as "host=localhost password=secret".
+var passwordParam = regexp.MustCompile(`(password=)\S+`)
+
+// Config holds the gRPC server's configuration.
+type Config struct {
+	Port            int           `yaml:"port" short:"p" long:"port" env:"BOOKS_PORT" description:"server's port"`
+	MetricsPort     int           `yaml:"metrics_port" long:"metrics-port" env:"BOOKS_METRICS_PORT" description:"port of the HTTP listener serving Prometheus metrics at /metrics"`
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout" long:"shutdown-timeout" env:"BOOKS_SHUTDOWN_TIMEOUT" description:"time given to in-flight RPCs to complete on shutdown"`
+	Reflection      bool          `yaml:"reflection" long:"reflection" env:"BOOKS_REFLECTION" description:"enables gRPC server reflection, used by tools such as grpcurl"`
+	VerboseErrors   bool          `yaml:"verbose_errors" long:"verbose-errors" env:"BOOKS_VERBOSE_ERRORS" description:"sends the text of internal errors to clients, for development only"`
+	ImportBatchSize int           `yaml:"import_batch_size" long:"import-batch-size" env:"BOOKS_IMPORT_BATCH_SIZE" description:"number of books inserted per transaction by ImportBooks"`
+	Db              Db            `yaml:"db" group:"Database Options" namespace:"db" env-namespace:"BOOKS_DB"`
+	Tracing         Tracing       `yaml:"tracing" group:"Tracing Options" namespace:"tracing" env-namespace:"BOOKS_TRACING"`
+	Log             Log           `yaml:"log" group:"Logging Options" namespace:"log" env-namespace:"BOOKS_LOG"`
+}
+
+// Db holds the database configuration.
+type Db struct {
+	Driver string `yaml:"driver" long:"driver" env:"DRIVER" description:"database backend, sqlite3 or postgres"`
+	Dsn    string `yaml:"dsn" long:"dsn" env:"DSN" description:"database connection string, defaults to the local SQLite file"`
+}
+
+// Tracing holds the OpenTelemetry tracing configuration.
+type Tracing struct {
+	Exporter string `yaml:"exporter" long:"exporter" env:"EXPORTER" description:"where spans are sent, none, stdout or otlp"`
+	Endpoint string `yaml:"endpoint" long:"endpoint" env:"ENDPOINT" description:"address of the OTLP collector, defaults to localhost:4317"`
+	Insecure bool   `yaml:"insecure" long:"insecure" env:"INSECURE" description:"sends spans to the OTLP collector without TLS"`
+}
+
+// Log holds the logging configuration.
+type Log struct {
+	Format string `yaml:"format" long:"format" env:"FORMAT" description:"format of the log entries, text or json"`
+	Level  string `yaml:"level" long:"level" env:"LEVEL" description:"lowest level logged, debug, info, warn or error"`
+}
+
+// Options holds the command line options, which are the configuration
+// itself plus the ones that control the program.
+type Options struct {
+	Config
+	ConfigFile  string `short:"c" long:"config" env:"BOOKS_CONFIG" description:"path to a YAML configuration file"`
+	PrintConfig bool   `long:"print-config" description:"prints the configuration, with secrets redacted, and exits"`
+	Migrate     string `long:"migrate" description:"runs the given migration command and exits" choice:"up" choice:"down" choice:"version"`
+}
+
+// Default returns the default configuration.
+func Default() Config {
+	return Config{
+		MetricsPort:     defaultMetricsPort,
+		ShutdownTimeout: defaultShutdownTimeout,
+		ImportBatchSize: defaultImportBatchSize,
+		Db: Db{
+			Driver: db.SqliteDriver,
+		},
+		Tracing: Tracing{
+			Exporter: tracing.NoneExporter,
+		},
+		Log: Log{
+			Format: logging.TextFormat,
+			Level:  logging.InfoLevel,
+		},
+	}
+}
+
+// Load builds the options from the given command line arguments. The
+// configuration is merged from, in increasing order of precedence, the
+// defaults, the YAML file given with --config, the BOOKS_* environment
+// variables and the command line flags, and then validated.
+func Load(args []string) (*Options, error) {
+	return load(args, os.ReadFile)
+}
+
+// load is Load with the function reading the configuration file given.
+func load(args []string, readFile func(name string) ([]byte, error)) (*Options, error) {
+	opts := &Options{Config: Default()}
+	configFile, err := configFilePath(args)
+	if err != nil {
+		return nil, err
+	}
+	if configFile != "" {
+		if err := opts.Config.loadFile(configFile, readFile); err != nil {
+			return nil, err
+		}
+	}
+	parser := flags.NewParser(opts, flags.HelpFlag|flags.PassDoubleDash)
+	parser.NamespaceDelimiter = "-"
+	if _, err := parser.ParseArgs(args); err != nil {
+		return nil, err
+	}
+	if opts.Db.Dsn == "" && opts.Db.Driver == db.SqliteDriver {
+		opts.Db.Dsn = defaultSqliteDsn
+	}
+	if err := opts.Config.Validate(); err != nil {
+		return nil, errors.Wrap(err, "invalid configuration")
+	}
+	return opts, nil
+}
+
+// configFilePath finds the configuration file given in the arguments
+// or in the environment, before the remaining options are parsed.
+func configFilePath(args []string) (string, error) {
+	var opts struct {
+		ConfigFile string `short:"c" long:"config" env:"BOOKS_CONFIG"`
+	}
+	parser := flags.NewParser(&opts, flags.IgnoreUnknown)
+	if _, err := parser.ParseArgs(args); err != nil {
+		return "", err
+	}
+	return opts.ConfigFile, nil
+}
+
+// loadFile overrides the configuration with the values in the given
+// YAML file, read with readFile. Unknown keys are rejected, so that
+// typos do not go unnoticed.
+func (c *Config) loadFile(path string, readFile func(name string) ([]byte, error)) error {
+	content, err := readFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "reading config file %s", path)
+	}
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(c); err != nil && err != io.EOF {
+		return errors.Wrapf(err, "parsing config file %s", path)
+	}
+	return nil
+}
+
+// Validate checks that the configuration is usable.
+func (c *Config) Validate() error {
+	var fields validate.FieldErrors
+	if c.Port < 1 || c.Port > 65535 {
+		fields = append(fields, validate.FieldError{Field: "port", Error: "port must be between 1 and 65535"})
+	}
+	if c.MetricsPort < 1 || c.MetricsPort > 65535 {
+		fields = append(fields, validate.FieldError{Field: "metrics_port", Error: "metrics_port must be between 1 and 65535"})
+	} else if c.MetricsPort == c.Port {
+		fields = append(fields, validate.FieldError{Field: "metrics_port", Error: "metrics_port must differ from port"})
+	}
+	if c.ShutdownTimeout <= 0 {
+		fields = append(fields, validate.FieldError{Field: "shutdown_timeout", Error: "shutdown_timeout must be greater than 0"})
+	}
+	if c.ImportBatchSize <= 0 {
+		fields = append(fields, validate.FieldError{Field: "import_batch_size", Error: "import_batch_size must be greater than 0"})
+	}
+	if c.Db.Driver != db.SqliteDriver && c.Db.Driver != db.PostgresDriver {
+		fields = append(fields, validate.FieldError{Field: "db.driver", Error: "db.driver must be one of [sqlite3 postgres]"})
+	}
+	if c.Db.Dsn == "" {
+		fields = append(fields, validate.FieldError{Field: "db.dsn", Error: "db.dsn is a required field"})
+	}
+	switch c.Tracing.Exporter {
+	case tracing.NoneExporter, tracing.StdoutExporter, tracing.OtlpExporter:
+	default:
+		fields = append(fields, validate.FieldError{Field: "tracing.exporter", Error: "tracing.exporter must be one of [none stdout otlp]"})
+	}
+	switch c.Log.Format {
+	case logging.TextFormat, logging.JsonFormat:
+	default:
+		fields = append(fields, validate.FieldError{Field: "log.format", Error: "log.format must be one of [text json]"})
+	}
+	switch c.Log.Level {
+	case logging.DebugLevel, logging.InfoLevel, logging.WarnLevel, logging.ErrorLevel:
+	default:
+		fields = append(fields, validate.FieldError{Field: "log.level", Error: "log.level must be one of [debug info warn error]"})
+	}
+	if len(fields) > 0 {
+		return fields
+	}
+	return nil
+}
+
+// Redacted returns a copy of the configuration in which secrets, such
+// as the database password, are masked.
+func (c Config) Redacted() Config {
+	c.Db.Dsn = redactDsn(c.Db.Dsn)
+	return c
+}
+
+// Dump returns the configuration in YAML, with secrets redacted, so
+// that it can be printed and reused as a configuration file.
+func (c Config) Dump() (string, error) {
+	out, err := yaml.Marshal(c.Redacted())
+	if err != nil {
+		return "", errors.Wrap(err, "marshaling config")
+	}
+	return string(out), nil
+}
+
+// redactDsn masks the password of both URL and key/value connection strings.
+func redactDsn(dsn string) string {
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), redacted)
+			return u.String()
+		}
+	}
+	return passwordParam.ReplaceAllString(dsn, "${1}"+redacted)
+}
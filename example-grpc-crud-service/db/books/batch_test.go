@@ -0,0 +1,199 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
+)
+
+// expectOperation sets the expectations of an operation of a batch,
+// run behind a savepoint that is released or rolled back to depending
+// on whether it succeeds.
+func expectOperation(mock sqlmock.Sqlmock, succeeds bool, expectStatements func()) {
+	mock.ExpectExec(regexp.QuoteMeta(savepointQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectStatements()
+	if succeeds {
+		mock.ExpectExec(regexp.QuoteMeta(releaseSavepointQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+	} else {
+		mock.ExpectExec(regexp.QuoteMeta(rollbackToSavepointQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+	}
+}
+
+func TestBatch(t *testing.T) {
+	// ops returns the operations of the batch, which are changed as they run.
+	ops := func() []*models.BatchOperation {
+		return []*models.BatchOperation{
+			{Create: &models.NewBook{Title: "some title", Author: "some author", Pages: 100}},
+			{Create: &models.NewBook{Title: "other title", Author: "some author", Pages: 150}},
+			{Delete: &models.DeletedBook{Id: 3}},
+		}
+	}
+	testCases := []struct {
+		name           string
+		mockClosure    func() (*sql.DB, sqlmock.Sqlmock)
+		bestEffort     bool
+		expectedOutput []*models.BatchResult
+		expectedError  error
+	}{
+		{
+			name: "happy path",
+			mockClosure: func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectBegin()
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("some title", "some author", 100).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				})
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("other title", "some author", 150).
+						WillReturnResult(sqlmock.NewResult(2, 1))
+				})
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				})
+				mock.ExpectCommit()
+				return db, mock
+			},
+			expectedOutput: []*models.BatchResult{
+				{Book: &models.Book{Id: 1, Title: "some title", Author: "some author", Pages: 100, Version: 1}},
+				{Book: &models.Book{Id: 2, Title: "other title", Author: "some author", Pages: 150, Version: 1}},
+				{},
+			},
+		},
+		{
+			name: "all or nothing, operation failed",
+			mockClosure: func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectBegin()
+				expectOperation(mock, false, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("some title", "some author", 100).
+						WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint})
+				})
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("other title", "some author", 150).
+						WillReturnResult(sqlmock.NewResult(2, 1))
+				})
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				})
+				mock.ExpectRollback()
+				return db, mock
+			},
+			expectedOutput: []*models.BatchResult{
+				{Err: &bookErrors.ErrDuplicateBook{Title: "some title", Author: "some author"}},
+				{Book: &models.Book{Id: 2, Title: "other title", Author: "some author", Pages: 150, Version: 1}},
+				{},
+			},
+		},
+		{
+			name: "best effort, operation failed",
+			mockClosure: func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectBegin()
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("some title", "some author", 100).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				})
+				expectOperation(mock, false, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("other title", "some author", 150).
+						WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint})
+				})
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				})
+				mock.ExpectCommit()
+				return db, mock
+			},
+			bestEffort: true,
+			expectedOutput: []*models.BatchResult{
+				{Book: &models.Book{Id: 1, Title: "some title", Author: "some author", Pages: 100, Version: 1}},
+				{Err: &bookErrors.ErrDuplicateBook{Title: "other title", Author: "some author"}},
+				{},
+			},
+		},
+		{
+			name: "error on begin",
+			mockClosure: func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectBegin().WillReturnError(errors.New("begin error"))
+				return db, mock
+			},
+			expectedError: errors.New("beginning transaction: begin error"),
+		},
+		{
+			name: "error on operation",
+			mockClosure: func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectBegin()
+				mock.ExpectExec(regexp.QuoteMeta(savepointQuery)).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("some title", "some author", 100).
+					WillReturnError(errors.New("insert error"))
+				mock.ExpectRollback()
+				return db, mock
+			},
+			bestEffort:    true,
+			expectedError: errors.New("running operation 0: inserting book: insert error"),
+		},
+		{
+			name: "error on commit",
+			mockClosure: func() (*sql.DB, sqlmock.Sqlmock) {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectBegin()
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("some title", "some author", 100).
+						WillReturnResult(sqlmock.NewResult(1, 1))
+				})
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("other title", "some author", 150).
+						WillReturnResult(sqlmock.NewResult(2, 1))
+				})
+				expectOperation(mock, true, func() {
+					mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(3).
+						WillReturnResult(sqlmock.NewResult(0, 1))
+				})
+				mock.ExpectCommit().WillReturnError(errors.New("commit error"))
+				return db, mock
+			},
+			expectedError: errors.New("committing transaction: commit error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := tc.mockClosure()
+			output, err := Batch(context.TODO(), db, ops(), tc.bestEffort)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
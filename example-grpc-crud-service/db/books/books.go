@@ -7,22 +7,57 @@ package books
 import (
 	"context"
 	"database/sql"
+	"fmt"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/errors"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// Querier runs SQL statements. It is implemented by both *sql.DB and
+// *sql.Tx, so that the operations changing books run either on their
+// own or within a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// initialVersion is the version of a newly created book, which is
+// the default value of the version column.
+const initialVersion = 1
+
 // SQL queries as constants for CRUD operations on the 'books' table.
 const (
 	listQuery = `
-	SELECT id, title, author, pages
+	SELECT id, title, author, pages, version
 	FROM books 
 	`
 
+	streamQuery = `
+	SELECT id, title, author, pages, version
+	FROM books
+	ORDER BY id
+	`
+
 	getByIdQuery = `
-	SELECT id, title, author, pages
+	SELECT id, title, author, pages, version
+	FROM books
+	WHERE id = $1
+	`
+
+	getVersionQuery = `
+	SELECT version
+	FROM books
+	WHERE id = $1
+	`
+
+	getTitleAndAuthorQuery = `
+	SELECT title, author
 	FROM books
 	WHERE id = $1
 	`
@@ -34,85 +69,295 @@ const (
 
 	updateQuery = `
 	UPDATE books
-	SET title = $1, author = $2, pages = $3
-	WHERE id = $4
+	SET title = $1, author = $2, pages = $3, version = version + 1
+	WHERE id = $4 AND ($5 = 0 OR version = $5)
+	RETURNING version
 	`
 
 	deleteByIdQuery = `
 	DELETE FROM books
 	WHERE id = $1
 	`
+
+	searchQuery = `
+	SELECT b.id, b.title, b.author, b.pages,
+		snippet(books_fts, -1, '<mark>', '</mark>', '...', 16),
+		bm25(books_fts)
+	FROM books_fts
+	JOIN books b ON b.id = books_fts.rowid
+	WHERE books_fts MATCH $1
+	ORDER BY bm25(books_fts)
+	LIMIT $2
+	`
 )
 
+// searchLimit is the maximum number of results returned by Search.
+const searchLimit = 50
+
 // List retrieves all books from the database.
 func List(ctx context.Context, db *sql.DB) ([]*models.Book, error) {
+	ctx, span := startSpan(ctx, "List", listQuery)
+	defer span.End()
 	rows, err := db.QueryContext(ctx, listQuery)
 	if err != nil {
-		return nil, errors.Wrap(err, "listing books")
+		return nil, spanError(span, errors.Wrap(err, "listing books"))
 	}
 	defer rows.Close()
 	books := []*models.Book{}
 	for rows.Next() {
 		var book models.Book
-		if err := rows.Scan(&book.Id, &book.Title, &book.Author, &book.Pages); err != nil {
-			return nil, errors.Wrap(err, "scanning book")
+		if err := rows.Scan(&book.Id, &book.Title, &book.Author, &book.Pages, &book.Version); err != nil {
+			return nil, spanError(span, errors.Wrap(err, "scanning book"))
 		}
 		books = append(books, &book)
 	}
 	return books, nil
 }
 
+// Stream walks the books table with a row cursor, calling fn for each
+// book as it is read. It stops at the first error returned by fn, or as
+// soon as ctx is canceled.
+func Stream(ctx context.Context, db *sql.DB, fn func(book *models.Book) error) error {
+	ctx, span := startSpan(ctx, "Stream", streamQuery)
+	defer span.End()
+	rows, err := db.QueryContext(ctx, streamQuery)
+	if err != nil {
+		return spanError(span, errors.Wrap(err, "streaming books"))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var book models.Book
+		if err := rows.Scan(&book.Id, &book.Title, &book.Author, &book.Pages, &book.Version); err != nil {
+			return spanError(span, errors.Wrap(err, "scanning book"))
+		}
+		if err := fn(&book); err != nil {
+			return err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return spanError(span, errors.Wrap(err, "iterating books"))
+	}
+	return nil
+}
+
 // GetById retrieves a book by its ID.
 func GetById(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+	ctx, span := startSpan(ctx, "GetById", getByIdQuery)
+	defer span.End()
 	row := db.QueryRowContext(ctx, getByIdQuery, bookId)
 	var book models.Book
-	if err := row.Scan(&book.Id, &book.Title, &book.Author, &book.Pages); err != nil {
+	if err := row.Scan(&book.Id, &book.Title, &book.Author, &book.Pages, &book.Version); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, &bookErrors.ErrBookNotFound{Id: bookId}
 		}
-		return nil, errors.Wrapf(err, "getting book with id %d", bookId)
+		return nil, spanError(span, errors.Wrapf(err, "getting book with id %d", bookId))
 	}
 	return &book, nil
 }
 
 // Create adds a new book record to the database.
-func Create(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+func Create(ctx context.Context, db Querier, newBook *models.NewBook) (*models.NewBook, error) {
+	ctx, span := startSpan(ctx, "Create", createQuery)
+	defer span.End()
 	result, err := db.ExecContext(ctx, createQuery, newBook.Title, newBook.Author, newBook.Pages)
 	if err != nil {
-		var sqliteErr sqlite3.Error
-		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		if isDuplicateError(err) {
 			return nil, &bookErrors.ErrDuplicateBook{Title: newBook.Title, Author: newBook.Author}
 		}
-		return nil, errors.Wrap(err, "inserting book")
+		return nil, spanError(span, errors.Wrap(err, "inserting book"))
 	}
 	id, err := result.LastInsertId()
 	if err != nil {
-		return nil, errors.Wrap(err, "getting last insert id")
+		return nil, spanError(span, errors.Wrap(err, "getting last insert id"))
 	}
 	newBook.Id = int(id)
+	newBook.Version = initialVersion
 	return newBook, nil
 }
 
-// Update modifies an existing book record.
-func Update(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
-	result, err := db.ExecContext(ctx, updateQuery, book.Title, book.Author, book.Pages, book.Id)
-	if err != nil {
-		return nil, errors.Wrapf(err, "updating book with id %d", book.Id)
+// Update modifies an existing book record, bumping its version. If the
+// book's version is set, the book is only updated if it is still at that
+// version, so that concurrent changes are not overwritten.
+func Update(ctx context.Context, db Querier, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	ctx, span := startSpan(ctx, "Update", updateQuery)
+	defer span.End()
+	row := db.QueryRowContext(ctx, updateQuery, book.Title, book.Author, book.Pages, book.Id, book.Version)
+	if err := row.Scan(&book.Version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notUpdatedError(ctx, db, span, book.Id, book.Version)
+		}
+		if isDuplicateError(err) {
+			return nil, &bookErrors.ErrDuplicateBook{Title: book.Title, Author: book.Author}
+		}
+		return nil, spanError(span, errors.Wrapf(err, "updating book with id %d", book.Id))
 	}
-	rowsUpdated, err := result.RowsAffected()
-	if err != nil {
-		return nil, errors.Wrap(err, "checking affected rows")
+	return book, nil
+}
+
+// notUpdatedError tells why the book with the given id was not changed
+// by a statement conditioned on its version: either it does not exist,
+// or it is not at the expected version anymore. Only failing to find
+// out is recorded in the span, as the other errors are the client's.
+func notUpdatedError(ctx context.Context, db Querier, span trace.Span, bookId, expected int) error {
+	var version int
+	if err := db.QueryRowContext(ctx, getVersionQuery, bookId).Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			return &bookErrors.ErrBookNotFound{Id: bookId}
+		}
+		return spanError(span, errors.Wrapf(err, "getting version of book with id %d", bookId))
 	}
-	if rowsUpdated == 0 {
-		return nil, &bookErrors.ErrBookNotFound{Id: book.Id}
+	return &bookErrors.ErrVersionMismatch{Id: bookId, Version: version, Expected: expected}
+}
+
+// Patch sets the fields of a book record that are not nil in patch,
+// bumping its version. If the patch's version is set, the book is only
+// updated if it is still at that version.
+func Patch(ctx context.Context, db Querier, patch *models.BookPatch) (*models.Book, error) {
+	return patchBook(ctx, db, db, patch)
+}
+
+// patchBook is Patch with the database the title and author of a book
+// that patch would duplicate are read from given, as a failed statement
+// aborts the PostgreSQL transaction it runs in.
+func patchBook(ctx context.Context, db, lookup Querier, patch *models.BookPatch) (*models.Book, error) {
+	query, args := patchQuery(patch)
+	ctx, span := startSpan(ctx, "Patch", query)
+	defer span.End()
+	row := db.QueryRowContext(ctx, query, args...)
+	var book models.Book
+	if err := row.Scan(&book.Id, &book.Title, &book.Author, &book.Pages, &book.Version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notUpdatedError(ctx, db, span, patch.Id, patch.Version)
+		}
+		if isDuplicateError(err) {
+			return nil, duplicatePatchError(ctx, lookup, span, patch)
+		}
+		return nil, spanError(span, errors.Wrapf(err, "patching book with id %d", patch.Id))
 	}
-	return book, nil
+	return &book, nil
+}
+
+// duplicatePatchError tells which book patch would have duplicated,
+// reading the title or author left as it is from the book, which the
+// failed statement did not change.
+func duplicatePatchError(ctx context.Context, db Querier, span trace.Span, patch *models.BookPatch) error {
+	var title, author string
+	if patch.Title == nil || patch.Author == nil {
+		if err := db.QueryRowContext(ctx, getTitleAndAuthorQuery, patch.Id).Scan(&title, &author); err != nil {
+			return spanError(span, errors.Wrapf(err, "getting title and author of book with id %d", patch.Id))
+		}
+	}
+	if patch.Title != nil {
+		title = *patch.Title
+	}
+	if patch.Author != nil {
+		author = *patch.Author
+	}
+	return &bookErrors.ErrDuplicateBook{Title: title, Author: author}
+}
+
+// patchQuery builds the parameterized query that sets the columns of
+// the fields held by patch, bumps the version of the book and returns
+// the updated book.
+func patchQuery(patch *models.BookPatch) (string, []any) {
+	var (
+		sets []string
+		args []any
+	)
+	// arg registers a query argument and returns its placeholder.
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if patch.Title != nil {
+		sets = append(sets, "title = "+arg(*patch.Title))
+	}
+	if patch.Author != nil {
+		sets = append(sets, "author = "+arg(*patch.Author))
+	}
+	if patch.Pages != nil {
+		sets = append(sets, "pages = "+arg(*patch.Pages))
+	}
+	sets = append(sets, "version = version + 1")
+	id, version := arg(patch.Id), arg(patch.Version)
+	var query strings.Builder
+	query.WriteString("\n\tUPDATE books")
+	query.WriteString("\n\tSET " + strings.Join(sets, ", "))
+	query.WriteString("\n\tWHERE id = " + id + " AND (" + version + " = 0 OR version = " + version + ")")
+	query.WriteString("\n\tRETURNING id, title, author, pages, version")
+	return query.String(), args
 }
 
 // DeleteById removes a book record by its ID.
-func DeleteById(ctx context.Context, db *sql.DB, bookId int) error {
+func DeleteById(ctx context.Context, db Querier, bookId int) error {
+	ctx, span := startSpan(ctx, "DeleteById", deleteByIdQuery)
+	defer span.End()
 	if _, err := db.ExecContext(ctx, deleteByIdQuery, bookId); err != nil {
-		return errors.Wrapf(err, "deleting book with id %d", bookId)
+		return spanError(span, errors.Wrapf(err, "deleting book with id %d", bookId))
 	}
 	return nil
 }
+
+// isDuplicateError tells whether err violates the uniqueness of the
+// title and author of books, as reported by either SQLite or PostgreSQL.
+func isDuplicateError(err error) bool {
+	var (
+		sqliteErr sqlite3.Error
+		pqErr     *pq.Error
+	)
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrConstraint
+	}
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// Search performs a full-text search over book titles and authors,
+// returning the best ranked matches first along with a snippet where
+// the matching terms are highlighted.
+func Search(ctx context.Context, db *sql.DB, query string) ([]*models.SearchResult, error) {
+	return search(ctx, db, searchQuery, matchExpression(query))
+}
+
+// search runs the given full-text search statement with the given
+// backend specific match expression.
+func search(ctx context.Context, db *sql.DB, stmt, match string) ([]*models.SearchResult, error) {
+	results := []*models.SearchResult{}
+	if match == "" {
+		return results, nil
+	}
+	ctx, span := startSpan(ctx, "Search", stmt)
+	defer span.End()
+	rows, err := db.QueryContext(ctx, stmt, match, searchLimit)
+	if err != nil {
+		return nil, spanError(span, errors.Wrap(err, "searching books"))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result models.SearchResult
+		if err := rows.Scan(&result.Id, &result.Title, &result.Author, &result.Pages, &result.Snippet, &result.Rank); err != nil {
+			return nil, spanError(span, errors.Wrap(err, "scanning search result"))
+		}
+		results = append(results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, spanError(span, errors.Wrap(err, "iterating search results"))
+	}
+	return results, nil
+}
+
+// matchExpression turns free text into an FTS5 match expression in
+// which every term is quoted, so that user input cannot inject query
+// syntax, and matched as a prefix.
+func matchExpression(query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(terms, " ")
+}
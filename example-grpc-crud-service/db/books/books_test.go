@@ -13,6 +13,7 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
@@ -32,23 +33,25 @@ func TestList(t *testing.T) {
 				require.NoError(t, err)
 				mock.ExpectQuery(regexp.QuoteMeta(listQuery)).
 					WillReturnRows(sqlmock.NewRows(
-						[]string{"id", "title", "author", "pages"}).
-						AddRow(1, "some title", "some author", 100).
-						AddRow(2, "another title", "another author", 150))
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow(1, "some title", "some author", 100, 1).
+						AddRow(2, "another title", "another author", 150, 3))
 				return db
 			},
 			expectedOutput: []*models.Book{
 				{
-					Id:     1,
-					Title:  "some title",
-					Author: "some author",
-					Pages:  100,
+					Id:      1,
+					Title:   "some title",
+					Author:  "some author",
+					Pages:   100,
+					Version: 1,
 				},
 				{
-					Id:     2,
-					Title:  "another title",
-					Author: "another author",
-					Pages:  150,
+					Id:      2,
+					Title:   "another title",
+					Author:  "another author",
+					Pages:   150,
+					Version: 3,
 				},
 			},
 		},
@@ -68,8 +71,8 @@ func TestList(t *testing.T) {
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				rows := sqlmock.NewRows([]string{"id", "title", "author", "pages"}).
-					AddRow("invalid", "data", "types", "here")
+				rows := sqlmock.NewRows([]string{"id", "title", "author", "pages", "version"}).
+					AddRow("invalid", "data", "types", "here", "version")
 				mock.ExpectQuery(regexp.QuoteMeta(listQuery)).
 					WillReturnRows(rows)
 
@@ -97,6 +100,140 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestStream(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mockClosure    func() *sql.DB
+		cancelOnFirst  bool
+		fnError        error
+		expectedOutput []*models.Book
+		expectedError  error
+	}{
+		{
+			name: "happy path",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(streamQuery)).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow(1, "some title", "some author", 100, 1).
+						AddRow(2, "another title", "another author", 150, 3))
+				return db
+			},
+			expectedOutput: []*models.Book{
+				{
+					Id:      1,
+					Title:   "some title",
+					Author:  "some author",
+					Pages:   100,
+					Version: 1,
+				},
+				{
+					Id:      2,
+					Title:   "another title",
+					Author:  "another author",
+					Pages:   150,
+					Version: 3,
+				},
+			},
+		},
+		{
+			name: "error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(streamQuery)).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			expectedError: errors.New("streaming books: select error"),
+		},
+		{
+			name: "error on scan",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(streamQuery)).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow("invalid", "data", "types", "here", "version"))
+				return db
+			},
+			expectedError: errors.New(`scanning book: sql: Scan error on column index 0, name "id": converting driver.Value type string ("invalid") to a int: invalid syntax`),
+		},
+		{
+			name: "error from callback",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(streamQuery)).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow(1, "some title", "some author", 100, 1))
+				return db
+			},
+			fnError:       errors.New("send error"),
+			expectedError: errors.New("send error"),
+		},
+		{
+			name: "error on iterating rows",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(streamQuery)).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow(1, "some title", "some author", 100, 1).
+						RowError(0, errors.New("row error")))
+				return db
+			},
+			expectedError: errors.New("iterating books: row error"),
+		},
+		{
+			name: "context canceled",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(streamQuery)).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow(1, "some title", "some author", 100, 1).
+						AddRow(2, "another title", "another author", 150, 3))
+				return db
+			},
+			cancelOnFirst: true,
+			expectedError: context.Canceled,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := tc.mockClosure()
+			ctx, cancel := context.WithCancel(context.TODO())
+			defer cancel()
+			var output []*models.Book
+			err := Stream(ctx, db, func(book *models.Book) error {
+				output = append(output, book)
+				if tc.cancelOnFirst {
+					cancel()
+				}
+				return tc.fnError
+			})
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
 func TestGetById(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -111,15 +248,16 @@ func TestGetById(t *testing.T) {
 				require.NoError(t, err)
 				mock.ExpectQuery(regexp.QuoteMeta(getByIdQuery)).WithArgs(1).
 					WillReturnRows(sqlmock.NewRows(
-						[]string{"id", "title", "author", "pages"}).
-						AddRow(1, "some title", "some author", 100))
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow(1, "some title", "some author", 100, 1))
 				return db
 			},
 			expectedOutput: &models.Book{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 1,
 			},
 		},
 		{
@@ -187,10 +325,11 @@ func TestCreate(t *testing.T) {
 				Pages:  100,
 			},
 			expectedOutput: &models.NewBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 1,
 			},
 		},
 		{
@@ -274,74 +413,162 @@ func TestUpdate(t *testing.T) {
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
 			expectedOutput: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 3,
+			},
+		},
+		{
+			name: "happy path, any version",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 0).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+				return db
+			},
+			input: &models.UpdatedBook{
 				Id:     1,
 				Title:  "some title",
 				Author: "some author",
 				Pages:  100,
 			},
+			expectedOutput: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 5,
+			},
 		},
 		{
 			name: "error",
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
 					WillReturnError(errors.New("update error"))
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
 			expectedError: errors.New("updating book with id 1: update error"),
 		},
 		{
-			name: "error on rows affected",
+			name: "error, duplicate book",
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
-					WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("rows affected error")))
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint})
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
-			expectedError: errors.New("checking affected rows: rows affected error"),
+			expectedError: errors.New(`book with title "some title" from author "some author" already exists`),
+		},
+		{
+			name: "error, duplicate book in PostgreSQL",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				return db
+			},
+			input: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
+			},
+			expectedError: errors.New(`book with title "some title" from author "some author" already exists`),
 		},
 		{
 			name: "no book found",
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
-					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
 			expectedError: errors.New("no book with id 1 found"),
 		},
+		{
+			name: "version mismatch",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				return db
+			},
+			input: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
+			},
+			expectedError: errors.New("book with id 1 is at version 3, not 2"),
+		},
+		{
+			name: "error getting version",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			input: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
+			},
+			expectedError: errors.New("getting version of book with id 1: select error"),
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -362,6 +589,174 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestPatch(t *testing.T) {
+	title, author, pages := "another title", "another author", 120
+	bookRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "title", "author", "pages", "version"}).
+			AddRow(1, "another title", "some author", 120, 3)
+	}
+	testCases := []struct {
+		name           string
+		mockClosure    func() *sql.DB
+		input          *models.BookPatch
+		expectedOutput *models.Book
+		expectedError  error
+	}{
+		{
+			name: "happy path",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1, pages = $2, version = version + 1 WHERE id = $3 AND ($4 = 0 OR version = $4) RETURNING id, title, author, pages, version")).
+					WithArgs("another title", 120, 1, 2).
+					WillReturnRows(bookRows())
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedOutput: &models.Book{
+				Id:      1,
+				Title:   "another title",
+				Author:  "some author",
+				Pages:   120,
+				Version: 3,
+			},
+		},
+		{
+			name: "error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET pages = $1, version = version + 1 WHERE id = $2 AND ($3 = 0 OR version = $3)")).
+					WithArgs(120, 1, 2).
+					WillReturnError(errors.New("update error"))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedError: errors.New("patching book with id 1: update error"),
+		},
+		{
+			name: "error, duplicate book",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1, author = $2")).
+					WithArgs("another title", "another author", 1, 2).
+					WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint})
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Author:  &author,
+				Version: 2,
+			},
+			expectedError: errors.New(`book with title "another title" from author "another author" already exists`),
+		},
+		{
+			name: "error, duplicate book, author left as it is",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1")).
+					WithArgs("another title", 1, 2).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				mock.ExpectQuery(regexp.QuoteMeta(getTitleAndAuthorQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"title", "author"}).AddRow("some title", "some author"))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Version: 2,
+			},
+			expectedError: errors.New(`book with title "another title" from author "some author" already exists`),
+		},
+		{
+			name: "error, duplicate book, book not read",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1")).
+					WithArgs("another title", 1, 2).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				mock.ExpectQuery(regexp.QuoteMeta(getTitleAndAuthorQuery)).WithArgs(1).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Version: 2,
+			},
+			expectedError: errors.New("getting title and author of book with id 1: select error"),
+		},
+		{
+			name: "no book found",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET pages = $1")).
+					WithArgs(120, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedError: errors.New("no book with id 1 found"),
+		},
+		{
+			name: "version mismatch",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET pages = $1")).
+					WithArgs(120, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedError: errors.New("book with id 1 is at version 3, not 2"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := tc.mockClosure()
+			output, err := Patch(context.TODO(), db, tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
 func TestDeleteById(t *testing.T) {
 	testCases := []struct {
 		name          string
@@ -410,3 +805,107 @@ func TestDeleteById(t *testing.T) {
 		})
 	}
 }
+
+func TestSearch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		mockClosure    func() *sql.DB
+		expectedOutput []*models.SearchResult
+		expectedError  error
+	}{
+		{
+			name:  "happy path",
+			input: `lord "rings`,
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"* """rings"*`, searchLimit).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "snippet", "rank"}).
+						AddRow(1, "the lord of the rings", "tolkien", 1000, "the <mark>lord</mark> of the <mark>rings</mark>", -1.5))
+				return db
+			},
+			expectedOutput: []*models.SearchResult{
+				{
+					Book: models.Book{
+						Id:     1,
+						Title:  "the lord of the rings",
+						Author: "tolkien",
+						Pages:  1000,
+					},
+					Snippet: "the <mark>lord</mark> of the <mark>rings</mark>",
+					Rank:    -1.5,
+				},
+			},
+		},
+		{
+			name:  "blank query",
+			input: "  ",
+			mockClosure: func() *sql.DB {
+				db, _, err := sqlmock.New()
+				require.NoError(t, err)
+				return db
+			},
+			expectedOutput: []*models.SearchResult{},
+		},
+		{
+			name:  "error",
+			input: "lord",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"*`, searchLimit).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			expectedError: errors.New("searching books: select error"),
+		},
+		{
+			name:  "error on scan",
+			input: "lord",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"*`, searchLimit).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "snippet", "rank"}).
+						AddRow("invalid", "data", "types", "here", "snippet", "rank"))
+				return db
+			},
+			expectedError: errors.New(`scanning search result: sql: Scan error on column index 0, name "id": converting driver.Value type string ("invalid") to a int: invalid syntax`),
+		},
+		{
+			name:  "error on iterating rows",
+			input: "lord",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"*`, searchLimit).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "snippet", "rank"}).
+						AddRow(1, "the lord of the rings", "tolkien", 1000, "snippet", -1.5).
+						RowError(0, errors.New("row error")))
+				return db
+			},
+			expectedError: errors.New("iterating search results: row error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := tc.mockClosure()
+			output, err := Search(context.TODO(), db, tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
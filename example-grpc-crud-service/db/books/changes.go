@@ -0,0 +1,176 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
+)
+
+// SQL queries over the 'book_changes' table, which is filled by
+// triggers on the 'books' table.
+const (
+	latestSequenceQuery = `
+	SELECT COALESCE(MAX(sequence), 0)
+	FROM book_changes
+	`
+
+	listChangesQuery = `
+	SELECT sequence, operation, book_id, title, author, pages, version
+	FROM book_changes
+	WHERE sequence > $1
+	ORDER BY sequence
+	LIMIT $2
+	`
+)
+
+// changesPageSize is the maximum number of changes read at once by Watch.
+const changesPageSize = 100
+
+// defaultPollInterval is how often watchers look for the changes
+// they were not notified of.
+const defaultPollInterval = time.Second
+
+// Feed notifies its watchers that books have changed, so that they
+// read the new changes as soon as they are made. As it only knows of
+// the changes made by this process, watchers also poll for the ones
+// made by other replicas sharing the database every pollInterval.
+type Feed struct {
+	mu           sync.Mutex
+	watchers     map[chan struct{}]struct{}
+	pollInterval time.Duration
+}
+
+// NewFeed creates a new Feed with no watchers, which poll
+// for changes every second.
+func NewFeed() *Feed {
+	return newFeed(defaultPollInterval)
+}
+
+// newFeed is NewFeed with the interval watchers poll at given.
+func newFeed(pollInterval time.Duration) *Feed {
+	return &Feed{watchers: make(map[chan struct{}]struct{}), pollInterval: pollInterval}
+}
+
+// Notify wakes every watcher up. It never blocks: a watcher that has
+// not yet handled a previous notification gets a single one for both.
+func (f *Feed) Notify() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for watcher := range f.watchers {
+		select {
+		case watcher <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// subscribe registers a new watcher, returning the channel
+// its notifications are sent to.
+func (f *Feed) subscribe() chan struct{} {
+	watcher := make(chan struct{}, 1)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.watchers[watcher] = struct{}{}
+	return watcher
+}
+
+// unsubscribe removes the given watcher.
+func (f *Feed) unsubscribe(watcher chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.watchers, watcher)
+}
+
+// Watch calls fn for every change made to books after the given
+// sequence, in order, then waits for the feed to notify new ones, or
+// for its poll interval to elapse. A sequence of zero only watches the
+// changes made from now on.
+// It stops at the first error returned by fn, or as soon as ctx
+// is canceled.
+func Watch(ctx context.Context, db *sql.DB, feed *Feed, after int64, fn func(change *models.Change) error) error {
+	// Subscribing first makes sure no change made while
+	// reading the previous ones goes unnoticed.
+	watcher := feed.subscribe()
+	defer feed.unsubscribe(watcher)
+	ticker := time.NewTicker(feed.pollInterval)
+	defer ticker.Stop()
+	if after == 0 {
+		latest, err := latestSequence(ctx, db)
+		if err != nil {
+			return err
+		}
+		after = latest
+	}
+	for {
+		changes, err := listChanges(ctx, db, after)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			if err := fn(change); err != nil {
+				return err
+			}
+			after = change.Sequence
+		}
+		if len(changes) == changesPageSize {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-watcher:
+		case <-ticker.C:
+		}
+	}
+}
+
+// latestSequence returns the sequence of the latest change,
+// or zero if there is none.
+func latestSequence(ctx context.Context, db *sql.DB) (int64, error) {
+	ctx, span := startSpan(ctx, "Watch", latestSequenceQuery)
+	defer span.End()
+	var sequence int64
+	if err := db.QueryRowContext(ctx, latestSequenceQuery).Scan(&sequence); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return 0, ctxErr
+		}
+		return 0, spanError(span, errors.Wrap(err, "getting latest change"))
+	}
+	return sequence, nil
+}
+
+// listChanges returns the next page of changes made after
+// the given sequence.
+func listChanges(ctx context.Context, db *sql.DB, after int64) ([]*models.Change, error) {
+	ctx, span := startSpan(ctx, "Watch", listChangesQuery)
+	defer span.End()
+	rows, err := db.QueryContext(ctx, listChangesQuery, after, changesPageSize)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, spanError(span, errors.Wrap(err, "listing changes"))
+	}
+	defer rows.Close()
+	changes := []*models.Change{}
+	for rows.Next() {
+		var change models.Change
+		if err := rows.Scan(&change.Sequence, &change.Operation, &change.Book.Id, &change.Book.Title,
+			&change.Book.Author, &change.Book.Pages, &change.Book.Version); err != nil {
+			return nil, spanError(span, errors.Wrap(err, "scanning change"))
+		}
+		changes = append(changes, &change)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, spanError(span, errors.Wrap(err, "iterating changes"))
+	}
+	return changes, nil
+}
@@ -0,0 +1,225 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
+)
+
+// errStopWatching is returned by the callbacks that end a watch.
+var errStopWatching = errors.New("stop watching")
+
+// changeColumns are the columns read from the book_changes table.
+var changeColumns = []string{"sequence", "operation", "book_id", "title", "author", "pages", "version"}
+
+func TestFeed(t *testing.T) {
+	feed := NewFeed()
+	watcher := feed.subscribe()
+
+	// Notifications not yet handled are merged.
+	feed.Notify()
+	feed.Notify()
+	require.Len(t, watcher, 1)
+	<-watcher
+
+	feed.unsubscribe(watcher)
+	feed.Notify()
+	require.Len(t, watcher, 0)
+	require.Empty(t, feed.watchers)
+}
+
+func TestWatch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mockClosure    func() *sql.DB
+		after          int64
+		stopAfter      int
+		expectedOutput []*models.Change
+		expectedError  error
+	}{
+		{
+			name: "happy path",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(2, changesPageSize).
+					WillReturnRows(sqlmock.NewRows(changeColumns).
+						AddRow(3, "create", 1, "some title", "some author", 100, 1).
+						AddRow(4, "delete", 1, "some title", "some author", 100, 1))
+				return db
+			},
+			after:     2,
+			stopAfter: 2,
+			expectedOutput: []*models.Change{
+				{
+					Sequence:  3,
+					Operation: models.CreateOperation,
+					Book:      models.Book{Id: 1, Title: "some title", Author: "some author", Pages: 100, Version: 1},
+				},
+				{
+					Sequence:  4,
+					Operation: models.DeleteOperation,
+					Book:      models.Book{Id: 1, Title: "some title", Author: "some author", Pages: 100, Version: 1},
+				},
+			},
+			expectedError: errStopWatching,
+		},
+		{
+			name: "new changes only",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(latestSequenceQuery)).
+					WillReturnRows(sqlmock.NewRows([]string{"sequence"}).AddRow(7))
+				mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(7, changesPageSize).
+					WillReturnRows(sqlmock.NewRows(changeColumns).
+						AddRow(8, "update", 1, "some title", "some author", 150, 2))
+				return db
+			},
+			stopAfter: 1,
+			expectedOutput: []*models.Change{
+				{
+					Sequence:  8,
+					Operation: models.UpdateOperation,
+					Book:      models.Book{Id: 1, Title: "some title", Author: "some author", Pages: 150, Version: 2},
+				},
+			},
+			expectedError: errStopWatching,
+		},
+		{
+			name: "error getting latest change",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(latestSequenceQuery)).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			expectedError: errors.New("getting latest change: select error"),
+		},
+		{
+			name: "error listing changes",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(2, changesPageSize).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			after:         2,
+			expectedError: errors.New("listing changes: select error"),
+		},
+		{
+			name: "error on scan",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(2, changesPageSize).
+					WillReturnRows(sqlmock.NewRows(changeColumns).
+						AddRow("invalid", "data", "types", "here", "and", "there", "too"))
+				return db
+			},
+			after:         2,
+			expectedError: errors.New(`scanning change: sql: Scan error on column index 0, name "sequence": converting driver.Value type string ("invalid") to a int64: invalid syntax`),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var output []*models.Change
+			err := Watch(context.TODO(), tc.mockClosure(), NewFeed(), tc.after, func(change *models.Change) error {
+				output = append(output, change)
+				if len(output) == tc.stopAfter {
+					return errStopWatching
+				}
+				return nil
+			})
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+			}
+			require.Equal(t, tc.expectedOutput, output)
+		})
+	}
+}
+
+func TestWatchWaitsForNotification(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(2, changesPageSize).
+		WillReturnRows(sqlmock.NewRows(changeColumns))
+	mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(2, changesPageSize).
+		WillReturnRows(sqlmock.NewRows(changeColumns).
+			AddRow(3, "create", 1, "some title", "some author", 100, 1))
+
+	feed := NewFeed()
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	changes := make(chan *models.Change, 1)
+	done := make(chan error)
+	go func() {
+		done <- Watch(ctx, db, feed, 2, func(change *models.Change) error {
+			// Stops watching once the new change is read.
+			cancel()
+			changes <- change
+			return nil
+		})
+	}()
+
+	// Keeps notifying until the watcher, once subscribed and
+	// done with the first page, reads the new change.
+	var change *models.Change
+	for change == nil {
+		feed.Notify()
+		select {
+		case change = <-changes:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	require.Equal(t, int64(3), change.Sequence)
+	require.Equal(t, context.Canceled, <-done)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestWatchPolls(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(2, changesPageSize).
+		WillReturnRows(sqlmock.NewRows(changeColumns))
+	mock.ExpectQuery(regexp.QuoteMeta(listChangesQuery)).WithArgs(2, changesPageSize).
+		WillReturnRows(sqlmock.NewRows(changeColumns).
+			AddRow(3, "create", 1, "some title", "some author", 100, 1))
+
+	// The change is made by another replica, so the feed
+	// is never notified of it.
+	feed := newFeed(10 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	var changes []*models.Change
+	err = Watch(ctx, db, feed, 2, func(change *models.Change) error {
+		changes = append(changes, change)
+		return errStopWatching
+	})
+	require.Equal(t, errStopWatching, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, int64(3), changes[0].Sequence)
+	require.NoError(t, mock.ExpectationsWereMet())
+}
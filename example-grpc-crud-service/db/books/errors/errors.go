@@ -24,3 +24,15 @@ type ErrDuplicateBook struct {
 func (e ErrDuplicateBook) Error() string {
 	return fmt.Sprintf(`book with title "%s" from author "%s" already exists`, e.Title, e.Author)
 }
+
+// ErrVersionMismatch represents an error when a book is not at the version
+// a change was based on, meaning that someone else changed it meanwhile.
+type ErrVersionMismatch struct {
+	Id       int
+	Version  int
+	Expected int
+}
+
+func (e ErrVersionMismatch) Error() string {
+	return fmt.Sprintf("book with id %d is at version %d, not %d", e.Id, e.Version, e.Expected)
+}
@@ -4,26 +4,98 @@
 
 package models
 
-// Book represents the model for a book record.
+// Book represents the model for a book record. Version is bumped
+// on every update.
 type Book struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author"`
-	Pages  int    `json:"pages"`
+	Id      int    `json:"id"`
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Pages   int    `json:"pages"`
+	Version int    `json:"version"`
 }
 
 // NewBook is used to create a new book record.
 type NewBook struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title" validate:"required"`
-	Author string `json:"author" validate:"required"`
-	Pages  int    `json:"pages" validate:"required,gt=0"`
+	Id      int    `json:"id"`
+	Title   string `json:"title" validate:"required"`
+	Author  string `json:"author" validate:"required"`
+	Pages   int    `json:"pages" validate:"required,gt=0"`
+	Version int    `json:"version"`
 }
 
-// UpdateBook is used to update a book record.
+// UpdateBook is used to update a book record. Version is the version
+// the update is based on, if any, and the new version once updated.
 type UpdatedBook struct {
-	Id     int    `json:"id" validate:"required"`
-	Title  string `json:"title" validate:"required"`
-	Author string `json:"author" validate:"required"`
-	Pages  int    `json:"pages" validate:"required,gt=0"`
+	Id      int    `json:"id" validate:"required"`
+	Title   string `json:"title" validate:"required"`
+	Author  string `json:"author" validate:"required"`
+	Pages   int    `json:"pages" validate:"required,gt=0"`
+	Version int    `json:"version"`
+}
+
+// BookPatch is used to update some fields of a book record, nil fields
+// being left as they are. Version is the version the update is based on,
+// if any.
+type BookPatch struct {
+	Id      int     `json:"id" validate:"required"`
+	Title   *string `json:"title" validate:"omitempty,min=1"`
+	Author  *string `json:"author" validate:"omitempty,min=1"`
+	Pages   *int    `json:"pages" validate:"omitempty,gt=0"`
+	Version int     `json:"version"`
+}
+
+// DeletedBook identifies a book to be deleted.
+type DeletedBook struct {
+	Id int `json:"id" validate:"required"`
+}
+
+// BatchOperation is an operation of a batch, which creates or deletes
+// a book depending on which of its fields is set.
+type BatchOperation struct {
+	Create *NewBook
+	Delete *DeletedBook
+}
+
+// BatchResult is the outcome of an operation of a batch: the book it
+// created, or the error that made it fail.
+type BatchResult struct {
+	Book *Book
+	Err  error
+}
+
+// SearchResult represents a book matching a full-text search. Snippet
+// holds an excerpt with the matching terms highlighted, and Rank holds
+// the relevance of the match, the lower the better.
+type SearchResult struct {
+	Book
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// SearchParams holds the parameters of a full-text search.
+type SearchParams struct {
+	Query string `json:"query" validate:"required"`
+}
+
+// WatchParams holds the parameters of a watch over the changes of books.
+// AfterSequence is the sequence of the last change already seen, if any.
+type WatchParams struct {
+	AfterSequence int64 `json:"after_sequence" validate:"gte=0"`
+}
+
+// Operations recorded in the changes of books.
+const (
+	CreateOperation = "create"
+	UpdateOperation = "update"
+	DeleteOperation = "delete"
+)
+
+// Change represents a change made to a book: its creation, update or
+// deletion. Sequence orders the changes, so that a watcher can resume
+// after the last one it saw, and Book holds the book as it was once
+// the change was made, or right before its deletion.
+type Change struct {
+	Sequence  int64  `json:"sequence"`
+	Operation string `json:"operation"`
+	Book      Book   `json:"book"`
 }
@@ -0,0 +1,208 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint violation.
+const uniqueViolation = "23505"
+
+// changesLockKey identifies the advisory lock held by every transaction
+// changing books. Sequences of the book_changes table are handed out on
+// insert, not on commit: making writers take turns makes changes visible
+// in sequence order, so that watchers never skip one.
+const changesLockKey = 7325147062511394817
+
+// SQL queries that differ from their SQLite counterparts.
+const (
+	postgresCreateQuery = `
+	INSERT INTO books (title, author, pages)
+	VALUES ($1, $2, $3)
+	RETURNING id
+	`
+
+	lockChangesQuery = `SELECT pg_advisory_xact_lock($1)`
+
+	postgresSearchQuery = `
+	SELECT id, title, author, pages,
+		ts_headline('simple', title || ' ' || author, query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=16, MinWords=1'),
+		-ts_rank(search, query) AS rank
+	FROM books, to_tsquery('simple', $1) AS query
+	WHERE search @@ query
+	ORDER BY rank
+	LIMIT $2
+	`
+)
+
+// PostgresStore is a BookStore backed by a PostgreSQL database.
+type PostgresStore struct {
+	db *sql.DB
+	// feed is notified after every write, so that watchers read
+	// the changes recorded by the triggers on the books table.
+	feed *Feed
+}
+
+// NewPostgresStore creates a new PostgresStore for the given database.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, feed: NewFeed()}
+}
+
+// List retrieves all books. See List.
+func (s *PostgresStore) List(ctx context.Context) ([]*models.Book, error) {
+	return List(ctx, s.db)
+}
+
+// Stream calls fn for every book. See Stream.
+func (s *PostgresStore) Stream(ctx context.Context, fn func(book *models.Book) error) error {
+	return Stream(ctx, s.db, fn)
+}
+
+// GetById retrieves a book by its ID. See GetById.
+func (s *PostgresStore) GetById(ctx context.Context, bookId int) (*models.Book, error) {
+	return GetById(ctx, s.db, bookId)
+}
+
+// Create adds a new book record to the database. See postgresCreate.
+func (s *PostgresStore) Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
+	defer s.feed.Notify()
+	err := postgresWrite(ctx, s.db, func(ctx context.Context, tx *sql.Tx) (err error) {
+		newBook, err = postgresCreate(ctx, tx, newBook)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newBook, nil
+}
+
+// postgresCreate adds a new book record, getting its id back from
+// the insert statement, as PostgreSQL has no last insert id.
+func postgresCreate(ctx context.Context, db Querier, newBook *models.NewBook) (*models.NewBook, error) {
+	ctx, span := startSpan(ctx, "Create", postgresCreateQuery)
+	defer span.End()
+	row := db.QueryRowContext(ctx, postgresCreateQuery, newBook.Title, newBook.Author, newBook.Pages)
+	if err := row.Scan(&newBook.Id); err != nil {
+		if isDuplicateError(err) {
+			return nil, &bookErrors.ErrDuplicateBook{Title: newBook.Title, Author: newBook.Author}
+		}
+		return nil, spanError(span, errors.Wrap(err, "inserting book"))
+	}
+	newBook.Version = initialVersion
+	return newBook, nil
+}
+
+// Update modifies an existing book record. See Update.
+func (s *PostgresStore) Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	defer s.feed.Notify()
+	err := postgresWrite(ctx, s.db, func(ctx context.Context, tx *sql.Tx) (err error) {
+		book, err = Update(ctx, tx, book)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// Patch updates some fields of a book record. See Patch.
+func (s *PostgresStore) Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+	defer s.feed.Notify()
+	var book *models.Book
+	err := postgresWrite(ctx, s.db, func(ctx context.Context, tx *sql.Tx) (err error) {
+		// The lock keeps the book as it is until the title and
+		// author of a duplicate are read outside the transaction.
+		book, err = patchBook(ctx, tx, s.db, patch)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return book, nil
+}
+
+// DeleteById removes a book record by its ID. See DeleteById.
+func (s *PostgresStore) DeleteById(ctx context.Context, bookId int) error {
+	defer s.feed.Notify()
+	return postgresWrite(ctx, s.db, func(ctx context.Context, tx *sql.Tx) error {
+		return DeleteById(ctx, tx, bookId)
+	})
+}
+
+// Search performs a full-text search over book titles and authors,
+// returning the best ranked matches first along with a snippet where
+// the matching terms are highlighted.
+func (s *PostgresStore) Search(ctx context.Context, query string) ([]*models.SearchResult, error) {
+	return search(ctx, s.db, postgresSearchQuery, tsQueryExpression(query))
+}
+
+// Batch creates and deletes books within a single transaction. See Batch.
+func (s *PostgresStore) Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	defer s.feed.Notify()
+	return batch(ctx, s.db, ops, bestEffort, postgresBeginTx, postgresCreate)
+}
+
+// postgresBeginTx begins a transaction holding the lock that makes the
+// transactions changing books take turns until it ends.
+func postgresBeginTx(ctx context.Context, db *sql.DB) (*sql.Tx, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, lockChangesQuery, changesLockKey); err != nil {
+		tx.Rollback()
+		return nil, errors.Wrap(err, "locking book changes")
+	}
+	return tx, nil
+}
+
+// postgresWrite runs fn within a transaction begun by postgresBeginTx,
+// which is committed if fn succeeds.
+func postgresWrite(ctx context.Context, db *sql.DB, fn func(ctx context.Context, tx *sql.Tx) error) error {
+	ctx, span := startSpan(ctx, "Write", lockChangesQuery)
+	defer span.End()
+	tx, err := postgresBeginTx(ctx, db)
+	if err != nil {
+		return spanError(span, errors.Wrap(err, "beginning transaction"))
+	}
+	defer tx.Rollback()
+	if err := fn(ctx, tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return spanError(span, errors.Wrap(err, "committing transaction"))
+	}
+	return nil
+}
+
+// Watch calls fn for every change made to books after the given
+// sequence, then for every new one as it is made. See Watch.
+func (s *PostgresStore) Watch(ctx context.Context, after int64, fn func(change *models.Change) error) error {
+	return Watch(ctx, s.db, s.feed, after, fn)
+}
+
+// Ping checks that the database is reachable.
+func (s *PostgresStore) Ping(ctx context.Context) error {
+	return errors.Wrap(s.db.PingContext(ctx), "pinging database")
+}
+
+// tsQueryExpression turns free text into a tsquery in which every term
+// is quoted, so that user input cannot inject query syntax, and matched
+// as a prefix.
+func tsQueryExpression(query string) string {
+	terms := strings.Fields(query)
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `''`)
+	for i, term := range terms {
+		terms[i] = "'" + quote.Replace(term) + "':*"
+	}
+	return strings.Join(terms, " & ")
+}
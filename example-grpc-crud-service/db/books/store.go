@@ -0,0 +1,115 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
+)
+
+// BookStore defines the operations used to manage books,
+// independently of the storage backend.
+type BookStore interface {
+	List(ctx context.Context) ([]*models.Book, error)
+	Stream(ctx context.Context, fn func(book *models.Book) error) error
+	GetById(ctx context.Context, bookId int) (*models.Book, error)
+	Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error)
+	Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error)
+	Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error)
+	DeleteById(ctx context.Context, bookId int) error
+	Search(ctx context.Context, query string) ([]*models.SearchResult, error)
+	Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+	Watch(ctx context.Context, after int64, fn func(change *models.Change) error) error
+	Ping(ctx context.Context) error
+}
+
+// NewStore returns the BookStore matching the given database driver.
+func NewStore(driver string, sqlDb *sql.DB) (BookStore, error) {
+	switch driver {
+	case db.SqliteDriver:
+		return NewSqliteStore(sqlDb), nil
+	case db.PostgresDriver:
+		return NewPostgresStore(sqlDb), nil
+	}
+	return nil, errors.Errorf("no book store for database driver %q", driver)
+}
+
+// SqliteStore is a BookStore backed by a SQLite database.
+type SqliteStore struct {
+	db *sql.DB
+	// feed is notified after every write, so that watchers read
+	// the changes recorded by the triggers on the books table.
+	feed *Feed
+}
+
+// NewSqliteStore creates a new SqliteStore for the given database.
+func NewSqliteStore(db *sql.DB) *SqliteStore {
+	return &SqliteStore{db: db, feed: NewFeed()}
+}
+
+// List retrieves all books. See List.
+func (s *SqliteStore) List(ctx context.Context) ([]*models.Book, error) {
+	return List(ctx, s.db)
+}
+
+// Stream calls fn for every book. See Stream.
+func (s *SqliteStore) Stream(ctx context.Context, fn func(book *models.Book) error) error {
+	return Stream(ctx, s.db, fn)
+}
+
+// GetById retrieves a book by its ID. See GetById.
+func (s *SqliteStore) GetById(ctx context.Context, bookId int) (*models.Book, error) {
+	return GetById(ctx, s.db, bookId)
+}
+
+// Create adds a new book record. See Create.
+func (s *SqliteStore) Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
+	defer s.feed.Notify()
+	return Create(ctx, s.db, newBook)
+}
+
+// Update modifies an existing book record. See Update.
+func (s *SqliteStore) Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	defer s.feed.Notify()
+	return Update(ctx, s.db, book)
+}
+
+// Patch updates some fields of a book record. See Patch.
+func (s *SqliteStore) Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+	defer s.feed.Notify()
+	return Patch(ctx, s.db, patch)
+}
+
+// DeleteById removes a book record by its ID. See DeleteById.
+func (s *SqliteStore) DeleteById(ctx context.Context, bookId int) error {
+	defer s.feed.Notify()
+	return DeleteById(ctx, s.db, bookId)
+}
+
+// Search performs a full-text search over books. See Search.
+func (s *SqliteStore) Search(ctx context.Context, query string) ([]*models.SearchResult, error) {
+	return Search(ctx, s.db, query)
+}
+
+// Batch creates and deletes books within a single transaction. See Batch.
+func (s *SqliteStore) Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	defer s.feed.Notify()
+	return Batch(ctx, s.db, ops, bestEffort)
+}
+
+// Watch calls fn for every change made to books after the given
+// sequence, then for every new one as it is made. See Watch.
+func (s *SqliteStore) Watch(ctx context.Context, after int64, fn func(change *models.Change) error) error {
+	return Watch(ctx, s.db, s.feed, after, fn)
+}
+
+// Ping checks that the database is reachable.
+func (s *SqliteStore) Ping(ctx context.Context) error {
+	return errors.Wrap(s.db.PingContext(ctx), "pinging database")
+}
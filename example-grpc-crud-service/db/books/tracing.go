@@ -0,0 +1,41 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by this package.
+const tracerName = "github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books"
+
+// startSpan starts a client span named after the book operation,
+// such as books.List, around the given SQL statement.
+func startSpan(ctx context.Context, operation, stmt string) (context.Context, trace.Span) {
+	stmt = strings.Join(strings.Fields(stmt), " ")
+	sqlOperation, _, _ := strings.Cut(stmt, " ")
+	return otel.Tracer(tracerName).Start(ctx, "books."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBOperation(sqlOperation),
+			semconv.DBSQLTable("books"),
+			semconv.DBStatement(stmt),
+		),
+	)
+}
+
+// spanError records err in the span, marking it as failed,
+// and returns it.
+func spanError(span trace.Span, err error) error {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+	return err
+}
@@ -7,16 +7,47 @@ package db
 import (
 	"database/sql"
 
+	_ "github.com/lib/pq"
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 )
 
-// For ease of unit testing.
-var sqlOpen = sql.Open
+// Supported database drivers.
+const (
+	SqliteDriver   = "sqlite3"
+	PostgresDriver = "postgres"
+)
+
+// openFunc opens a database, as sql.Open does.
+type openFunc func(driverName, dataSourceName string) (*sql.DB, error)
+
+// Open establishes a connection to a database using the given driver,
+// which must be one of SqliteDriver or PostgresDriver.
+func Open(driver, dsn string) (*sql.DB, error) {
+	return open(sql.Open, driver, dsn)
+}
+
+// open is Open with the function opening the database given.
+func open(sqlOpen openFunc, driver, dsn string) (*sql.DB, error) {
+	if driver != SqliteDriver && driver != PostgresDriver {
+		return nil, errors.Errorf("unsupported database driver %q", driver)
+	}
+	db, err := sqlOpen(driver, dsn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "opening %s database", driver)
+	}
+	return db, nil
+}
 
 // ConnectToSqlite establishes a connection to a SQLite database.
 func ConnectToSqlite(sqliteFilePath string) (*sql.DB, error) {
-	db, err := sqlOpen("sqlite3", sqliteFilePath)
+	return connectToSqlite(sql.Open, sqliteFilePath)
+}
+
+// connectToSqlite is ConnectToSqlite with the function opening
+// the database given.
+func connectToSqlite(sqlOpen openFunc, sqliteFilePath string) (*sql.DB, error) {
+	db, err := sqlOpen(SqliteDriver, sqliteFilePath)
 	if err != nil {
 		return nil, errors.Wrapf(err, "opening sqlite file %s", sqliteFilePath)
 	}
@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS book_changes (
+    sequence INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation TEXT NOT NULL,
+    book_id INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL,
+    pages INTEGER NOT NULL,
+    version INTEGER NOT NULL
+);
+
+CREATE TRIGGER IF NOT EXISTS book_changes_after_insert AFTER INSERT ON books BEGIN
+    INSERT INTO book_changes (operation, book_id, title, author, pages, version)
+    VALUES ('create', new.id, new.title, new.author, new.pages, new.version);
+END;
+
+CREATE TRIGGER IF NOT EXISTS book_changes_after_update AFTER UPDATE ON books BEGIN
+    INSERT INTO book_changes (operation, book_id, title, author, pages, version)
+    VALUES ('update', new.id, new.title, new.author, new.pages, new.version);
+END;
+
+CREATE TRIGGER IF NOT EXISTS book_changes_after_delete AFTER DELETE ON books BEGIN
+    INSERT INTO book_changes (operation, book_id, title, author, pages, version)
+    VALUES ('delete', old.id, old.title, old.author, old.pages, old.version);
+END;
\ No newline at end of file
@@ -0,0 +1,49 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/examples/client/details"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func main() {
+	ctx := context.Background()
+	const serverHost = "localhost:4444"
+	conn, err := grpc.Dial(serverHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Println("failed to dial server: ", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	client := book.NewBookServiceClient(conn)
+	resp, err := client.BatchCreateBooks(ctx, &book.BatchCreateBooksRequest{
+		Books: []*book.Book{
+			{Title: "first title", Author: "author", Pages: 100},
+			{Title: "second title", Author: "author", Pages: 200},
+		},
+		BestEffort: true,
+	})
+	if err != nil {
+		fmt.Println("failed to create books: ", err)
+		details.Print(err)
+		os.Exit(1)
+	}
+	for _, result := range resp.GetResults() {
+		if err := status.FromProto(result.GetStatus()).Err(); err != nil {
+			fmt.Printf("failed to create book %d: %v\n", result.GetIndex(), err)
+			details.Print(err)
+			continue
+		}
+		fmt.Printf("created book %d: %v\n", result.GetIndex(), result.GetBook())
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"os"
 
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/examples/client/details"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -34,6 +35,7 @@ func main() {
 	})
 	if err != nil {
 		fmt.Println("failed to create book: ", err)
+		details.Print(err)
 	}
 	fmt.Printf("created book: %v\n", createdBook)
 }
@@ -10,6 +10,7 @@ import (
 	"os"
 
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/examples/client/details"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -27,6 +28,7 @@ func main() {
 	book, err := client.GetBook(ctx, &book.GetBookRequest{Id: 1})
 	if err != nil {
 		fmt.Println("failed to get book: ", err)
+		details.Print(err)
 	}
 	fmt.Printf("book: %v\n", book)
 }
@@ -0,0 +1,55 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/examples/client/details"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func main() {
+	ctx := context.Background()
+	const serverHost = "localhost:4444"
+	conn, err := grpc.Dial(serverHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Println("failed to dial server: ", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	client := book.NewBookServiceClient(conn)
+	stream, err := client.ImportBooks(ctx)
+	if err != nil {
+		fmt.Println("failed to import books: ", err)
+		os.Exit(1)
+	}
+	for i := 1; i <= 1000; i++ {
+		newBook := &book.Book{
+			Title:  fmt.Sprintf("title %d", i),
+			Author: "author",
+			Pages:  100,
+		}
+		// The server stopped receiving: the reason comes with the reply.
+		if err := stream.Send(&book.CreateBookRequest{Book: newBook}); err == io.EOF {
+			break
+		} else if err != nil {
+			fmt.Println("failed to send book: ", err)
+			os.Exit(1)
+		}
+	}
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		fmt.Println("failed to import books: ", err)
+		details.Print(err)
+		os.Exit(1)
+	}
+	fmt.Printf("import summary: %v\n", summary)
+}
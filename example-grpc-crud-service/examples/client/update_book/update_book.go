@@ -10,6 +10,7 @@ import (
 	"os"
 
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/examples/client/details"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 )
@@ -24,6 +25,14 @@ func main() {
 	}
 	defer conn.Close()
 	client := book.NewBookServiceClient(conn)
+	// The update is based on the current version of the book, so that it
+	// fails with ABORTED should someone else change the book meanwhile.
+	currentBook, err := client.GetBook(ctx, &book.GetBookRequest{Id: 1})
+	if err != nil {
+		fmt.Println("failed to get book: ", err)
+		details.Print(err)
+		os.Exit(1)
+	}
 	bookToBeUpdated := &book.Book{
 		Id:     1,
 		Title:  "new title",
@@ -31,10 +40,12 @@ func main() {
 		Pages:  150,
 	}
 	updatedBook, err := client.UpdateBook(ctx, &book.UpdateBookRequest{
-		Book: bookToBeUpdated,
+		Book:            bookToBeUpdated,
+		ExpectedVersion: currentBook.GetVersion(),
 	})
 	if err != nil {
 		fmt.Println("failed to update book: ", err)
+		details.Print(err)
 	}
 	fmt.Printf("updated book: %v\n", updatedBook)
 }
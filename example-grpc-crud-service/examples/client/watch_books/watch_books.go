@@ -0,0 +1,58 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	// Resumes after the sequence given as argument, if any.
+	var afterSequence int64
+	if len(os.Args) > 1 {
+		sequence, err := strconv.ParseInt(os.Args[1], 10, 64)
+		if err != nil {
+			fmt.Println("invalid sequence: ", err)
+			os.Exit(1)
+		}
+		afterSequence = sequence
+	}
+	const serverHost = "localhost:4444"
+	conn, err := grpc.Dial(serverHost, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		fmt.Println("failed to dial server: ", err)
+		os.Exit(1)
+	}
+	defer conn.Close()
+	client := book.NewBookServiceClient(conn)
+	stream, err := client.WatchBooks(ctx, &book.WatchBooksRequest{AfterSequence: afterSequence})
+	if err != nil {
+		fmt.Println("failed to watch books: ", err)
+		os.Exit(1)
+	}
+	for {
+		change, err := stream.Recv()
+		if status.Code(err) == codes.Canceled {
+			break
+		}
+		if err != nil {
+			fmt.Println("failed to receive change: ", err)
+			os.Exit(1)
+		}
+		fmt.Printf("%v\n", change)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package interceptors
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// reasonInternal is the reason of the google.rpc.ErrorInfo sent along
+// with internal errors.
+const reasonInternal = "INTERNAL"
+
+// UnaryErrors is an interceptor that turns the errors returned by unary
+// handlers without a gRPC status, which are unexpected, into internal
+// errors. See internalError.
+func UnaryErrors(logger *slog.Logger, verbose bool) grpc.UnaryServerInterceptor {
+	return unaryErrors(logger, verbose, newIncidentId)
+}
+
+// unaryErrors is UnaryErrors with the function generating
+// incident ids given.
+func unaryErrors(logger *slog.Logger, verbose bool, newIncidentId func() string) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		resp, err := handler(ctx, req)
+		if err != nil {
+			err = internalError(logger, ctx, info.FullMethod, err, verbose, newIncidentId)
+		}
+		return resp, err
+	}
+}
+
+// StreamErrors is an interceptor that turns the errors returned by
+// streaming handlers without a gRPC status, which are unexpected, into
+// internal errors. See internalError.
+func StreamErrors(logger *slog.Logger, verbose bool) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		err := handler(srv, ss)
+		if err != nil {
+			err = internalError(logger, ss.Context(), info.FullMethod, err, verbose, newIncidentId)
+		}
+		return err
+	}
+}
+
+// internalError logs an error that has no gRPC status under a new
+// incident id, generated by newIncidentId, and returns a codes.Internal
+// status which tells the client nothing but that id, in a
+// google.rpc.ErrorInfo, so that the error's text, which may disclose
+// details such as SQL statements, is not leaked. If verbose is set, as
+// is useful in development, the status message is the error's text.
+// Errors that already have a gRPC status are meant for the client, and
+// are returned as they are, while context errors are turned into
+// codes.Canceled or codes.DeadlineExceeded.
+func internalError(logger *slog.Logger, ctx context.Context, method string, err error, verbose bool, newIncidentId func() string) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err).Err()
+	}
+	incidentId := newIncidentId()
+	logger.ErrorContext(ctx, "internal error",
+		slog.String("method", method),
+		slog.String("incident", incidentId),
+		slog.Any("err", err),
+	)
+	message := "internal error"
+	if verbose {
+		message = err.Error()
+	}
+	service, _ := splitMethod(method)
+	st, detailsErr := status.New(codes.Internal, message).WithDetails(&errdetails.ErrorInfo{
+		Reason:   reasonInternal,
+		Domain:   service,
+		Metadata: map[string]string{"incident": incidentId},
+	})
+	if detailsErr != nil {
+		return status.Error(codes.Internal, message)
+	}
+	return st.Err()
+}
+
+// newIncidentId generates a random id for an internal error.
+func newIncidentId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,147 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+// Package interceptors provides the gRPC interceptors applied to every RPC.
+package interceptors
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"time"
+
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// unknownPeer is logged when the client's address is not available.
+const unknownPeer = "unknown"
+
+// UnaryLogger is an interceptor that logs the method, status code,
+// duration and peer of each unary RPC, along with the id of the book
+// it is about, if any.
+func UnaryLogger(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		attrs := rpcAttrs(ctx, info.FullMethod, err, time.Since(start))
+		if id, ok := bookId(req, resp); ok {
+			attrs = append(attrs, slog.Int("bookid", int(id)))
+		}
+		logRpc(logger, ctx, err, attrs)
+		return resp, err
+	}
+}
+
+// StreamLogger is an interceptor that logs the method, status code,
+// duration and peer of each streaming RPC.
+func StreamLogger(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		err := handler(srv, ss)
+		logRpc(logger, ss.Context(), err, rpcAttrs(ss.Context(), info.FullMethod, err, time.Since(start)))
+		return err
+	}
+}
+
+// rpcAttrs returns the attributes logged for every RPC.
+func rpcAttrs(ctx context.Context, method string, err error, duration time.Duration) []slog.Attr {
+	attrs := []slog.Attr{
+		slog.String("method", method),
+		slog.String("code", status.Code(err).String()),
+		slog.Duration("duration", duration),
+		slog.String("peer", peerAddr(ctx)),
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("err", status.Convert(err).Message()))
+	}
+	return attrs
+}
+
+// logRpc logs a completed RPC. Calls that failed because of the server,
+// rather than the client, are logged as errors.
+func logRpc(logger *slog.Logger, ctx context.Context, err error, attrs []slog.Attr) {
+	level := slog.LevelInfo
+	if serverError(status.Code(err)) {
+		level = slog.LevelError
+	}
+	logger.LogAttrs(ctx, level, "rpc completed", attrs...)
+}
+
+// serverError tells whether the given code reports a server-side failure.
+func serverError(code codes.Code) bool {
+	switch code {
+	case codes.Unknown, codes.Internal, codes.Unimplemented, codes.Unavailable, codes.DataLoss:
+		return true
+	}
+	return false
+}
+
+// bookId returns the id of the book an RPC is about, taken from the
+// request or, for calls such as CreateBook, from the response.
+func bookId(req, resp any) (int32, bool) {
+	switch r := req.(type) {
+	case interface{ GetId() int32 }:
+		return r.GetId(), true
+	case interface{ GetBook() *book.Book }:
+		if id := r.GetBook().GetId(); id != 0 {
+			return id, true
+		}
+	}
+	if b, ok := resp.(*book.Book); ok && b.GetId() != 0 {
+		return b.GetId(), true
+	}
+	return 0, false
+}
+
+// peerAddr returns the address of the client that made the call.
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return unknownPeer
+	}
+	return p.Addr.String()
+}
+
+// UnaryRecovery is an interceptor that recovers from panics in unary
+// handlers, logging the stack trace and failing the call with
+// codes.Internal instead of crashing the process.
+func UnaryRecovery(logger *slog.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(logger, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// StreamRecovery is an interceptor that recovers from panics in streaming
+// handlers, logging the stack trace and failing the call with
+// codes.Internal instead of crashing the process.
+func StreamRecovery(logger *slog.Logger) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recovered(logger, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+// recovered logs a recovered panic and returns the error sent to the client,
+// which does not disclose the panic's details.
+func recovered(logger *slog.Logger, method string, r any) error {
+	logger.Error("panic recovered",
+		slog.String("method", method),
+		slog.Any("panic", r),
+		slog.String("stack", string(debug.Stack())),
+	)
+	return status.Error(codes.Internal, "internal error")
+}
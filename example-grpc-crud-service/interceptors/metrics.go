@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package interceptors
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// RPC types, used as the value of the type label.
+const (
+	unaryRpc        = "unary"
+	clientStreamRpc = "client_stream"
+	serverStreamRpc = "server_stream"
+	bidiStreamRpc   = "bidi_stream"
+)
+
+// RpcMetrics holds the Prometheus collectors updated by the metrics
+// interceptors.
+type RpcMetrics struct {
+	handled  *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	inFlight *prometheus.GaugeVec
+}
+
+// NewRpcMetrics creates the RPC collectors and registers them
+// in the given registry.
+func NewRpcMetrics(reg prometheus.Registerer) *RpcMetrics {
+	m := &RpcMetrics{
+		handled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "books",
+			Subsystem: "grpc_server",
+			Name:      "handled_total",
+			Help:      "Number of RPCs completed, by service, method, type and status code.",
+		}, []string{"service", "method", "type", "code"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "books",
+			Subsystem: "grpc_server",
+			Name:      "handling_seconds",
+			Help:      "Latency of RPCs, by service, method, type and status code.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"service", "method", "type", "code"}),
+		inFlight: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "books",
+			Subsystem: "grpc_server",
+			Name:      "in_flight",
+			Help:      "Number of RPCs being handled, by service, method and type.",
+		}, []string{"service", "method", "type"}),
+	}
+	reg.MustRegister(m.handled, m.duration, m.inFlight)
+	return m
+}
+
+// UnaryMetrics is an interceptor that records the number, latency and
+// status code of unary RPCs, along with how many are in flight.
+func UnaryMetrics(m *RpcMetrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		done := m.start(info.FullMethod, unaryRpc)
+		resp, err := handler(ctx, req)
+		done(err)
+		return resp, err
+	}
+}
+
+// StreamMetrics is an interceptor that records the number, latency and
+// status code of streaming RPCs, along with how many are in flight.
+func StreamMetrics(m *RpcMetrics) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		done := m.start(info.FullMethod, streamType(info))
+		err := handler(srv, ss)
+		done(err)
+		return err
+	}
+}
+
+// start records an RPC as in flight and returns the function that
+// records its completion.
+func (m *RpcMetrics) start(fullMethod, rpcType string) func(err error) {
+	start := time.Now()
+	service, method := splitMethod(fullMethod)
+	inFlight := m.inFlight.WithLabelValues(service, method, rpcType)
+	inFlight.Inc()
+	return func(err error) {
+		inFlight.Dec()
+		code := status.Code(err).String()
+		m.handled.WithLabelValues(service, method, rpcType, code).Inc()
+		m.duration.WithLabelValues(service, method, rpcType, code).Observe(time.Since(start).Seconds())
+	}
+}
+
+// splitMethod splits a full method name, such as /books.BookService/GetBook,
+// into service and method.
+func splitMethod(fullMethod string) (string, string) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok {
+		return "unknown", "unknown"
+	}
+	return service, method
+}
+
+// streamType tells which kind of streaming RPC is being handled.
+func streamType(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return bidiStreamRpc
+	case info.IsClientStream:
+		return clientStreamRpc
+	}
+	return serverStreamRpc
+}
@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	testCases := []struct {
+		name           string
+		format         string
+		level          string
+		expectedOutput []string
+		expectedError  error
+	}{
+		{
+			name:           "text",
+			format:         TextFormat,
+			level:          InfoLevel,
+			expectedOutput: []string{"level=INFO msg=info method=/books.BookService/GetBook\n"},
+		},
+		{
+			name:           "json",
+			format:         JsonFormat,
+			level:          InfoLevel,
+			expectedOutput: []string{`"level":"INFO","msg":"info","method":"/books.BookService/GetBook"}` + "\n"},
+		},
+		{
+			name:   "debug level",
+			format: TextFormat,
+			level:  DebugLevel,
+			expectedOutput: []string{
+				"level=DEBUG msg=debug method=/books.BookService/GetBook\n",
+				"level=INFO msg=info method=/books.BookService/GetBook\n",
+			},
+		},
+		{
+			name:   "error level",
+			format: TextFormat,
+			level:  ErrorLevel,
+		},
+		{
+			name:          "unknown format",
+			format:        "xml",
+			level:         InfoLevel,
+			expectedError: errors.New(`unknown log format "xml"`),
+		},
+		{
+			name:          "unknown level",
+			format:        TextFormat,
+			level:         "trace",
+			expectedError: errors.New(`unknown log level "trace"`),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var out bytes.Buffer
+			logger, err := New(&out, tc.format, tc.level)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				logger.Debug("debug", "method", "/books.BookService/GetBook")
+				logger.Info("info", "method", "/books.BookService/GetBook")
+				lines := strings.SplitAfter(out.String(), "\n")
+				require.Len(t, lines, len(tc.expectedOutput)+1)
+				for i, expected := range tc.expectedOutput {
+					require.True(t, strings.HasSuffix(lines[i], expected), lines[i])
+				}
+			}
+		})
+	}
+}
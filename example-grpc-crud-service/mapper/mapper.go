@@ -21,25 +21,53 @@ func NewBookDbModel(book *book.Book) *models.NewBook {
 	}
 }
 
-// UpdatedBookDbModel converts a Book protobuf message to an UpdatedBook database model.
+// UpdatedBookDbModel converts a Book protobuf message to an UpdatedBook database model,
+// expected to be at the given version, if any.
 // It is used when updating an existing book entry in the database.
-func UpdatedBookDbModel(book *book.Book) *models.UpdatedBook {
+func UpdatedBookDbModel(book *book.Book, expectedVersion int32) *models.UpdatedBook {
 	return &models.UpdatedBook{
-		Id:     int(book.GetId()),
-		Title:  book.GetTitle(),
-		Author: book.GetAuthor(),
-		Pages:  int(book.GetPages()),
+		Id:      int(book.GetId()),
+		Title:   book.GetTitle(),
+		Author:  book.GetAuthor(),
+		Pages:   int(book.GetPages()),
+		Version: int(expectedVersion),
 	}
 }
 
+// BookPatchDbModel converts the fields of a Book protobuf message listed in an
+// update mask's paths to a BookPatch database model, expected to be at the given
+// version, if any. Paths that are not fields of a book are ignored.
+// It is used when updating some fields of an existing book entry in the database.
+func BookPatchDbModel(book *book.Book, paths []string, expectedVersion int32) *models.BookPatch {
+	bookPatch := &models.BookPatch{
+		Id:      int(book.GetId()),
+		Version: int(expectedVersion),
+	}
+	for _, path := range paths {
+		switch path {
+		case "title":
+			title := book.GetTitle()
+			bookPatch.Title = &title
+		case "author":
+			author := book.GetAuthor()
+			bookPatch.Author = &author
+		case "pages":
+			pages := int(book.GetPages())
+			bookPatch.Pages = &pages
+		}
+	}
+	return bookPatch
+}
+
 // BookProto converts a Book database model to a Book protobuf message.
 // It is typically used when sending book data back to the client.
 func BookProto(dbBook *models.Book) *book.Book {
 	return &book.Book{
-		Id:     int32(dbBook.Id),
-		Title:  dbBook.Title,
-		Author: dbBook.Author,
-		Pages:  int32(dbBook.Pages),
+		Id:      int32(dbBook.Id),
+		Title:   dbBook.Title,
+		Author:  dbBook.Author,
+		Pages:   int32(dbBook.Pages),
+		Version: int32(dbBook.Version),
 	}
 }
 
@@ -49,12 +77,47 @@ func BookProtoList(dbBooks []*models.Book) []*book.Book {
 	bookProtoList := []*book.Book{}
 	for _, dbBook := range dbBooks {
 		bookProto := &book.Book{
-			Id:     int32(dbBook.Id),
-			Title:  dbBook.Title,
-			Author: dbBook.Author,
-			Pages:  int32(dbBook.Pages),
+			Id:      int32(dbBook.Id),
+			Title:   dbBook.Title,
+			Author:  dbBook.Author,
+			Pages:   int32(dbBook.Pages),
+			Version: int32(dbBook.Version),
 		}
 		bookProtoList = append(bookProtoList, bookProto)
 	}
 	return bookProtoList
 }
+
+// SearchResultProtoList converts a list of SearchResult database models to a slice
+// of SearchResult protobuf messages.
+// It is used when sending full-text search results back to the client.
+func SearchResultProtoList(dbResults []*models.SearchResult) []*book.SearchResult {
+	searchResultProtoList := []*book.SearchResult{}
+	for _, dbResult := range dbResults {
+		searchResultProto := &book.SearchResult{
+			Book:    BookProto(&dbResult.Book),
+			Snippet: dbResult.Snippet,
+			Rank:    dbResult.Rank,
+		}
+		searchResultProtoList = append(searchResultProtoList, searchResultProto)
+	}
+	return searchResultProtoList
+}
+
+// changeTypes maps the operations recorded in book changes to their
+// ChangeType protobuf enum values.
+var changeTypes = map[string]book.ChangeType{
+	models.CreateOperation: book.ChangeType_CHANGE_TYPE_CREATED,
+	models.UpdateOperation: book.ChangeType_CHANGE_TYPE_UPDATED,
+	models.DeleteOperation: book.ChangeType_CHANGE_TYPE_DELETED,
+}
+
+// BookChangeProto converts a Change database model to a BookChange protobuf message.
+// It is used when streaming the changes made to books back to the client.
+func BookChangeProto(dbChange *models.Change) *book.BookChange {
+	return &book.BookChange{
+		Sequence: dbChange.Sequence,
+		Type:     changeTypes[dbChange.Operation],
+		Book:     BookProto(&dbChange.Book),
+	}
+}
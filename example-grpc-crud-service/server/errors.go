@@ -0,0 +1,98 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package server
+
+import (
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
+	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/errors"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/validate"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+)
+
+// Reasons given in the google.rpc.ErrorInfo details, which identify
+// the cause of an error within errorDomain.
+const (
+	reasonBookNotFound    = "BOOK_NOT_FOUND"
+	reasonDuplicateBook   = "DUPLICATE_BOOK"
+	reasonVersionMismatch = "VERSION_MISMATCH"
+)
+
+// errorDomain is the logical grouping of the reasons above.
+var errorDomain = book.BookService_ServiceDesc.ServiceName
+
+// invalidArgumentError returns a codes.InvalidArgument status carrying
+// a google.rpc.BadRequest with one field violation per invalid field.
+// The fields are given as paths within the request message, that is,
+// prefixed by the given parent field, if any.
+func invalidArgumentError(err error, parent string) error {
+	var fieldErrors validate.FieldErrors
+	if !errors.As(err, &fieldErrors) {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	badRequest := &errdetails.BadRequest{}
+	for _, fe := range fieldErrors {
+		field := fe.Field
+		if parent != "" {
+			field = parent + "." + field
+		}
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: fe.Error,
+		})
+	}
+	return withDetails(status.New(codes.InvalidArgument, "the request has invalid fields"), badRequest)
+}
+
+// notFoundError returns a codes.NotFound status carrying a
+// google.rpc.ErrorInfo with the id of the missing book.
+func notFoundError(err *bookErrors.ErrBookNotFound) error {
+	return withDetails(status.New(codes.NotFound, err.Error()), &errdetails.ErrorInfo{
+		Reason:   reasonBookNotFound,
+		Domain:   errorDomain,
+		Metadata: map[string]string{"id": strconv.Itoa(err.Id)},
+	})
+}
+
+// alreadyExistsError returns a codes.AlreadyExists status carrying a
+// google.rpc.ErrorInfo with the title and author of the duplicate book.
+func alreadyExistsError(err *bookErrors.ErrDuplicateBook) error {
+	return withDetails(status.New(codes.AlreadyExists, err.Error()), &errdetails.ErrorInfo{
+		Reason:   reasonDuplicateBook,
+		Domain:   errorDomain,
+		Metadata: map[string]string{"title": err.Title, "author": err.Author},
+	})
+}
+
+// abortedError returns a codes.Aborted status carrying a
+// google.rpc.ErrorInfo with the id of the book, its current version
+// and the version the change was based on, so that the client can
+// fetch the book again and retry.
+func abortedError(err *bookErrors.ErrVersionMismatch) error {
+	return withDetails(status.New(codes.Aborted, err.Error()), &errdetails.ErrorInfo{
+		Reason: reasonVersionMismatch,
+		Domain: errorDomain,
+		Metadata: map[string]string{
+			"id":               strconv.Itoa(err.Id),
+			"version":          strconv.Itoa(err.Version),
+			"expected_version": strconv.Itoa(err.Expected),
+		},
+	})
+}
+
+// withDetails attaches the given details to st and returns it as an error.
+// Should the details fail to be marshaled, st is returned without them.
+func withDetails(st *status.Status, details ...protoadapt.MessageV1) error {
+	withDetails, err := st.WithDetails(details...)
+	if err != nil {
+		return st.Err()
+	}
+	return withDetails.Err()
+}
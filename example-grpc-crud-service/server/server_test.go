@@ -6,28 +6,142 @@ package server
 
 import (
 	"context"
-	"database/sql"
 	"errors"
 	"io"
-	"log"
+	"log/slog"
+	"net"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/stretchr/testify/require"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/api/proto/gen/book"
 	bookErrors "github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/errors"
 	"github.com/tiagomelo/go-templates/example-grpc-crud-service/db/books/models"
+	"github.com/tiagomelo/go-templates/example-grpc-crud-service/interceptors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	rpcStatus "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
 )
 
+// mockStore is a books.BookStore whose behavior is set per test case.
+type mockStore struct {
+	list       func(ctx context.Context) ([]*models.Book, error)
+	stream     func(ctx context.Context, fn func(book *models.Book) error) error
+	getById    func(ctx context.Context, bookId int) (*models.Book, error)
+	create     func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error)
+	update     func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error)
+	patch      func(ctx context.Context, patch *models.BookPatch) (*models.Book, error)
+	deleteById func(ctx context.Context, bookId int) error
+	search     func(ctx context.Context, query string) ([]*models.SearchResult, error)
+	batch      func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+	watch      func(ctx context.Context, after int64, fn func(change *models.Change) error) error
+	ping       func(ctx context.Context) error
+}
+
+func (m *mockStore) List(ctx context.Context) ([]*models.Book, error) {
+	return m.list(ctx)
+}
+
+func (m *mockStore) Stream(ctx context.Context, fn func(book *models.Book) error) error {
+	return m.stream(ctx, fn)
+}
+
+func (m *mockStore) GetById(ctx context.Context, bookId int) (*models.Book, error) {
+	return m.getById(ctx, bookId)
+}
+
+func (m *mockStore) Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
+	return m.create(ctx, newBook)
+}
+
+func (m *mockStore) Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	return m.update(ctx, book)
+}
+
+func (m *mockStore) Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+	return m.patch(ctx, patch)
+}
+
+func (m *mockStore) DeleteById(ctx context.Context, bookId int) error {
+	return m.deleteById(ctx, bookId)
+}
+
+func (m *mockStore) Search(ctx context.Context, query string) ([]*models.SearchResult, error) {
+	return m.search(ctx, query)
+}
+
+func (m *mockStore) Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	return m.batch(ctx, ops, bestEffort)
+}
+
+func (m *mockStore) Watch(ctx context.Context, after int64, fn func(change *models.Change) error) error {
+	return m.watch(ctx, after, fn)
+}
+
+func (m *mockStore) Ping(ctx context.Context) error {
+	return m.ping(ctx)
+}
+
+// newRpcMetrics returns RPC metrics registered in a throwaway registry.
+func newRpcMetrics() *interceptors.RpcMetrics {
+	return interceptors.NewRpcMetrics(prometheus.NewRegistry())
+}
+
+// bufSize is the size of the in-memory connection buffer used in tests.
+const bufSize = 1024 * 1024
+
+// testImportBatchSize is the number of books per transaction of the
+// servers under test, small enough for imports to span several batches.
+const testImportBatchSize = 2
+
+// newBufconnClient starts the given server on an in-memory listener
+// and returns a client connected to it.
+func newBufconnClient(t *testing.T, s *server) book.BookServiceClient {
+	return book.NewBookServiceClient(newBufconnConn(t, s))
+}
+
+// newBufconnConn starts the given server on an in-memory listener
+// and returns a connection to it.
+func newBufconnConn(t *testing.T, s *server) *grpc.ClientConn {
+	lis := bufconn.Listen(bufSize)
+	go s.GrpcSrv.Serve(lis)
+	t.Cleanup(s.GrpcSrv.Stop)
+	conn, err := grpc.DialContext(context.TODO(), "bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
 func TestGetAllBooks(t *testing.T) {
 	testCases := []struct {
 		name           string
-		mockListBooks  func(ctx context.Context, db *sql.DB) ([]*models.Book, error)
+		mockListBooks  func(ctx context.Context) ([]*models.Book, error)
 		expectedOutput *book.GetAllBooksResponse
 		expectedError  error
 	}{
 		{
 			name: "happy path",
-			mockListBooks: func(ctx context.Context, db *sql.DB) ([]*models.Book, error) {
+			mockListBooks: func(ctx context.Context) ([]*models.Book, error) {
 				return []*models.Book{
 					{
 						Id:     1,
@@ -62,17 +176,18 @@ func TestGetAllBooks(t *testing.T) {
 		},
 		{
 			name: "error",
-			mockListBooks: func(ctx context.Context, db *sql.DB) ([]*models.Book, error) {
+			mockListBooks: func(ctx context.Context) ([]*models.Book, error) {
 				return nil, errors.New("list books error")
 			},
-			expectedError: errors.New("rpc error: code = Internal desc = getting all books: list books error"),
+			expectedError: errors.New("getting all books: list books error"),
 		},
 	}
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			listBooks = tc.mockListBooks
-			logger := log.New(io.Discard, "", 0)
-			s := New(logger, nil)
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{list: tc.mockListBooks}, newRpcMetrics(), testImportBatchSize, false, false)
 			output, err := s.GetAllBooks(context.TODO(), &book.GetAllBooksRequest{})
 			if err != nil {
 				if tc.expectedError == nil {
@@ -92,13 +207,13 @@ func TestGetAllBooks(t *testing.T) {
 func TestGetBook(t *testing.T) {
 	testCases := []struct {
 		name            string
-		mockGetBookById func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error)
+		mockGetBookById func(ctx context.Context, bookId int) (*models.Book, error)
 		expectedOutput  *book.Book
 		expectedError   error
 	}{
 		{
 			name: "happy path",
-			mockGetBookById: func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
 				return &models.Book{
 					Id:     1,
 					Title:  "title",
@@ -115,24 +230,25 @@ func TestGetBook(t *testing.T) {
 		},
 		{
 			name: "does not exist",
-			mockGetBookById: func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
 				return nil, &bookErrors.ErrBookNotFound{Id: 1}
 			},
 			expectedError: errors.New("rpc error: code = NotFound desc = no book with id 1 found"),
 		},
 		{
 			name: "error",
-			mockGetBookById: func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
 				return nil, errors.New("get book error")
 			},
-			expectedError: errors.New("rpc error: code = Internal desc = getting book with id 1: get book error"),
+			expectedError: errors.New("getting book with id 1: get book error"),
 		},
 	}
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			getBookById = tc.mockGetBookById
-			logger := log.New(io.Discard, "", 0)
-			s := New(logger, nil)
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{getById: tc.mockGetBookById}, newRpcMetrics(), testImportBatchSize, false, false)
 			output, err := s.GetBook(context.TODO(), &book.GetBookRequest{Id: 1})
 			if err != nil {
 				if tc.expectedError == nil {
@@ -153,7 +269,7 @@ func TestCreateBook(t *testing.T) {
 	testCases := []struct {
 		name           string
 		input          *book.CreateBookRequest
-		mockCreateBook func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error)
+		mockCreateBook func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error)
 		expectedOutput *book.Book
 		expectedError  error
 	}{
@@ -166,19 +282,21 @@ func TestCreateBook(t *testing.T) {
 					Pages:  100,
 				},
 			},
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return &models.NewBook{
-					Id:     1,
-					Title:  "title",
-					Author: "author",
-					Pages:  100,
+					Id:      1,
+					Title:   "title",
+					Author:  "author",
+					Pages:   100,
+					Version: 1,
 				}, nil
 			},
 			expectedOutput: &book.Book{
-				Id:     1,
-				Title:  "title",
-				Author: "author",
-				Pages:  100,
+				Id:      1,
+				Title:   "title",
+				Author:  "author",
+				Pages:   100,
+				Version: 1,
 			},
 		},
 		{
@@ -186,7 +304,7 @@ func TestCreateBook(t *testing.T) {
 			input: &book.CreateBookRequest{
 				Book: &book.Book{},
 			},
-			expectedError: errors.New(`rpc error: code = InvalidArgument desc = [{"field":"title","error":"title is a required field"},{"field":"author","error":"author is a required field"},{"field":"pages","error":"pages is a required field"}]`),
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
 		},
 		{
 			name: "already exists",
@@ -197,7 +315,7 @@ func TestCreateBook(t *testing.T) {
 					Pages:  100,
 				},
 			},
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return nil, &bookErrors.ErrDuplicateBook{
 					Title:  "title",
 					Author: "author",
@@ -214,17 +332,18 @@ func TestCreateBook(t *testing.T) {
 					Pages:  100,
 				},
 			},
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return nil, errors.New("create book error")
 			},
-			expectedError: errors.New("rpc error: code = Internal desc = create book error"),
+			expectedError: errors.New("create book error"),
 		},
 	}
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			createBook = tc.mockCreateBook
-			logger := log.New(io.Discard, "", 0)
-			s := New(logger, nil)
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{create: tc.mockCreateBook}, newRpcMetrics(), testImportBatchSize, false, false)
 			output, err := s.CreateBook(context.TODO(), tc.input)
 			if err != nil {
 				if tc.expectedError == nil {
@@ -245,7 +364,7 @@ func TestUpdateBook(t *testing.T) {
 	testCases := []struct {
 		name           string
 		input          *book.UpdateBookRequest
-		mockUpdateBook func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error)
+		mockUpdateBook func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error)
 		expectedOutput *book.Book
 		expectedError  error
 	}{
@@ -258,20 +377,23 @@ func TestUpdateBook(t *testing.T) {
 					Author: "new author",
 					Pages:  150,
 				},
+				ExpectedVersion: 2,
 			},
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return &models.UpdatedBook{
-					Id:     1,
-					Title:  "new title",
-					Author: "new author",
-					Pages:  150,
+					Id:      1,
+					Title:   "new title",
+					Author:  "new author",
+					Pages:   150,
+					Version: book.Version + 1,
 				}, nil
 			},
 			expectedOutput: &book.Book{
-				Id:     1,
-				Title:  "new title",
-				Author: "new author",
-				Pages:  150,
+				Id:      1,
+				Title:   "new title",
+				Author:  "new author",
+				Pages:   150,
+				Version: 3,
 			},
 		},
 		{
@@ -279,7 +401,7 @@ func TestUpdateBook(t *testing.T) {
 			input: &book.UpdateBookRequest{
 				Book: &book.Book{},
 			},
-			expectedError: errors.New(`rpc error: code = InvalidArgument desc = [{"field":"id","error":"id is a required field"},{"field":"title","error":"title is a required field"},{"field":"author","error":"author is a required field"},{"field":"pages","error":"pages is a required field"}]`),
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
 		},
 		{
 			name: "does not exist",
@@ -291,11 +413,42 @@ func TestUpdateBook(t *testing.T) {
 					Pages:  150,
 				},
 			},
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return nil, &bookErrors.ErrBookNotFound{Id: 1}
 			},
 			expectedError: errors.New("rpc error: code = NotFound desc = no book with id 1 found"),
 		},
+		{
+			name: "version mismatch",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:     1,
+					Title:  "new title",
+					Author: "new author",
+					Pages:  150,
+				},
+				ExpectedVersion: 2,
+			},
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+				return nil, &bookErrors.ErrVersionMismatch{Id: 1, Version: 3, Expected: book.Version}
+			},
+			expectedError: errors.New("rpc error: code = Aborted desc = book with id 1 is at version 3, not 2"),
+		},
+		{
+			name: "already exists",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:     1,
+					Title:  "new title",
+					Author: "new author",
+					Pages:  150,
+				},
+			},
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+				return nil, &bookErrors.ErrDuplicateBook{Title: book.Title, Author: book.Author}
+			},
+			expectedError: errors.New(`rpc error: code = AlreadyExists desc = book with title "new title" from author "new author" already exists`),
+		},
 		{
 			name: "error",
 			input: &book.UpdateBookRequest{
@@ -306,23 +459,181 @@ func TestUpdateBook(t *testing.T) {
 					Pages:  150,
 				},
 			},
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return nil, errors.New("update book error")
 			},
-			expectedError: errors.New("rpc error: code = Internal desc = update book error"),
+			expectedError: errors.New("update book error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{update: tc.mockUpdateBook}, newRpcMetrics(), testImportBatchSize, false, false)
+			output, err := s.UpdateBook(context.TODO(), tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
+func TestUpdateBookWithMask(t *testing.T) {
+	testCases := []struct {
+		name               string
+		input              *book.UpdateBookRequest
+		mockPatchBook      func(ctx context.Context, patch *models.BookPatch) (*models.Book, error)
+		expectedOutput     *book.Book
+		expectedViolations map[string]string
+		expectedError      error
+	}{
+		{
+			name: "happy path",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:    1,
+					Pages: 200,
+				},
+				ExpectedVersion: 2,
+				UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"pages"}},
+			},
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				if patch.Id != 1 || patch.Title != nil || patch.Author != nil || patch.Pages == nil || *patch.Pages != 200 || patch.Version != 2 {
+					return nil, errors.New("unexpected patch")
+				}
+				return &models.Book{
+					Id:      1,
+					Title:   "some title",
+					Author:  "some author",
+					Pages:   200,
+					Version: 3,
+				}, nil
+			},
+			expectedOutput: &book.Book{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   200,
+				Version: 3,
+			},
+		},
+		{
+			name: "unknown path",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:    1,
+					Pages: 200,
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages", "isbn"}},
+			},
+			expectedViolations: map[string]string{
+				"update_mask.paths": `"isbn" is not a field that can be updated`,
+			},
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
+		},
+		{
+			name: "invalid input",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id: 1,
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+			},
+			expectedViolations: map[string]string{
+				"book.title": "title must be at least 1 character in length",
+			},
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
+		},
+		{
+			name: "does not exist",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:    1,
+					Pages: 200,
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages"}},
+			},
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				return nil, &bookErrors.ErrBookNotFound{Id: 1}
+			},
+			expectedError: errors.New("rpc error: code = NotFound desc = no book with id 1 found"),
+		},
+		{
+			name: "version mismatch",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:    1,
+					Pages: 200,
+				},
+				ExpectedVersion: 2,
+				UpdateMask:      &fieldmaskpb.FieldMask{Paths: []string{"pages"}},
+			},
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				return nil, &bookErrors.ErrVersionMismatch{Id: 1, Version: 3, Expected: patch.Version}
+			},
+			expectedError: errors.New("rpc error: code = Aborted desc = book with id 1 is at version 3, not 2"),
+		},
+		{
+			name: "already exists",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:    1,
+					Title: "new title",
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"title"}},
+			},
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				return nil, &bookErrors.ErrDuplicateBook{Title: *patch.Title, Author: "some author"}
+			},
+			expectedError: errors.New(`rpc error: code = AlreadyExists desc = book with title "new title" from author "some author" already exists`),
+		},
+		{
+			name: "error",
+			input: &book.UpdateBookRequest{
+				Book: &book.Book{
+					Id:    1,
+					Pages: 200,
+				},
+				UpdateMask: &fieldmaskpb.FieldMask{Paths: []string{"pages"}},
+			},
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				return nil, errors.New("patch book error")
+			},
+			expectedError: errors.New("patch book error"),
 		},
 	}
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			updateBook = tc.mockUpdateBook
-			logger := log.New(io.Discard, "", 0)
-			s := New(logger, nil)
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{patch: tc.mockPatchBook}, newRpcMetrics(), testImportBatchSize, false, false)
 			output, err := s.UpdateBook(context.TODO(), tc.input)
 			if err != nil {
 				if tc.expectedError == nil {
 					t.Fatalf(`expected no error, got "%v"`, err)
 				}
 				require.Equal(t, tc.expectedError.Error(), err.Error())
+				if tc.expectedViolations != nil {
+					violations := make(map[string]string)
+					for _, detail := range status.Convert(err).Details() {
+						if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+							for _, violation := range badRequest.FieldViolations {
+								violations[violation.Field] = violation.Description
+							}
+						}
+					}
+					require.Equal(t, tc.expectedViolations, violations)
+				}
 			} else {
 				if tc.expectedError != nil {
 					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
@@ -337,7 +648,7 @@ func TestDeleteBook(t *testing.T) {
 	testCases := []struct {
 		name           string
 		input          *book.DeleteBookRequest
-		mockDeleteBook func(ctx context.Context, db *sql.DB, bookId int) error
+		mockDeleteBook func(ctx context.Context, bookId int) error
 		expectedOutput *book.DeleteBookResponse
 		expectedError  error
 	}{
@@ -346,7 +657,7 @@ func TestDeleteBook(t *testing.T) {
 			input: &book.DeleteBookRequest{
 				Id: int32(1),
 			},
-			mockDeleteBook: func(ctx context.Context, db *sql.DB, bookId int) error {
+			mockDeleteBook: func(ctx context.Context, bookId int) error {
 				return nil
 			},
 			expectedOutput: &book.DeleteBookResponse{
@@ -358,17 +669,18 @@ func TestDeleteBook(t *testing.T) {
 			input: &book.DeleteBookRequest{
 				Id: int32(1),
 			},
-			mockDeleteBook: func(ctx context.Context, db *sql.DB, bookId int) error {
+			mockDeleteBook: func(ctx context.Context, bookId int) error {
 				return errors.New("delete book error")
 			},
-			expectedError: errors.New("rpc error: code = Internal desc = delete book error"),
+			expectedError: errors.New("delete book error"),
 		},
 	}
 	for _, tc := range testCases {
+		tc := tc
 		t.Run(tc.name, func(t *testing.T) {
-			deleteBook = tc.mockDeleteBook
-			logger := log.New(io.Discard, "", 0)
-			s := New(logger, nil)
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{deleteById: tc.mockDeleteBook}, newRpcMetrics(), testImportBatchSize, false, false)
 			output, err := s.DeleteBook(context.TODO(), tc.input)
 			if err != nil {
 				if tc.expectedError == nil {
@@ -384,3 +696,854 @@ func TestDeleteBook(t *testing.T) {
 		})
 	}
 }
+
+func TestStreamBooks(t *testing.T) {
+	testCases := []struct {
+		name            string
+		mockStreamBooks func(ctx context.Context, fn func(book *models.Book) error) error
+		expectedOutput  []*book.Book
+		expectedError   error
+	}{
+		{
+			name: "happy path",
+			mockStreamBooks: func(ctx context.Context, fn func(book *models.Book) error) error {
+				if err := fn(&models.Book{Id: 1, Title: "title", Author: "author", Pages: 100}); err != nil {
+					return err
+				}
+				return fn(&models.Book{Id: 2, Title: "another title", Author: "another author", Pages: 150})
+			},
+			expectedOutput: []*book.Book{
+				{
+					Id:     1,
+					Title:  "title",
+					Author: "author",
+					Pages:  100,
+				},
+				{
+					Id:     2,
+					Title:  "another title",
+					Author: "another author",
+					Pages:  150,
+				},
+			},
+		},
+		{
+			name: "error",
+			mockStreamBooks: func(ctx context.Context, fn func(book *models.Book) error) error {
+				return errors.New("stream books error")
+			},
+			expectedError: errors.New("rpc error: code = Internal desc = internal error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			client := newBufconnClient(t, New(logger, &mockStore{stream: tc.mockStreamBooks}, newRpcMetrics(), testImportBatchSize, false, false))
+			stream, err := client.StreamBooks(context.TODO(), &book.StreamBooksRequest{})
+			require.NoError(t, err)
+			var output []*book.Book
+			for {
+				b, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if tc.expectedError == nil {
+						t.Fatalf(`expected no error, got "%v"`, err)
+					}
+					require.Equal(t, tc.expectedError.Error(), err.Error())
+					return
+				}
+				output = append(output, b)
+			}
+			if tc.expectedError != nil {
+				t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+			}
+			require.Equal(t, len(tc.expectedOutput), len(output))
+			for i := range output {
+				require.True(t, proto.Equal(tc.expectedOutput[i], output[i]))
+			}
+		})
+	}
+}
+
+func TestStreamBooksCanceled(t *testing.T) {
+	serverCanceled := make(chan error, 1)
+	store := &mockStore{stream: func(ctx context.Context, fn func(book *models.Book) error) error {
+		if err := fn(&models.Book{Id: 1, Title: "title", Author: "author", Pages: 100}); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		serverCanceled <- ctx.Err()
+		return ctx.Err()
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := newBufconnClient(t, New(logger, store, newRpcMetrics(), testImportBatchSize, false, false))
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	stream, err := client.StreamBooks(ctx, &book.StreamBooksRequest{})
+	require.NoError(t, err)
+	b, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int32(1), b.GetId())
+	cancel()
+	_, err = stream.Recv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+	require.Equal(t, context.Canceled, <-serverCanceled)
+}
+
+func TestWatchBooks(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          *book.WatchBooksRequest
+		mockWatchBooks func(ctx context.Context, after int64, fn func(change *models.Change) error) error
+		expectedOutput []*book.BookChange
+		expectedError  error
+	}{
+		{
+			name:  "happy path",
+			input: &book.WatchBooksRequest{AfterSequence: 2},
+			mockWatchBooks: func(ctx context.Context, after int64, fn func(change *models.Change) error) error {
+				require.Equal(t, int64(2), after)
+				if err := fn(&models.Change{
+					Sequence:  3,
+					Operation: models.CreateOperation,
+					Book:      models.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1},
+				}); err != nil {
+					return err
+				}
+				return fn(&models.Change{
+					Sequence:  4,
+					Operation: models.DeleteOperation,
+					Book:      models.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1},
+				})
+			},
+			expectedOutput: []*book.BookChange{
+				{
+					Sequence: 3,
+					Type:     book.ChangeType_CHANGE_TYPE_CREATED,
+					Book:     &book.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1},
+				},
+				{
+					Sequence: 4,
+					Type:     book.ChangeType_CHANGE_TYPE_DELETED,
+					Book:     &book.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1},
+				},
+			},
+		},
+		{
+			name:          "invalid sequence",
+			input:         &book.WatchBooksRequest{AfterSequence: -1},
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
+		},
+		{
+			name:  "error",
+			input: &book.WatchBooksRequest{},
+			mockWatchBooks: func(ctx context.Context, after int64, fn func(change *models.Change) error) error {
+				return errors.New("watch books error")
+			},
+			expectedError: errors.New("rpc error: code = Internal desc = internal error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			client := newBufconnClient(t, New(logger, &mockStore{watch: tc.mockWatchBooks}, newRpcMetrics(), testImportBatchSize, false, false))
+			stream, err := client.WatchBooks(context.TODO(), tc.input)
+			require.NoError(t, err)
+			var output []*book.BookChange
+			for {
+				c, err := stream.Recv()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					if tc.expectedError == nil {
+						t.Fatalf(`expected no error, got "%v"`, err)
+					}
+					require.Equal(t, tc.expectedError.Error(), err.Error())
+					return
+				}
+				output = append(output, c)
+			}
+			if tc.expectedError != nil {
+				t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+			}
+			require.Equal(t, len(tc.expectedOutput), len(output))
+			for i := range output {
+				require.True(t, proto.Equal(tc.expectedOutput[i], output[i]), "%v", output[i])
+			}
+		})
+	}
+}
+
+func TestWatchBooksCanceled(t *testing.T) {
+	serverCanceled := make(chan error, 1)
+	store := &mockStore{watch: func(ctx context.Context, after int64, fn func(change *models.Change) error) error {
+		if err := fn(&models.Change{Sequence: 1, Operation: models.CreateOperation, Book: models.Book{Id: 1}}); err != nil {
+			return err
+		}
+		<-ctx.Done()
+		serverCanceled <- ctx.Err()
+		return ctx.Err()
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := newBufconnClient(t, New(logger, store, newRpcMetrics(), testImportBatchSize, false, false))
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	stream, err := client.WatchBooks(ctx, &book.WatchBooksRequest{})
+	require.NoError(t, err)
+	c, err := stream.Recv()
+	require.NoError(t, err)
+	require.Equal(t, int64(1), c.GetSequence())
+	cancel()
+	_, err = stream.Recv()
+	require.Equal(t, codes.Canceled, status.Code(err))
+	require.Equal(t, context.Canceled, <-serverCanceled)
+}
+
+func TestImportBooks(t *testing.T) {
+	testCases := []struct {
+		name            string
+		input           []*book.Book
+		mockBatch       func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+		expectedBatches [][]string
+		expectedOutput  *book.ImportSummary
+		expectedError   error
+	}{
+		{
+			name: "happy path",
+			input: []*book.Book{
+				{Title: "first title", Author: "author", Pages: 100},
+				{},
+				{Title: "second title", Author: "author", Pages: 100},
+				{Title: "third title", Author: "author", Pages: 100},
+			},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				results := make([]*models.BatchResult, len(ops))
+				for i, op := range ops {
+					if op.Create.Title == "second title" {
+						results[i] = &models.BatchResult{Err: &bookErrors.ErrDuplicateBook{Title: op.Create.Title, Author: op.Create.Author}}
+						continue
+					}
+					results[i] = &models.BatchResult{Book: &models.Book{Title: op.Create.Title}}
+				}
+				return results, nil
+			},
+			expectedBatches: [][]string{{"first title", "second title"}, {"third title"}},
+			expectedOutput:  &book.ImportSummary{Created: 2, Duplicates: 1, Invalid: 1},
+		},
+		{
+			name:           "no books",
+			expectedOutput: &book.ImportSummary{},
+		},
+		{
+			name: "error",
+			input: []*book.Book{
+				{Title: "first title", Author: "author", Pages: 100},
+			},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return nil, errors.New("batch error")
+			},
+			expectedBatches: [][]string{{"first title"}},
+			expectedError:   errors.New("rpc error: code = Internal desc = internal error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var batches [][]string
+			store := &mockStore{batch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				require.True(t, bestEffort)
+				var titles []string
+				for _, op := range ops {
+					titles = append(titles, op.Create.Title)
+				}
+				batches = append(batches, titles)
+				return tc.mockBatch(ctx, ops, bestEffort)
+			}}
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			client := newBufconnClient(t, New(logger, store, newRpcMetrics(), testImportBatchSize, false, false))
+			stream, err := client.ImportBooks(context.TODO())
+			require.NoError(t, err)
+			for _, b := range tc.input {
+				require.NoError(t, stream.Send(&book.CreateBookRequest{Book: b}))
+			}
+			output, err := stream.CloseAndRecv()
+			require.Equal(t, tc.expectedBatches, batches)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.True(t, proto.Equal(tc.expectedOutput, output), "%v", output)
+			}
+		})
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	store := &mockStore{
+		getById: func(ctx context.Context, bookId int) (*models.Book, error) {
+			close(started)
+			<-release
+			return &models.Book{Id: bookId, Title: "title", Author: "author", Pages: 100}, nil
+		},
+		ping: func(ctx context.Context) error {
+			return nil
+		},
+	}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := New(logger, store, newRpcMetrics(), testImportBatchSize, false, false)
+	s.checkHealth(context.TODO())
+	client := newBufconnClient(t, s)
+
+	type result struct {
+		book *book.Book
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		b, err := client.GetBook(context.TODO(), &book.GetBookRequest{Id: 1})
+		results <- result{book: b, err: err}
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.TODO())
+	}()
+	require.Eventually(t, func() bool {
+		resp, err := s.health.Check(context.TODO(), &healthpb.HealthCheckRequest{})
+		return err == nil && resp.GetStatus() == healthpb.HealthCheckResponse_NOT_SERVING
+	}, time.Second, 10*time.Millisecond)
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("expected shutdown to wait for the in-flight call, got %v", err)
+	default:
+	}
+
+	close(release)
+	r := <-results
+	require.NoError(t, r.err)
+	require.Equal(t, int32(1), r.book.GetId())
+	require.NoError(t, <-shutdownErr)
+}
+
+func TestShutdownEndsWatches(t *testing.T) {
+	watching := make(chan struct{})
+	store := &mockStore{watch: func(ctx context.Context, after int64, fn func(change *models.Change) error) error {
+		close(watching)
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := New(logger, store, newRpcMetrics(), testImportBatchSize, false, false)
+	client := newBufconnClient(t, s)
+	stream, err := client.WatchBooks(context.TODO(), &book.WatchBooksRequest{})
+	require.NoError(t, err)
+	<-watching
+
+	// Shutdown does not wait for the client to leave.
+	ctx, cancel := context.WithTimeout(context.TODO(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	_, err = stream.Recv()
+	require.Equal(t, "rpc error: code = Unavailable desc = server is shutting down", err.Error())
+}
+
+func TestShutdownDeadlineExceeded(t *testing.T) {
+	started := make(chan struct{})
+	store := &mockStore{getById: func(ctx context.Context, bookId int) (*models.Book, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := New(logger, store, newRpcMetrics(), testImportBatchSize, false, false)
+	client := newBufconnClient(t, s)
+
+	callErr := make(chan error, 1)
+	go func() {
+		_, err := client.GetBook(context.TODO(), &book.GetBookRequest{Id: 1})
+		callErr <- err
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer cancel()
+	err := s.Shutdown(ctx)
+	require.Equal(t, context.DeadlineExceeded, err)
+	require.Error(t, <-callErr)
+}
+
+func TestSearchBooks(t *testing.T) {
+	testCases := []struct {
+		name            string
+		input           *book.SearchBooksRequest
+		mockSearchBooks func(ctx context.Context, query string) ([]*models.SearchResult, error)
+		expectedOutput  *book.SearchBooksResponse
+		expectedError   error
+	}{
+		{
+			name:  "happy path",
+			input: &book.SearchBooksRequest{Query: "lord"},
+			mockSearchBooks: func(ctx context.Context, query string) ([]*models.SearchResult, error) {
+				return []*models.SearchResult{
+					{
+						Book: models.Book{
+							Id:     1,
+							Title:  "the lord of the rings",
+							Author: "tolkien",
+							Pages:  1000,
+						},
+						Snippet: "the <mark>lord</mark> of the rings",
+						Rank:    -1.5,
+					},
+				}, nil
+			},
+			expectedOutput: &book.SearchBooksResponse{
+				Results: []*book.SearchResult{
+					{
+						Book: &book.Book{
+							Id:     1,
+							Title:  "the lord of the rings",
+							Author: "tolkien",
+							Pages:  1000,
+						},
+						Snippet: "the <mark>lord</mark> of the rings",
+						Rank:    -1.5,
+					},
+				},
+			},
+		},
+		{
+			name:          "invalid input",
+			input:         &book.SearchBooksRequest{},
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
+		},
+		{
+			name:  "error",
+			input: &book.SearchBooksRequest{Query: "lord"},
+			mockSearchBooks: func(ctx context.Context, query string) ([]*models.SearchResult, error) {
+				return nil, errors.New("search books error")
+			},
+			expectedError: errors.New("search books error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{search: tc.mockSearchBooks}, newRpcMetrics(), testImportBatchSize, false, false)
+			output, err := s.SearchBooks(context.TODO(), tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
+// withoutDetails drops the error details from the statuses of
+// the given batch results, those being checked by TestErrorDetails.
+func withoutDetails(results []*book.BatchResult) []*book.BatchResult {
+	for _, result := range results {
+		result.Status.Details = nil
+	}
+	return results
+}
+
+func TestBatchCreateBooks(t *testing.T) {
+	validBook := &book.Book{Title: "title", Author: "author", Pages: 100}
+	duplicateBook := &book.Book{Title: "other title", Author: "author", Pages: 100}
+	testCases := []struct {
+		name           string
+		input          *book.BatchCreateBooksRequest
+		mockBatch      func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+		expectedOutput *book.BatchCreateBooksResponse
+		expectedError  error
+	}{
+		{
+			name:  "happy path",
+			input: &book.BatchCreateBooksRequest{Books: []*book.Book{validBook}},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return []*models.BatchResult{
+					{Book: &models.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1}},
+				}, nil
+			},
+			expectedOutput: &book.BatchCreateBooksResponse{
+				Results: []*book.BatchResult{
+					{
+						Index:  0,
+						Book:   &book.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1},
+						Status: &rpcStatus.Status{},
+					},
+				},
+			},
+		},
+		{
+			name:  "all or nothing, invalid book",
+			input: &book.BatchCreateBooksRequest{Books: []*book.Book{validBook, {}}},
+			expectedOutput: &book.BatchCreateBooksResponse{
+				Results: []*book.BatchResult{
+					{
+						Index:  0,
+						Status: &rpcStatus.Status{Code: int32(codes.Aborted), Message: "rolled back as other items of the batch failed"},
+					},
+					{
+						Index:  1,
+						Status: &rpcStatus.Status{Code: int32(codes.InvalidArgument), Message: "the request has invalid fields"},
+					},
+				},
+			},
+		},
+		{
+			name:  "all or nothing, duplicate book",
+			input: &book.BatchCreateBooksRequest{Books: []*book.Book{validBook, duplicateBook}},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return []*models.BatchResult{
+					{Book: &models.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1}},
+					{Err: &bookErrors.ErrDuplicateBook{Title: "other title", Author: "author"}},
+				}, nil
+			},
+			expectedOutput: &book.BatchCreateBooksResponse{
+				Results: []*book.BatchResult{
+					{
+						Index:  0,
+						Status: &rpcStatus.Status{Code: int32(codes.Aborted), Message: "rolled back as other items of the batch failed"},
+					},
+					{
+						Index:  1,
+						Status: &rpcStatus.Status{Code: int32(codes.AlreadyExists), Message: `book with title "other title" from author "author" already exists`},
+					},
+				},
+			},
+		},
+		{
+			name:  "best effort",
+			input: &book.BatchCreateBooksRequest{Books: []*book.Book{{}, validBook, duplicateBook}, BestEffort: true},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return []*models.BatchResult{
+					{Book: &models.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1}},
+					{Err: &bookErrors.ErrDuplicateBook{Title: "other title", Author: "author"}},
+				}, nil
+			},
+			expectedOutput: &book.BatchCreateBooksResponse{
+				Results: []*book.BatchResult{
+					{
+						Index:  0,
+						Status: &rpcStatus.Status{Code: int32(codes.InvalidArgument), Message: "the request has invalid fields"},
+					},
+					{
+						Index:  1,
+						Book:   &book.Book{Id: 1, Title: "title", Author: "author", Pages: 100, Version: 1},
+						Status: &rpcStatus.Status{},
+					},
+					{
+						Index:  2,
+						Status: &rpcStatus.Status{Code: int32(codes.AlreadyExists), Message: `book with title "other title" from author "author" already exists`},
+					},
+				},
+			},
+		},
+		{
+			name:          "no books",
+			input:         &book.BatchCreateBooksRequest{},
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
+		},
+		{
+			name:          "too many books",
+			input:         &book.BatchCreateBooksRequest{Books: make([]*book.Book, maxBatchSize+1)},
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
+		},
+		{
+			name:  "error",
+			input: &book.BatchCreateBooksRequest{Books: []*book.Book{validBook}},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return nil, errors.New("batch error")
+			},
+			expectedError: errors.New("batch error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{batch: tc.mockBatch}, newRpcMetrics(), testImportBatchSize, false, false)
+			output, err := s.BatchCreateBooks(context.TODO(), tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				output.Results = withoutDetails(output.Results)
+				require.True(t, proto.Equal(tc.expectedOutput, output), "%v", output)
+			}
+		})
+	}
+}
+
+func TestBatchDeleteBooks(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          *book.BatchDeleteBooksRequest
+		mockBatch      func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+		expectedOutput *book.BatchDeleteBooksResponse
+		expectedError  error
+	}{
+		{
+			name:  "happy path",
+			input: &book.BatchDeleteBooksRequest{Ids: []int32{1, 2}},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return []*models.BatchResult{{}, {}}, nil
+			},
+			expectedOutput: &book.BatchDeleteBooksResponse{
+				Results: []*book.BatchResult{
+					{Index: 0, Status: &rpcStatus.Status{}},
+					{Index: 1, Status: &rpcStatus.Status{}},
+				},
+			},
+		},
+		{
+			name:  "all or nothing, invalid id",
+			input: &book.BatchDeleteBooksRequest{Ids: []int32{1, 0}},
+			expectedOutput: &book.BatchDeleteBooksResponse{
+				Results: []*book.BatchResult{
+					{
+						Index:  0,
+						Status: &rpcStatus.Status{Code: int32(codes.Aborted), Message: "rolled back as other items of the batch failed"},
+					},
+					{
+						Index:  1,
+						Status: &rpcStatus.Status{Code: int32(codes.InvalidArgument), Message: "the request has invalid fields"},
+					},
+				},
+			},
+		},
+		{
+			name:  "best effort, invalid id",
+			input: &book.BatchDeleteBooksRequest{Ids: []int32{1, 0}, BestEffort: true},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return []*models.BatchResult{{}}, nil
+			},
+			expectedOutput: &book.BatchDeleteBooksResponse{
+				Results: []*book.BatchResult{
+					{Index: 0, Status: &rpcStatus.Status{}},
+					{
+						Index:  1,
+						Status: &rpcStatus.Status{Code: int32(codes.InvalidArgument), Message: "the request has invalid fields"},
+					},
+				},
+			},
+		},
+		{
+			name:          "no ids",
+			input:         &book.BatchDeleteBooksRequest{},
+			expectedError: errors.New("rpc error: code = InvalidArgument desc = the request has invalid fields"),
+		},
+		{
+			name:  "error",
+			input: &book.BatchDeleteBooksRequest{Ids: []int32{1}},
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return nil, errors.New("batch error")
+			},
+			expectedError: errors.New("batch error"),
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{batch: tc.mockBatch}, newRpcMetrics(), testImportBatchSize, false, false)
+			output, err := s.BatchDeleteBooks(context.TODO(), tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				output.Results = withoutDetails(output.Results)
+				require.True(t, proto.Equal(tc.expectedOutput, output), "%v", output)
+			}
+		})
+	}
+}
+
+func TestCheckHealth(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mockPing       func(ctx context.Context) error
+		expectedStatus healthpb.HealthCheckResponse_ServingStatus
+	}{
+		{
+			name: "database reachable",
+			mockPing: func(ctx context.Context) error {
+				return nil
+			},
+			expectedStatus: healthpb.HealthCheckResponse_SERVING,
+		},
+		{
+			name: "database unreachable",
+			mockPing: func(ctx context.Context) error {
+				return errors.New("ping error")
+			},
+			expectedStatus: healthpb.HealthCheckResponse_NOT_SERVING,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{ping: tc.mockPing}, newRpcMetrics(), testImportBatchSize, false, false)
+			client := healthpb.NewHealthClient(newBufconnConn(t, s))
+			s.checkHealth(context.TODO())
+			for _, service := range []string{"", "books.BookService"} {
+				resp, err := client.Check(context.TODO(), &healthpb.HealthCheckRequest{Service: service})
+				require.NoError(t, err)
+				require.Equal(t, tc.expectedStatus, resp.GetStatus(), service)
+			}
+		})
+	}
+}
+
+func TestMonitorHealth(t *testing.T) {
+	pings := make(chan struct{}, 1)
+	store := &mockStore{ping: func(ctx context.Context) error {
+		pings <- struct{}{}
+		return nil
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	s := New(logger, store, newRpcMetrics(), testImportBatchSize, false, false)
+	resp, err := s.health.Check(context.TODO(), &healthpb.HealthCheckRequest{Service: "books.BookService"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, resp.GetStatus())
+
+	// The database is pinged right away, then it returns once ctx is done.
+	ctx, cancel := context.WithCancel(context.TODO())
+	cancel()
+	s.MonitorHealth(ctx)
+	require.Len(t, pings, 1)
+	resp, err = s.health.Check(context.TODO(), &healthpb.HealthCheckRequest{Service: "books.BookService"})
+	require.NoError(t, err)
+	require.Equal(t, healthpb.HealthCheckResponse_SERVING, resp.GetStatus())
+}
+
+func TestReflection(t *testing.T) {
+	testCases := []struct {
+		name             string
+		enableReflection bool
+		expectedServices []string
+		expectedCode     codes.Code
+	}{
+		{
+			name:             "enabled",
+			enableReflection: true,
+			expectedServices: []string{
+				"books.BookService",
+				"grpc.health.v1.Health",
+				"grpc.reflection.v1.ServerReflection",
+				"grpc.reflection.v1alpha.ServerReflection",
+			},
+		},
+		{
+			name:         "disabled",
+			expectedCode: codes.Unimplemented,
+		},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+			s := New(logger, &mockStore{}, newRpcMetrics(), testImportBatchSize, tc.enableReflection, false)
+			client := reflectionpb.NewServerReflectionClient(newBufconnConn(t, s))
+			stream, err := client.ServerReflectionInfo(context.TODO())
+			require.NoError(t, err)
+			err = stream.Send(&reflectionpb.ServerReflectionRequest{
+				MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+			})
+			require.NoError(t, err)
+			resp, err := stream.Recv()
+			if tc.expectedCode != codes.OK {
+				require.Equal(t, tc.expectedCode, status.Code(err))
+				return
+			}
+			require.NoError(t, err)
+			var services []string
+			for _, service := range resp.GetListServicesResponse().GetService() {
+				services = append(services, service.GetName())
+			}
+			require.ElementsMatch(t, tc.expectedServices, services)
+		})
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	store := &mockStore{getById: func(ctx context.Context, bookId int) (*models.Book, error) {
+		panic("get book panic")
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := newBufconnClient(t, New(logger, store, newRpcMetrics(), testImportBatchSize, false, false))
+	_, err := client.GetBook(context.TODO(), &book.GetBookRequest{Id: 1})
+	require.Equal(t, "rpc error: code = Internal desc = internal error", err.Error())
+}
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	var storeSpan trace.SpanContext
+	store := &mockStore{getById: func(ctx context.Context, bookId int) (*models.Book, error) {
+		storeSpan = trace.SpanContextFromContext(ctx)
+		return &models.Book{Id: 1, Title: "title", Author: "author", Pages: 100}, nil
+	}}
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	client := newBufconnClient(t, New(logger, store, newRpcMetrics(), testImportBatchSize, false, false))
+	ctx := metadata.AppendToOutgoingContext(context.TODO(),
+		"traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, err := client.GetBook(ctx, &book.GetBookRequest{Id: 1})
+	require.NoError(t, err)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.Equal(t, "books.BookService/GetBook", spans[0].Name)
+	require.Equal(t, trace.SpanKindServer, spans[0].SpanKind)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", spans[0].Parent.SpanID().String())
+	require.Equal(t, spans[0].SpanContext.SpanID(), storeSpan.SpanID())
+}
@@ -6,48 +6,104 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	"github.com/jessevdk/go-flags"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tiagomelo/go-templates/example-rest-api/config"
 	"github.com/tiagomelo/go-templates/example-rest-api/db"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books"
 	"github.com/tiagomelo/go-templates/example-rest-api/handlers"
+	"github.com/tiagomelo/go-templates/example-rest-api/middleware"
+	"github.com/tiagomelo/go-templates/example-rest-api/tracing"
 )
 
-type options struct {
-	Port int `short:"p" long:"port" description:"server's port" required:"true"`
-}
-
-func run(port int, log *slog.Logger) error {
+func run(opts *config.Options, log *slog.Logger) error {
 	ctx := context.Background()
 	defer log.InfoContext(ctx, "Completed")
 
 	// =========================================================================
 	// Database support
 
-	const sqliteDbFile = "db/booksRestApi.db"
-	db, err := db.ConnectToSqlite(sqliteDbFile)
+	sqlDb, err := db.Open(opts.Db.Driver, opts.Db.Dsn)
 	if err != nil {
-		return errors.Wrapf(err, "opening database file %s", sqliteDbFile)
+		return errors.Wrap(err, "opening database")
+	}
+	defer sqlDb.Close()
+	if opts.Migrate != "" {
+		return migrate(ctx, sqlDb, opts.Migrate, log)
+	}
+	if err := db.Migrate(ctx, sqlDb); err != nil {
+		return errors.Wrap(err, "migrating database")
+	}
+	store, err := books.NewStore(opts.Db.Driver, sqlDb)
+	if err != nil {
+		return errors.Wrap(err, "creating book store")
+	}
+
+	// =========================================================================
+	// Tracing
+
+	shutdownTracing, err := tracing.Setup(ctx, opts.Tracing.Exporter, opts.Tracing.Endpoint, opts.Tracing.Insecure)
+	if err != nil {
+		return errors.Wrap(err, "setting up tracing")
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(ctx, opts.ShutdownTimeout)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			log.ErrorContext(ctx, "flushing traces", slog.Any("err", err))
+		}
+	}()
+
+	// =========================================================================
+	// Metrics
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(sqlDb, opts.Db.Driver),
+	)
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+
+	// Server exposing the metrics on their own port, so that they are not
+	// reachable through the public API.
+	metricsSrv := http.Server{
+		Addr:    fmt.Sprintf(":%d", opts.MetricsPort),
+		Handler: metricsMux,
 	}
 
 	// =========================================================================
 	// API Service
 
+	// Set once shutdown begins, so that the readiness probe fails and
+	// load balancers stop routing requests before the server stops.
+	var shuttingDown atomic.Bool
+
 	apiMux := handlers.NewApiMux(&handlers.ApiMuxConfig{
-		Db:  db,
-		Log: log,
+		Store:         store,
+		Db:            sqlDb,
+		ShuttingDown:  &shuttingDown,
+		Log:           log,
+		Metrics:       middleware.NewHttpMetrics(registry),
+		VerboseErrors: opts.VerboseErrors,
 	})
 
 	// Server to service the requests against the mux.
 	srv := http.Server{
-		Addr:    fmt.Sprintf(":%d", port),
+		Addr:    fmt.Sprintf(":%d", opts.Port),
 		Handler: apiMux,
 	}
 
@@ -55,8 +111,8 @@ func run(port int, log *slog.Logger) error {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, os.Interrupt, syscall.SIGTERM)
 
-	// Channel to listen for errors coming from the listener.
-	serverErrors := make(chan error, 1)
+	// Channel to listen for errors coming from the listeners.
+	serverErrors := make(chan error, 2)
 
 	// Start the service listening for api requests.
 	go func() {
@@ -64,35 +120,83 @@ func run(port int, log *slog.Logger) error {
 		serverErrors <- srv.ListenAndServe()
 	}()
 
+	// Start the service listening for metrics scrapes.
+	go func() {
+		log.Info(fmt.Sprintf("Metrics listening on %s", metricsSrv.Addr))
+		serverErrors <- metricsSrv.ListenAndServe()
+	}()
+
 	// Blocking main and waiting for shutdown.
 	select {
 	case err := <-serverErrors:
 		return errors.Wrap(err, "server error")
 	case sig := <-shutdown:
 		log.InfoContext(ctx, fmt.Sprintf("Starting shutdown: %v", sig))
+		shuttingDown.Store(true)
+
+		// Give load balancers time to notice the failing readiness probe.
+		time.Sleep(opts.ShutdownDelay)
 
 		// Give outstanding requests a deadline for completion.
-		ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		ctx, cancel := context.WithTimeout(ctx, opts.ShutdownTimeout)
 		defer cancel()
 
-		// Asking listener to shutdown and shed load.
-		if err := srv.Shutdown(ctx); err != nil {
+		// Asking listener to shutdown and shed load. The metrics server
+		// is stopped as well even if the listener could not drain.
+		srvErr := srv.Shutdown(ctx)
+		if srvErr != nil {
 			srv.Close()
-			return errors.Wrap(err, "could not stop server gracefully")
+		}
+		if err := metricsSrv.Shutdown(ctx); err != nil {
+			metricsSrv.Close()
+			if srvErr == nil {
+				return errors.Wrap(err, "could not stop metrics server gracefully")
+			}
+		}
+		if srvErr != nil {
+			return errors.Wrap(srvErr, "could not stop server gracefully")
 		}
 	}
 	return nil
 }
 
+// migrate runs the given migration command against the database.
+func migrate(ctx context.Context, sqlDb *sql.DB, command string, log *slog.Logger) error {
+	switch command {
+	case "up":
+		if err := db.Migrate(ctx, sqlDb); err != nil {
+			return errors.Wrap(err, "applying migrations")
+		}
+	case "down":
+		if err := db.MigrateDown(ctx, sqlDb); err != nil {
+			return errors.Wrap(err, "reverting migration")
+		}
+	}
+	version, err := db.MigrationVersion(ctx, sqlDb)
+	if err != nil {
+		return errors.Wrap(err, "getting migration version")
+	}
+	log.InfoContext(ctx, fmt.Sprintf("Database at migration version %d", version))
+	return nil
+}
+
 func main() {
-	var opts options
-	parser := flags.NewParser(&opts, flags.Default)
-	_, err := parser.Parse()
+	opts, err := config.Load(os.Args[1:])
 	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
+	if opts.PrintConfig {
+		out, err := opts.Config.Dump()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		fmt.Print(out)
+		return
+	}
 	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
-	if err := run(opts.Port, log); err != nil {
+	if err := run(opts, log); err != nil {
 		log.Error("error", slog.Any("err", err))
 		os.Exit(1)
 	}
@@ -0,0 +1,122 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+)
+
+// Statements that undo a failed operation of a batch without undoing
+// the others, which are supported by both SQLite and PostgreSQL.
+const (
+	savepointQuery           = "SAVEPOINT batch_operation"
+	rollbackToSavepointQuery = "ROLLBACK TO SAVEPOINT batch_operation"
+	releaseSavepointQuery    = "RELEASE SAVEPOINT batch_operation"
+)
+
+// createFunc adds a new book record in the way of a given backend.
+type createFunc func(ctx context.Context, db Querier, newBook *models.NewBook) (*models.NewBook, error)
+
+// Batch runs the given operations within a single transaction, returning
+// their results in the same order. Errors that are the client's, that is,
+// duplicate books, missing books and version mismatches, are reported in
+// the result of the operation that failed, which is undone on its own.
+// Any other error rolls the whole batch back and is returned. Unless
+// bestEffort is set, the batch is also rolled back if any operation failed.
+func Batch(ctx context.Context, db *sql.DB, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	return batch(ctx, db, ops, bestEffort, Create)
+}
+
+// batch runs a batch, adding books with the given create function.
+func batch(ctx context.Context, db *sql.DB, ops []*models.BatchOperation, bestEffort bool, create createFunc) ([]*models.BatchResult, error) {
+	// The span covers the whole transaction, the spans of the
+	// operations being its children.
+	ctx, span := startSpan(ctx, "Batch", "BEGIN")
+	defer span.End()
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, spanError(ctx, span, errors.Wrap(err, "beginning transaction"))
+	}
+	defer tx.Rollback()
+	results := make([]*models.BatchResult, len(ops))
+	failed := false
+	for i, op := range ops {
+		if _, err := tx.ExecContext(ctx, savepointQuery); err != nil {
+			return nil, spanError(ctx, span, errors.Wrap(err, "creating savepoint"))
+		}
+		book, err := runOperation(ctx, tx, op, create)
+		if err != nil {
+			if !isClientError(err) {
+				return nil, spanError(ctx, span, errors.Wrapf(err, "running operation %d", i))
+			}
+			if _, err := tx.ExecContext(ctx, rollbackToSavepointQuery); err != nil {
+				return nil, spanError(ctx, span, errors.Wrap(err, "rolling back to savepoint"))
+			}
+			results[i] = &models.BatchResult{Err: err}
+			failed = true
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, releaseSavepointQuery); err != nil {
+			return nil, spanError(ctx, span, errors.Wrap(err, "releasing savepoint"))
+		}
+		results[i] = &models.BatchResult{Book: book}
+	}
+	if failed && !bestEffort {
+		return results, nil
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, spanError(ctx, span, errors.Wrap(err, "committing transaction"))
+	}
+	return results, nil
+}
+
+// runOperation runs an operation of a batch within the given transaction,
+// returning the book it created or updated, if any.
+func runOperation(ctx context.Context, tx *sql.Tx, op *models.BatchOperation, create createFunc) (*models.Book, error) {
+	switch {
+	case op.Create != nil:
+		newBook, err := create(ctx, tx, op.Create)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Book{
+			Id:      newBook.Id,
+			Title:   newBook.Title,
+			Author:  newBook.Author,
+			Pages:   newBook.Pages,
+			Version: newBook.Version,
+		}, nil
+	case op.Update != nil:
+		updatedBook, err := Update(ctx, tx, op.Update)
+		if err != nil {
+			return nil, err
+		}
+		return &models.Book{
+			Id:      updatedBook.Id,
+			Title:   updatedBook.Title,
+			Author:  updatedBook.Author,
+			Pages:   updatedBook.Pages,
+			Version: updatedBook.Version,
+		}, nil
+	case op.Delete != nil:
+		return nil, DeleteById(ctx, tx, op.Delete.Id, op.Delete.Version)
+	}
+	return nil, errors.New("empty batch operation")
+}
+
+// isClientError tells whether err is caused by the operation that
+// returned it, rather than by the database.
+func isClientError(err error) bool {
+	var (
+		notFoundErr   *ErrBookNotFound
+		duplicatedErr *ErrDuplicateBook
+		mismatchErr   *ErrVersionMismatch
+	)
+	return errors.As(err, &notFoundErr) || errors.As(err, &duplicatedErr) || errors.As(err, &mismatchErr)
+}
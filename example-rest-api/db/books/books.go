@@ -8,10 +8,13 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"strings"
 
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"github.com/pkg/errors"
 	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ErrBookNotFound represents an error when a book is not found in the database.
@@ -33,15 +36,47 @@ func (e ErrDuplicateBook) Error() string {
 	return fmt.Sprintf(`book with title "%s" from author "%s" already exists`, e.Title, e.Author)
 }
 
+// ErrVersionMismatch represents an error when a book is not at the version
+// a change was based on, meaning that someone else changed it meanwhile.
+type ErrVersionMismatch struct {
+	Id       int
+	Version  int
+	Expected int
+}
+
+func (e ErrVersionMismatch) Error() string {
+	return fmt.Sprintf("book with id %d is at version %d, not %d", e.Id, e.Version, e.Expected)
+}
+
+// Querier runs SQL statements. It is implemented by both *sql.DB and
+// *sql.Tx, so that the operations changing books run either on their
+// own or within a transaction.
+type Querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// initialVersion is the version of a newly created book, which is
+// the default value of the version column.
+const initialVersion = 1
+
 // SQL queries as constants for CRUD operations on the 'books' table.
 const (
-	listQuery = `
-	SELECT id, title, author, pages
-	FROM books 
+	getByIdQuery = `
+	SELECT id, title, author, pages, version
+	FROM books
+	WHERE id = $1
 	`
 
-	getByIdQuery = `
-	SELECT id, title, author, pages
+	getVersionQuery = `
+	SELECT version
+	FROM books
+	WHERE id = $1
+	`
+
+	getTitleAndAuthorQuery = `
+	SELECT title, author
 	FROM books
 	WHERE id = $1
 	`
@@ -53,85 +88,253 @@ const (
 
 	updateQuery = `
 	UPDATE books
-	SET title = $1, author = $2, pages = $3
-	WHERE id = $4
+	SET title = $1, author = $2, pages = $3, version = version + 1
+	WHERE id = $4 AND ($5 = 0 OR version = $5)
+	RETURNING version
 	`
 
 	deleteByIdQuery = `
 	DELETE FROM books
-	WHERE id = $1
+	WHERE id = $1 AND ($2 = 0 OR version = $2)
+	`
+
+	searchQuery = `
+	SELECT b.id, b.title, b.author, b.pages,
+		snippet(books_fts, -1, '<mark>', '</mark>', '...', 16),
+		bm25(books_fts)
+	FROM books_fts
+	JOIN books b ON b.id = books_fts.rowid
+	WHERE books_fts MATCH $1
+	ORDER BY bm25(books_fts)
+	LIMIT $2
 	`
 )
 
-// List retrieves all books from the database.
-func List(ctx context.Context, db *sql.DB) ([]*models.Book, error) {
-	rows, err := db.QueryContext(ctx, listQuery)
+// searchLimit is the maximum number of results returned by Search.
+const searchLimit = 50
+
+// List retrieves a page of books matching the filters given in the
+// parameters, in the requested order, starting right after the cursor
+// book. One extra row is fetched to find out whether there are more
+// books past the returned page.
+func List(ctx context.Context, db *sql.DB, params *models.ListParams) (*models.BookPage, error) {
+	return list(ctx, db, params, "LIKE")
+}
+
+// list retrieves a page of books, using likeOp to match title filters.
+func list(ctx context.Context, db *sql.DB, params *models.ListParams, likeOp string) (*models.BookPage, error) {
+	query, args, err := listQuery(params, likeOp)
+	if err != nil {
+		return nil, errors.Wrap(err, "building list query")
+	}
+	ctx, span := startSpan(ctx, "List", query)
+	defer span.End()
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, errors.Wrap(err, "listing books")
+		return nil, spanError(ctx, span, errors.Wrap(err, "listing books"))
 	}
 	defer rows.Close()
 	books := []*models.Book{}
 	for rows.Next() {
 		var book models.Book
 		if err := rows.Scan(&book.Id, &book.Title, &book.Author, &book.Pages); err != nil {
-			return nil, errors.Wrap(err, "scanning book")
+			return nil, spanError(ctx, span, errors.Wrap(err, "scanning book"))
 		}
 		books = append(books, &book)
 	}
-	return books, nil
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, errors.Wrap(err, "iterating books"))
+	}
+	page := &models.BookPage{Books: books}
+	if len(books) > params.Limit {
+		page.Books = books[:params.Limit]
+		page.HasMore = true
+	}
+	return page, nil
 }
 
 // GetById retrieves a book by its ID.
 func GetById(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+	ctx, span := startSpan(ctx, "GetById", getByIdQuery)
+	defer span.End()
 	row := db.QueryRowContext(ctx, getByIdQuery, bookId)
 	var book models.Book
-	if err := row.Scan(&book.Id, &book.Title, &book.Author, &book.Pages); err != nil {
+	if err := row.Scan(&book.Id, &book.Title, &book.Author, &book.Pages, &book.Version); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, &ErrBookNotFound{Id: bookId}
 		}
-		return nil, errors.Wrapf(err, "getting book with id %d", bookId)
+		return nil, spanError(ctx, span, errors.Wrapf(err, "getting book with id %d", bookId))
 	}
 	return &book, nil
 }
 
 // Create adds a new book record to the database.
-func Create(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+func Create(ctx context.Context, db Querier, newBook *models.NewBook) (*models.NewBook, error) {
+	ctx, span := startSpan(ctx, "Create", createQuery)
+	defer span.End()
 	result, err := db.ExecContext(ctx, createQuery, newBook.Title, newBook.Author, newBook.Pages)
 	if err != nil {
-		var sqliteErr *sqlite3.Error
-		if errors.As(err, &sqliteErr) && sqliteErr.Code == sqlite3.ErrConstraint {
+		if isDuplicateError(err) {
 			return nil, &ErrDuplicateBook{Title: newBook.Title, Author: newBook.Author}
 		}
-		return nil, errors.Wrap(err, "inserting book")
+		return nil, spanError(ctx, span, errors.Wrap(err, "inserting book"))
 	}
 	id, err := result.LastInsertId()
 	if err != nil {
-		return nil, errors.Wrap(err, "getting last insert id")
+		return nil, spanError(ctx, span, errors.Wrap(err, "getting last insert id"))
 	}
 	newBook.Id = int(id)
+	newBook.Version = initialVersion
 	return newBook, nil
 }
 
-// Update modifies an existing book record.
-func Update(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
-	result, err := db.ExecContext(ctx, updateQuery, book.Title, book.Author, book.Pages, book.Id)
+// Update modifies an existing book record, bumping its version. If the
+// book's version is set, the book is only updated if it is still at that
+// version, so that concurrent changes are not overwritten.
+func Update(ctx context.Context, db Querier, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	ctx, span := startSpan(ctx, "Update", updateQuery)
+	defer span.End()
+	row := db.QueryRowContext(ctx, updateQuery, book.Title, book.Author, book.Pages, book.Id, book.Version)
+	if err := row.Scan(&book.Version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notUpdatedError(ctx, db, span, book.Id, book.Version)
+		}
+		if isDuplicateError(err) {
+			return nil, &ErrDuplicateBook{Title: book.Title, Author: book.Author}
+		}
+		return nil, spanError(ctx, span, errors.Wrapf(err, "updating book with id %d", book.Id))
+	}
+	return book, nil
+}
+
+// Patch sets the columns of a book changed by patch, bumping its version,
+// as long as the book is still at the version the patch was applied to.
+func Patch(ctx context.Context, db Querier, patch *models.BookPatch) (*models.Book, error) {
+	query, args := patchQuery(patch)
+	ctx, span := startSpan(ctx, "Patch", query)
+	defer span.End()
+	row := db.QueryRowContext(ctx, query, args...)
+	var book models.Book
+	if err := row.Scan(&book.Id, &book.Title, &book.Author, &book.Pages, &book.Version); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, notUpdatedError(ctx, db, span, patch.Id, patch.Version)
+		}
+		if isDuplicateError(err) {
+			return nil, duplicatePatchError(ctx, db, span, patch)
+		}
+		return nil, spanError(ctx, span, errors.Wrapf(err, "patching book with id %d", patch.Id))
+	}
+	return &book, nil
+}
+
+// duplicatePatchError tells which book patch would have duplicated,
+// reading the title or author left as it is from the book, which the
+// failed statement did not change.
+func duplicatePatchError(ctx context.Context, db Querier, span trace.Span, patch *models.BookPatch) error {
+	var title, author string
+	if patch.Title == nil || patch.Author == nil {
+		if err := db.QueryRowContext(ctx, getTitleAndAuthorQuery, patch.Id).Scan(&title, &author); err != nil {
+			return spanError(ctx, span, errors.Wrapf(err, "getting title and author of book with id %d", patch.Id))
+		}
+	}
+	if patch.Title != nil {
+		title = *patch.Title
+	}
+	if patch.Author != nil {
+		author = *patch.Author
+	}
+	return &ErrDuplicateBook{Title: title, Author: author}
+}
+
+// DeleteById removes a book record by its ID. If version is not zero,
+// the book is only removed if it is still at that version. Either way,
+// removing a book that does not exist fails with ErrBookNotFound.
+func DeleteById(ctx context.Context, db Querier, bookId, version int) error {
+	ctx, span := startSpan(ctx, "DeleteById", deleteByIdQuery)
+	defer span.End()
+	result, err := db.ExecContext(ctx, deleteByIdQuery, bookId, version)
 	if err != nil {
-		return nil, errors.Wrapf(err, "updating book with id %d", book.Id)
+		return spanError(ctx, span, errors.Wrapf(err, "deleting book with id %d", bookId))
 	}
-	rowsUpdated, err := result.RowsAffected()
+	rowsDeleted, err := result.RowsAffected()
 	if err != nil {
-		return nil, errors.Wrap(err, "checking affected rows")
+		return spanError(ctx, span, errors.Wrap(err, "checking affected rows"))
 	}
-	if rowsUpdated == 0 {
-		return nil, &ErrBookNotFound{Id: book.Id}
+	if rowsDeleted == 0 {
+		return notUpdatedError(ctx, db, span, bookId, version)
 	}
-	return book, nil
+	return nil
 }
 
-// DeleteById removes a book record by its ID.
-func DeleteById(ctx context.Context, db *sql.DB, bookId int) error {
-	if _, err := db.ExecContext(ctx, deleteByIdQuery, bookId); err != nil {
-		return errors.Wrapf(err, "deleting book with id %d", bookId)
+// notUpdatedError tells why the book with the given id was not changed
+// by a statement conditioned on its version: either it does not exist,
+// or it is not at the expected version anymore. Only failing to find
+// out is recorded in the span, as the other errors are the client's.
+func notUpdatedError(ctx context.Context, db Querier, span trace.Span, bookId, expected int) error {
+	var version int
+	if err := db.QueryRowContext(ctx, getVersionQuery, bookId).Scan(&version); err != nil {
+		if err == sql.ErrNoRows {
+			return &ErrBookNotFound{Id: bookId}
+		}
+		return spanError(ctx, span, errors.Wrapf(err, "getting version of book with id %d", bookId))
 	}
-	return nil
+	return &ErrVersionMismatch{Id: bookId, Version: version, Expected: expected}
+}
+
+// isDuplicateError tells whether err violates the uniqueness of the
+// title and author of books, as reported by either SQLite or PostgreSQL.
+func isDuplicateError(err error) bool {
+	var (
+		sqliteErr sqlite3.Error
+		pqErr     *pq.Error
+	)
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code == sqlite3.ErrConstraint
+	}
+	return errors.As(err, &pqErr) && pqErr.Code == uniqueViolation
+}
+
+// Search performs a full-text search over book titles and authors,
+// returning the best ranked matches first along with a snippet where
+// the matching terms are highlighted.
+func Search(ctx context.Context, db *sql.DB, query string) ([]*models.SearchResult, error) {
+	return search(ctx, db, searchQuery, matchExpression(query))
+}
+
+// search runs the given full-text search statement with the given
+// backend specific match expression.
+func search(ctx context.Context, db *sql.DB, stmt, match string) ([]*models.SearchResult, error) {
+	results := []*models.SearchResult{}
+	if match == "" {
+		return results, nil
+	}
+	ctx, span := startSpan(ctx, "Search", stmt)
+	defer span.End()
+	rows, err := db.QueryContext(ctx, stmt, match, searchLimit)
+	if err != nil {
+		return nil, spanError(ctx, span, errors.Wrap(err, "searching books"))
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var result models.SearchResult
+		if err := rows.Scan(&result.Id, &result.Title, &result.Author, &result.Pages, &result.Snippet, &result.Rank); err != nil {
+			return nil, spanError(ctx, span, errors.Wrap(err, "scanning search result"))
+		}
+		results = append(results, &result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, spanError(ctx, span, errors.Wrap(err, "iterating search results"))
+	}
+	return results, nil
+}
+
+// matchExpression turns free text into an FTS5 match expression in
+// which every term is quoted, so that user input cannot inject query
+// syntax, and matched as a prefix.
+func matchExpression(query string) string {
+	terms := strings.Fields(query)
+	for i, term := range terms {
+		terms[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"*`
+	}
+	return strings.Join(terms, " ")
 }
@@ -13,16 +13,32 @@ import (
 	"testing"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
 	"github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/require"
 	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
 )
 
 func TestList(t *testing.T) {
+	const (
+		firstPageQuery = `
+	SELECT id, title, author, pages
+	FROM books
+	ORDER BY id
+	LIMIT $1`
+
+		nextPageQuery = `
+	SELECT id, title, author, pages
+	FROM books
+	WHERE ((id > $1))
+	ORDER BY id
+	LIMIT $2`
+	)
 	testCases := []struct {
 		name           string
 		mockClosure    func() *sql.DB
-		expectedOutput []*models.Book
+		input          *models.ListParams
+		expectedOutput *models.BookPage
 		expectedError  error
 	}{
 		{
@@ -30,26 +46,54 @@ func TestList(t *testing.T) {
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectQuery(regexp.QuoteMeta(listQuery)).
+				mock.ExpectQuery(regexp.QuoteMeta(firstPageQuery)).WithArgs(3).
 					WillReturnRows(sqlmock.NewRows(
 						[]string{"id", "title", "author", "pages"}).
 						AddRow(1, "some title", "some author", 100).
 						AddRow(2, "another title", "another author", 150))
 				return db
 			},
-			expectedOutput: []*models.Book{
-				{
-					Id:     1,
-					Title:  "some title",
-					Author: "some author",
-					Pages:  100,
+			input: &models.ListParams{Limit: 2},
+			expectedOutput: &models.BookPage{
+				Books: []*models.Book{
+					{
+						Id:     1,
+						Title:  "some title",
+						Author: "some author",
+						Pages:  100,
+					},
+					{
+						Id:     2,
+						Title:  "another title",
+						Author: "another author",
+						Pages:  150,
+					},
 				},
-				{
-					Id:     2,
-					Title:  "another title",
-					Author: "another author",
-					Pages:  150,
+			},
+		},
+		{
+			name: "happy path, has more books",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(nextPageQuery)).WithArgs(1, 2).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages"}).
+						AddRow(2, "another title", "another author", 150).
+						AddRow(3, "yet another title", "yet another author", 200))
+				return db
+			},
+			input: &models.ListParams{Limit: 1, After: &models.Book{Id: 1}},
+			expectedOutput: &models.BookPage{
+				Books: []*models.Book{
+					{
+						Id:     2,
+						Title:  "another title",
+						Author: "another author",
+						Pages:  150,
+					},
 				},
+				HasMore: true,
 			},
 		},
 		{
@@ -57,12 +101,19 @@ func TestList(t *testing.T) {
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectQuery(regexp.QuoteMeta(listQuery)).
+				mock.ExpectQuery(regexp.QuoteMeta(firstPageQuery)).WithArgs(3).
 					WillReturnError(errors.New("select error"))
 				return db
 			},
+			input:         &models.ListParams{Limit: 2},
 			expectedError: errors.New("listing books: select error"),
 		},
+		{
+			name:          "invalid sort key",
+			mockClosure:   func() *sql.DB { return nil },
+			input:         &models.ListParams{Limit: 2, Sort: []string{"isbn"}},
+			expectedError: errors.New(`building list query: invalid sort key "isbn"`),
+		},
 		{
 			name: "error on scan",
 			mockClosure: func() *sql.DB {
@@ -70,18 +121,34 @@ func TestList(t *testing.T) {
 				require.NoError(t, err)
 				rows := sqlmock.NewRows([]string{"id", "title", "author", "pages"}).
 					AddRow("invalid", "data", "types", "here")
-				mock.ExpectQuery(regexp.QuoteMeta(listQuery)).
+				mock.ExpectQuery(regexp.QuoteMeta(firstPageQuery)).WithArgs(3).
 					WillReturnRows(rows)
 
 				return db
 			},
+			input:         &models.ListParams{Limit: 2},
 			expectedError: errors.New(`scanning book: sql: Scan error on column index 0, name "id": converting driver.Value type string ("invalid") to a int: invalid syntax`),
 		},
+		{
+			name: "error on iterating rows",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				rows := sqlmock.NewRows([]string{"id", "title", "author", "pages"}).
+					AddRow(1, "some title", "some author", 100).
+					RowError(0, errors.New("row error"))
+				mock.ExpectQuery(regexp.QuoteMeta(firstPageQuery)).WithArgs(3).
+					WillReturnRows(rows)
+				return db
+			},
+			input:         &models.ListParams{Limit: 2},
+			expectedError: errors.New("iterating books: row error"),
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			db := tc.mockClosure()
-			output, err := List(context.TODO(), db)
+			output, err := List(context.TODO(), db, tc.input)
 			if err != nil {
 				if tc.expectedError == nil {
 					t.Fatalf(`expected no error, got "%v"`, err)
@@ -97,6 +164,105 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestListQuery(t *testing.T) {
+	testCases := []struct {
+		name          string
+		input         *models.ListParams
+		likeOp        string
+		expectedQuery string
+		expectedArgs  []any
+	}{
+		{
+			name:   "no filters",
+			input:  &models.ListParams{Limit: 10},
+			likeOp: "LIKE",
+			expectedQuery: `
+	SELECT id, title, author, pages
+	FROM books
+	ORDER BY id
+	LIMIT $1`,
+			expectedArgs: []any{11},
+		},
+		{
+			name: "all filters",
+			input: &models.ListParams{
+				Limit:         10,
+				Author:        "some author",
+				TitleContains: "100%_real",
+				MinPages:      100,
+				MaxPages:      200,
+			},
+			likeOp: "LIKE",
+			expectedQuery: `
+	SELECT id, title, author, pages
+	FROM books
+	WHERE author = $1 AND title LIKE $2 ESCAPE '\' AND pages >= $3 AND pages <= $4
+	ORDER BY id
+	LIMIT $5`,
+			expectedArgs: []any{"some author", `%100\%\_real%`, 100, 200, 11},
+		},
+		{
+			name: "all filters, case-insensitive operator",
+			input: &models.ListParams{
+				Limit:         10,
+				Author:        "some author",
+				TitleContains: "100%_real",
+				MinPages:      100,
+				MaxPages:      200,
+			},
+			likeOp: "ILIKE",
+			expectedQuery: `
+	SELECT id, title, author, pages
+	FROM books
+	WHERE author = $1 AND title ILIKE $2 ESCAPE '\' AND pages >= $3 AND pages <= $4
+	ORDER BY id
+	LIMIT $5`,
+			expectedArgs: []any{"some author", `%100\%\_real%`, 100, 200, 11},
+		},
+		{
+			name: "sort with cursor",
+			input: &models.ListParams{
+				Limit: 10,
+				Sort:  []string{"title", "-pages", "title"},
+				After: &models.Book{Id: 7, Title: "some title", Author: "some author", Pages: 100},
+			},
+			likeOp: "LIKE",
+			expectedQuery: `
+	SELECT id, title, author, pages
+	FROM books
+	WHERE ((title > $1) OR (title = $2 AND pages < $3) OR (title = $4 AND pages = $5 AND id > $6))
+	ORDER BY title, pages DESC, id
+	LIMIT $7`,
+			expectedArgs: []any{"some title", "some title", 100, "some title", 100, 7, 11},
+		},
+		{
+			name: "descending id with filter and cursor",
+			input: &models.ListParams{
+				Limit:  10,
+				Author: "some author",
+				Sort:   []string{"-id"},
+				After:  &models.Book{Id: 7},
+			},
+			likeOp: "LIKE",
+			expectedQuery: `
+	SELECT id, title, author, pages
+	FROM books
+	WHERE author = $1 AND ((id < $2))
+	ORDER BY id DESC
+	LIMIT $3`,
+			expectedArgs: []any{"some author", 7, 11},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			query, args, err := listQuery(tc.input, tc.likeOp)
+			require.NoError(t, err)
+			require.Equal(t, tc.expectedQuery, query)
+			require.Equal(t, tc.expectedArgs, args)
+		})
+	}
+}
+
 func TestGetById(t *testing.T) {
 	testCases := []struct {
 		name           string
@@ -111,15 +277,16 @@ func TestGetById(t *testing.T) {
 				require.NoError(t, err)
 				mock.ExpectQuery(regexp.QuoteMeta(getByIdQuery)).WithArgs(1).
 					WillReturnRows(sqlmock.NewRows(
-						[]string{"id", "title", "author", "pages"}).
-						AddRow(1, "some title", "some author", 100))
+						[]string{"id", "title", "author", "pages", "version"}).
+						AddRow(1, "some title", "some author", 100, 2))
 				return db
 			},
 			expectedOutput: &models.Book{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
 		},
 		{
@@ -187,10 +354,11 @@ func TestCreate(t *testing.T) {
 				Pages:  100,
 			},
 			expectedOutput: &models.NewBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 1,
 			},
 		},
 		{
@@ -215,7 +383,7 @@ func TestCreate(t *testing.T) {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
 				mock.ExpectExec(regexp.QuoteMeta(createQuery)).WithArgs("some title", "some author", 100).
-					WillReturnError(&sqlite3.Error{Code: sqlite3.ErrConstraint})
+					WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint})
 				return db
 			},
 			input: &models.NewBook{
@@ -274,74 +442,162 @@ func TestUpdate(t *testing.T) {
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
-					WillReturnResult(sqlmock.NewResult(1, 1))
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
 			expectedOutput: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 3,
+			},
+		},
+		{
+			name: "happy path, any version",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 0).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(5))
+				return db
+			},
+			input: &models.UpdatedBook{
 				Id:     1,
 				Title:  "some title",
 				Author: "some author",
 				Pages:  100,
 			},
+			expectedOutput: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 5,
+			},
 		},
 		{
 			name: "error",
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
 					WillReturnError(errors.New("update error"))
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
 			expectedError: errors.New("updating book with id 1: update error"),
 		},
 		{
-			name: "error on rows affected",
+			name: "error, duplicate book",
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
-					WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("rows affected error")))
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint})
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
-			expectedError: errors.New("checking affected rows: rows affected error"),
+			expectedError: errors.New(`book with title "some title" from author "some author" already exists`),
+		},
+		{
+			name: "error, duplicate book in PostgreSQL",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				return db
+			},
+			input: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
+			},
+			expectedError: errors.New(`book with title "some title" from author "some author" already exists`),
 		},
 		{
 			name: "no book found",
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1).
-					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
 				return db
 			},
 			input: &models.UpdatedBook{
-				Id:     1,
-				Title:  "some title",
-				Author: "some author",
-				Pages:  100,
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
 			},
 			expectedError: errors.New("no book with id 1 found"),
 		},
+		{
+			name: "version mismatch",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				return db
+			},
+			input: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
+			},
+			expectedError: errors.New("book with id 1 is at version 3, not 2"),
+		},
+		{
+			name: "error getting version",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(updateQuery)).WithArgs("some title", "some author", 100, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			input: &models.UpdatedBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 2,
+			},
+			expectedError: errors.New("getting version of book with id 1: select error"),
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -362,41 +618,269 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestPatch(t *testing.T) {
+	title, author, pages := "another title", "another author", 120
+	bookRows := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "title", "author", "pages", "version"}).
+			AddRow(1, "another title", "some author", 120, 3)
+	}
+	testCases := []struct {
+		name           string
+		mockClosure    func() *sql.DB
+		input          *models.BookPatch
+		expectedOutput *models.Book
+		expectedError  error
+	}{
+		{
+			name: "happy path",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1, pages = $2, version = version + 1 WHERE id = $3 AND version = $4 RETURNING id, title, author, pages, version")).
+					WithArgs("another title", 120, 1, 2).
+					WillReturnRows(bookRows())
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedOutput: &models.Book{
+				Id:      1,
+				Title:   "another title",
+				Author:  "some author",
+				Pages:   120,
+				Version: 3,
+			},
+		},
+		{
+			name: "error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET pages = $1, version = version + 1 WHERE id = $2 AND version = $3")).
+					WithArgs(120, 1, 2).
+					WillReturnError(errors.New("update error"))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedError: errors.New("patching book with id 1: update error"),
+		},
+		{
+			name: "no book found",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET pages = $1")).
+					WithArgs(120, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedError: errors.New("no book with id 1 found"),
+		},
+		{
+			name: "version mismatch",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET pages = $1")).
+					WithArgs(120, 1, 2).
+					WillReturnError(sql.ErrNoRows)
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Pages:   &pages,
+				Version: 2,
+			},
+			expectedError: errors.New("book with id 1 is at version 3, not 2"),
+		},
+		{
+			name: "error, duplicate book",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1, author = $2")).
+					WithArgs("another title", "another author", 1, 2).
+					WillReturnError(sqlite3.Error{Code: sqlite3.ErrConstraint})
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Author:  &author,
+				Version: 2,
+			},
+			expectedError: errors.New(`book with title "another title" from author "another author" already exists`),
+		},
+		{
+			name: "error, duplicate book, author left as it is",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1")).
+					WithArgs("another title", 1, 2).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				mock.ExpectQuery(regexp.QuoteMeta(getTitleAndAuthorQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"title", "author"}).AddRow("some title", "some author"))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Version: 2,
+			},
+			expectedError: errors.New(`book with title "another title" from author "some author" already exists`),
+		},
+		{
+			name: "error, duplicate book, book not read",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("UPDATE books SET title = $1")).
+					WithArgs("another title", 1, 2).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				mock.ExpectQuery(regexp.QuoteMeta(getTitleAndAuthorQuery)).WithArgs(1).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			input: &models.BookPatch{
+				Id:      1,
+				Title:   &title,
+				Version: 2,
+			},
+			expectedError: errors.New("getting title and author of book with id 1: select error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := tc.mockClosure()
+			output, err := Patch(context.TODO(), db, tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
 func TestDeleteById(t *testing.T) {
 	testCases := []struct {
 		name          string
 		input         int
+		version       int
 		mockClosure   func() *sql.DB
 		expectedError error
 	}{
 		{
-			name:  "happy path",
-			input: 1,
+			name:    "happy path",
+			input:   1,
+			version: 2,
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1).
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 2).
 					WillReturnResult(sqlmock.NewResult(0, 1))
 				return db
 			},
 		},
 		{
-			name:  "error",
+			name:  "no book found, any version",
 			input: 1,
 			mockClosure: func() *sql.DB {
 				db, mock, err := sqlmock.New()
 				require.NoError(t, err)
-				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1).
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 0).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				return db
+			},
+			expectedError: errors.New("no book with id 1 found"),
+		},
+		{
+			name:    "error",
+			input:   1,
+			version: 2,
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 2).
 					WillReturnError(errors.New("delete error"))
 				return db
 			},
 			expectedError: errors.New("deleting book with id 1: delete error"),
 		},
+		{
+			name:    "error on rows affected",
+			input:   1,
+			version: 2,
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 2).
+					WillReturnResult(sqlmock.NewErrorResult(fmt.Errorf("rows affected error")))
+				return db
+			},
+			expectedError: errors.New("checking affected rows: rows affected error"),
+		},
+		{
+			name:    "no book found",
+			input:   1,
+			version: 2,
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 2).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				return db
+			},
+			expectedError: errors.New("no book with id 1 found"),
+		},
+		{
+			name:    "version mismatch",
+			input:   1,
+			version: 2,
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 2).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				return db
+			},
+			expectedError: errors.New("book with id 1 is at version 3, not 2"),
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			db := tc.mockClosure()
-			err := DeleteById(context.TODO(), db, tc.input)
+			err := DeleteById(context.TODO(), db, tc.input, tc.version)
 			if err != nil {
 				if tc.expectedError == nil {
 					t.Fatalf(`expected no error, got "%v"`, err)
@@ -410,3 +894,107 @@ func TestDeleteById(t *testing.T) {
 		})
 	}
 }
+
+func TestSearch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		mockClosure    func() *sql.DB
+		expectedOutput []*models.SearchResult
+		expectedError  error
+	}{
+		{
+			name:  "happy path",
+			input: `lord "rings`,
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"* """rings"*`, searchLimit).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "snippet", "rank"}).
+						AddRow(1, "the lord of the rings", "tolkien", 1000, "the <mark>lord</mark> of the <mark>rings</mark>", -1.5))
+				return db
+			},
+			expectedOutput: []*models.SearchResult{
+				{
+					Book: models.Book{
+						Id:     1,
+						Title:  "the lord of the rings",
+						Author: "tolkien",
+						Pages:  1000,
+					},
+					Snippet: "the <mark>lord</mark> of the <mark>rings</mark>",
+					Rank:    -1.5,
+				},
+			},
+		},
+		{
+			name:  "blank query",
+			input: "  ",
+			mockClosure: func() *sql.DB {
+				db, _, err := sqlmock.New()
+				require.NoError(t, err)
+				return db
+			},
+			expectedOutput: []*models.SearchResult{},
+		},
+		{
+			name:  "error",
+			input: "lord",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"*`, searchLimit).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			expectedError: errors.New("searching books: select error"),
+		},
+		{
+			name:  "error on scan",
+			input: "lord",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"*`, searchLimit).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "snippet", "rank"}).
+						AddRow("invalid", "data", "types", "here", "snippet", "rank"))
+				return db
+			},
+			expectedError: errors.New(`scanning search result: sql: Scan error on column index 0, name "id": converting driver.Value type string ("invalid") to a int: invalid syntax`),
+		},
+		{
+			name:  "error on iterating rows",
+			input: "lord",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(searchQuery)).WithArgs(`"lord"*`, searchLimit).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "snippet", "rank"}).
+						AddRow(1, "the lord of the rings", "tolkien", 1000, "snippet", -1.5).
+						RowError(0, errors.New("row error")))
+				return db
+			},
+			expectedError: errors.New("iterating search results: row error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db := tc.mockClosure()
+			output, err := Search(context.TODO(), db, tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
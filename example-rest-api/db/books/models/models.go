@@ -4,26 +4,132 @@
 
 package models
 
-// Book represents the model for a book record.
+// Book represents the model for a book record. Version is bumped
+// on every update, and is sent to clients as the book's ETag.
 type Book struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title"`
-	Author string `json:"author"`
-	Pages  int    `json:"pages"`
+	Id      int    `json:"id"`
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Pages   int    `json:"pages"`
+	Version int    `json:"-"`
 }
 
 // NewBook is used to create a new book record.
 type NewBook struct {
-	Id     int    `json:"id"`
-	Title  string `json:"title" validate:"required"`
-	Author string `json:"author" validate:"required"`
-	Pages  int    `json:"pages" validate:"required,gt=0"`
+	Id      int    `json:"id"`
+	Title   string `json:"title" validate:"required"`
+	Author  string `json:"author" validate:"required"`
+	Pages   int    `json:"pages" validate:"required,gt=0"`
+	Version int    `json:"-"`
 }
 
-// UpdateBook is used to update a book record.
+// UpdateBook is used to update a book record. Version is the version
+// the update is based on, if any, and the new version once updated.
 type UpdatedBook struct {
-	Id     int    `json:"id" validate:"required"`
-	Title  string `json:"title" validate:"required"`
-	Author string `json:"author" validate:"required"`
-	Pages  int    `json:"pages" validate:"required,gt=0"`
+	Id      int    `json:"id" validate:"required"`
+	Title   string `json:"title" validate:"required"`
+	Author  string `json:"author" validate:"required"`
+	Pages   int    `json:"pages" validate:"required,gt=0"`
+	Version int    `json:"-"`
+}
+
+// BookPatch holds the fields of a book changed by a patch, nil fields
+// being left as they are. Version is the version the patch was applied to.
+type BookPatch struct {
+	Id      int
+	Title   *string
+	Author  *string
+	Pages   *int
+	Version int
+}
+
+// Batch modes. In all-or-nothing mode, a batch is rolled back if any
+// of its operations fails, while in best-effort mode the operations
+// that succeeded are kept.
+const (
+	AllOrNothing = "all_or_nothing"
+	BestEffort   = "best_effort"
+)
+
+// Batch operations.
+const (
+	CreateOp = "create"
+	UpdateOp = "update"
+	DeleteOp = "delete"
+)
+
+// BatchRequest holds operations on books to be run within a single
+// transaction, in all-or-nothing mode unless told otherwise.
+type BatchRequest struct {
+	Mode       string       `json:"mode" validate:"omitempty,oneof=all_or_nothing best_effort"`
+	Operations []*BatchItem `json:"operations" validate:"required,min=1,max=1000"`
+}
+
+// BatchItem is an operation of a batch request. Creating a book takes its
+// title, author and pages, updating one takes its id as well and deleting
+// one takes its id only. IfMatch holds the ETag of the book an update or
+// deletion is based on, or "*".
+type BatchItem struct {
+	Op      string `json:"op" validate:"oneof=create update delete"`
+	Id      int    `json:"id"`
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+	Pages   int    `json:"pages"`
+	IfMatch string `json:"if_match"`
+}
+
+// DeletedBook identifies a book to be deleted. Version is the version
+// the deletion is based on, if any.
+type DeletedBook struct {
+	Id      int `json:"id" validate:"required"`
+	Version int `json:"-"`
+}
+
+// BatchOperation is an operation of a batch, which creates, updates or
+// deletes a book depending on which of its fields is set.
+type BatchOperation struct {
+	Create *NewBook
+	Update *UpdatedBook
+	Delete *DeletedBook
+}
+
+// BatchResult is the outcome of an operation of a batch: the book it
+// created or updated, or the error that made it fail.
+type BatchResult struct {
+	Book *Book
+	Err  error
+}
+
+// ListParams holds the parameters used to fetch a page of books.
+// Sort holds column names, optionally prefixed with '-' for
+// descending order. After is the last book of the previous page.
+type ListParams struct {
+	Limit         int      `json:"limit" validate:"gt=0,lte=1000"`
+	Author        string   `json:"author"`
+	TitleContains string   `json:"title_contains"`
+	MinPages      int      `json:"min_pages" validate:"gte=0"`
+	MaxPages      int      `json:"max_pages" validate:"omitempty,gtefield=MinPages"`
+	Sort          []string `json:"sort" validate:"dive,oneof=id -id title -title author -author pages -pages"`
+	After         *Book    `json:"-"`
+}
+
+// BookPage represents a page of book records.
+type BookPage struct {
+	Books      []*Book `json:"books"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+	HasMore    bool    `json:"-"`
+}
+
+// SearchResult represents a book matching a full-text search. Snippet
+// holds an excerpt with the matching terms highlighted, and Rank holds
+// the relevance of the match, the lower the better.
+type SearchResult struct {
+	Book
+	Snippet string  `json:"snippet"`
+	Rank    float64 `json:"rank"`
+}
+
+// SearchParams holds the parameters of a full-text search.
+type SearchParams struct {
+	Query string `json:"q" validate:"required"`
 }
@@ -0,0 +1,117 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+)
+
+// uniqueViolation is the Postgres error code for a unique constraint violation.
+const uniqueViolation = "23505"
+
+// SQL queries that differ from their SQLite counterparts.
+const (
+	postgresCreateQuery = `
+	INSERT INTO books (title, author, pages)
+	VALUES ($1, $2, $3)
+	RETURNING id
+	`
+
+	postgresSearchQuery = `
+	SELECT id, title, author, pages,
+		ts_headline('simple', title || ' ' || author, query, 'StartSel=<mark>, StopSel=</mark>, MaxWords=16, MinWords=1'),
+		-ts_rank(search, query) AS rank
+	FROM books, to_tsquery('simple', $1) AS query
+	WHERE search @@ query
+	ORDER BY rank
+	LIMIT $2
+	`
+)
+
+// PostgresStore is a BookStore backed by a PostgreSQL database.
+type PostgresStore struct {
+	db *sql.DB
+}
+
+// NewPostgresStore creates a new PostgresStore for the given database.
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db}
+}
+
+// List retrieves a page of books. Title filters are case-insensitive,
+// as they are with SQLite. See List.
+func (s *PostgresStore) List(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
+	return list(ctx, s.db, params, "ILIKE")
+}
+
+// GetById retrieves a book by its ID. See GetById.
+func (s *PostgresStore) GetById(ctx context.Context, bookId int) (*models.Book, error) {
+	return GetById(ctx, s.db, bookId)
+}
+
+// Create adds a new book record to the database. See postgresCreate.
+func (s *PostgresStore) Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
+	return postgresCreate(ctx, s.db, newBook)
+}
+
+// postgresCreate adds a new book record, getting its id back from
+// the insert statement, as PostgreSQL has no last insert id.
+func postgresCreate(ctx context.Context, db Querier, newBook *models.NewBook) (*models.NewBook, error) {
+	ctx, span := startSpan(ctx, "Create", postgresCreateQuery)
+	defer span.End()
+	row := db.QueryRowContext(ctx, postgresCreateQuery, newBook.Title, newBook.Author, newBook.Pages)
+	if err := row.Scan(&newBook.Id); err != nil {
+		if isDuplicateError(err) {
+			return nil, &ErrDuplicateBook{Title: newBook.Title, Author: newBook.Author}
+		}
+		return nil, spanError(ctx, span, errors.Wrap(err, "inserting book"))
+	}
+	newBook.Version = initialVersion
+	return newBook, nil
+}
+
+// Update modifies an existing book record. See Update.
+func (s *PostgresStore) Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	return Update(ctx, s.db, book)
+}
+
+// Patch sets the changed columns of a book record. See Patch.
+func (s *PostgresStore) Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+	return Patch(ctx, s.db, patch)
+}
+
+// DeleteById removes a book record by its ID. See DeleteById.
+func (s *PostgresStore) DeleteById(ctx context.Context, bookId, version int) error {
+	return DeleteById(ctx, s.db, bookId, version)
+}
+
+// Search performs a full-text search over book titles and authors,
+// returning the best ranked matches first along with a snippet where
+// the matching terms are highlighted.
+func (s *PostgresStore) Search(ctx context.Context, query string) ([]*models.SearchResult, error) {
+	return search(ctx, s.db, postgresSearchQuery, tsQueryExpression(query))
+}
+
+// Batch runs operations on books within a single transaction. See Batch.
+func (s *PostgresStore) Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	return batch(ctx, s.db, ops, bestEffort, postgresCreate)
+}
+
+// tsQueryExpression turns free text into a tsquery in which every term
+// is quoted, so that user input cannot inject query syntax, and matched
+// as a prefix.
+func tsQueryExpression(query string) string {
+	terms := strings.Fields(query)
+	quote := strings.NewReplacer(`\`, `\\`, `'`, `''`)
+	for i, term := range terms {
+		terms[i] = "'" + quote.Replace(term) + "':*"
+	}
+	return strings.Join(terms, " & ")
+}
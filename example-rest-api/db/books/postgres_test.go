@@ -0,0 +1,213 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+)
+
+func TestPostgresList(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE title ILIKE $1")).WithArgs("%lord%", 11).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "pages"}).
+			AddRow(1, "The Lord of the Rings", "tolkien", 1000))
+	output, err := NewPostgresStore(db).List(context.TODO(), &models.ListParams{Limit: 10, TitleContains: "lord"})
+	require.NoError(t, err)
+	require.Equal(t, &models.BookPage{
+		Books: []*models.Book{
+			{
+				Id:     1,
+				Title:  "The Lord of the Rings",
+				Author: "tolkien",
+				Pages:  1000,
+			},
+		},
+	}, output)
+}
+
+func TestPostgresCreate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		mockClosure    func() *sql.DB
+		input          *models.NewBook
+		expectedOutput *models.NewBook
+		expectedError  error
+	}{
+		{
+			name: "happy path",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(postgresCreateQuery)).WithArgs("some title", "some author", 100).
+					WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				return db
+			},
+			input: &models.NewBook{
+				Title:  "some title",
+				Author: "some author",
+				Pages:  100,
+			},
+			expectedOutput: &models.NewBook{
+				Id:      1,
+				Title:   "some title",
+				Author:  "some author",
+				Pages:   100,
+				Version: 1,
+			},
+		},
+		{
+			name: "error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(postgresCreateQuery)).WithArgs("some title", "some author", 100).
+					WillReturnError(errors.New("insert error"))
+				return db
+			},
+			input: &models.NewBook{
+				Title:  "some title",
+				Author: "some author",
+				Pages:  100,
+			},
+			expectedError: errors.New("inserting book: insert error"),
+		},
+		{
+			name: "error, duplicate book",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(postgresCreateQuery)).WithArgs("some title", "some author", 100).
+					WillReturnError(&pq.Error{Code: uniqueViolation})
+				return db
+			},
+			input: &models.NewBook{
+				Title:  "some title",
+				Author: "some author",
+				Pages:  100,
+			},
+			expectedError: errors.New(`book with title "some title" from author "some author" already exists`),
+		},
+		{
+			name: "error, other constraint violation",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(postgresCreateQuery)).WithArgs("some title", "some author", 100).
+					WillReturnError(&pq.Error{Code: "23502", Message: "not null violation"})
+				return db
+			},
+			input: &models.NewBook{
+				Title:  "some title",
+				Author: "some author",
+				Pages:  100,
+			},
+			expectedError: errors.New("inserting book: pq: not null violation"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			db := tc.mockClosure()
+			output, err := NewPostgresStore(db).Create(context.TODO(), tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
+func TestPostgresSearch(t *testing.T) {
+	testCases := []struct {
+		name           string
+		input          string
+		mockClosure    func() *sql.DB
+		expectedOutput []*models.SearchResult
+		expectedError  error
+	}{
+		{
+			name:  "happy path",
+			input: `lord o'brien\`,
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(postgresSearchQuery)).WithArgs(`'lord':* & 'o''brien\\':*`, searchLimit).
+					WillReturnRows(sqlmock.NewRows(
+						[]string{"id", "title", "author", "pages", "ts_headline", "rank"}).
+						AddRow(1, "the lord of the rings", "tolkien", 1000, "the <mark>lord</mark> of the rings", -0.06))
+				return db
+			},
+			expectedOutput: []*models.SearchResult{
+				{
+					Book: models.Book{
+						Id:     1,
+						Title:  "the lord of the rings",
+						Author: "tolkien",
+						Pages:  1000,
+					},
+					Snippet: "the <mark>lord</mark> of the rings",
+					Rank:    -0.06,
+				},
+			},
+		},
+		{
+			name:  "blank query",
+			input: "  ",
+			mockClosure: func() *sql.DB {
+				db, _, err := sqlmock.New()
+				require.NoError(t, err)
+				return db
+			},
+			expectedOutput: []*models.SearchResult{},
+		},
+		{
+			name:  "error",
+			input: "lord",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(postgresSearchQuery)).WithArgs(`'lord':*`, searchLimit).
+					WillReturnError(errors.New("select error"))
+				return db
+			},
+			expectedError: errors.New("searching books: select error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			db := tc.mockClosure()
+			output, err := NewPostgresStore(db).Search(context.TODO(), tc.input)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+)
+
+// listSelect is the base query used to list books.
+const listSelect = `
+	SELECT id, title, author, pages
+	FROM books`
+
+// sortColumns is the whitelist of columns books can be sorted by.
+// Sort keys are looked up here, so user input never reaches the
+// ORDER BY clause verbatim.
+var sortColumns = map[string]string{
+	"id":     "id",
+	"title":  "title",
+	"author": "author",
+	"pages":  "pages",
+}
+
+// sortKey is a column to order by, along with its direction.
+type sortKey struct {
+	column string
+	desc   bool
+}
+
+// listQuery builds the parameterized query that fetches a page of books
+// matching the filters, sort order and cursor held by params. likeOp is
+// the case-insensitive pattern matching operator of the backend.
+func listQuery(params *models.ListParams, likeOp string) (string, []any, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	// arg registers a query argument and returns its placeholder.
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if params.Author != "" {
+		conds = append(conds, "author = "+arg(params.Author))
+	}
+	if params.TitleContains != "" {
+		conds = append(conds, "title "+likeOp+" "+arg("%"+escapeLike(params.TitleContains)+"%")+` ESCAPE '\'`)
+	}
+	if params.MinPages > 0 {
+		conds = append(conds, "pages >= "+arg(params.MinPages))
+	}
+	if params.MaxPages > 0 {
+		conds = append(conds, "pages <= "+arg(params.MaxPages))
+	}
+	keys, err := sortKeys(params.Sort)
+	if err != nil {
+		return "", nil, err
+	}
+	if params.After != nil {
+		conds = append(conds, keysetCond(keys, params.After, arg))
+	}
+	var query strings.Builder
+	query.WriteString(listSelect)
+	if len(conds) > 0 {
+		query.WriteString("\n\tWHERE " + strings.Join(conds, " AND "))
+	}
+	order := make([]string, len(keys))
+	for i, key := range keys {
+		order[i] = key.column
+		if key.desc {
+			order[i] += " DESC"
+		}
+	}
+	query.WriteString("\n\tORDER BY " + strings.Join(order, ", "))
+	query.WriteString("\n\tLIMIT " + arg(params.Limit+1))
+	return query.String(), args, nil
+}
+
+// patchQuery builds the parameterized query that sets the columns changed
+// by patch and bumps the version of the book, as long as it is still at
+// the version the patch was applied to, returning the updated book.
+func patchQuery(patch *models.BookPatch) (string, []any) {
+	var (
+		sets []string
+		args []any
+	)
+	// arg registers a query argument and returns its placeholder.
+	arg := func(v any) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if patch.Title != nil {
+		sets = append(sets, "title = "+arg(*patch.Title))
+	}
+	if patch.Author != nil {
+		sets = append(sets, "author = "+arg(*patch.Author))
+	}
+	if patch.Pages != nil {
+		sets = append(sets, "pages = "+arg(*patch.Pages))
+	}
+	sets = append(sets, "version = version + 1")
+	var query strings.Builder
+	query.WriteString("\n\tUPDATE books")
+	query.WriteString("\n\tSET " + strings.Join(sets, ", "))
+	query.WriteString("\n\tWHERE id = " + arg(patch.Id) + " AND version = " + arg(patch.Version))
+	query.WriteString("\n\tRETURNING id, title, author, pages, version")
+	return query.String(), args
+}
+
+// sortKeys translates the requested sort into sort keys, appending
+// the id as a tiebreaker so that the order is always total, which
+// keyset pagination relies on.
+func sortKeys(sort []string) ([]sortKey, error) {
+	var keys []sortKey
+	seen := make(map[string]bool)
+	for _, s := range sort {
+		desc := strings.HasPrefix(s, "-")
+		column, ok := sortColumns[strings.TrimPrefix(s, "-")]
+		if !ok {
+			return nil, errors.Errorf("invalid sort key %q", s)
+		}
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+		keys = append(keys, sortKey{column: column, desc: desc})
+	}
+	if !seen["id"] {
+		keys = append(keys, sortKey{column: "id"})
+	}
+	return keys, nil
+}
+
+// keysetCond builds the condition that selects the rows coming after
+// the given book in the order defined by keys, such as
+// (title > $1) OR (title = $2 AND id > $3).
+func keysetCond(keys []sortKey, after *models.Book, arg func(v any) string) string {
+	var alternatives []string
+	for i, key := range keys {
+		var terms []string
+		for _, prev := range keys[:i] {
+			terms = append(terms, prev.column+" = "+arg(columnValue(after, prev.column)))
+		}
+		op := " > "
+		if key.desc {
+			op = " < "
+		}
+		terms = append(terms, key.column+op+arg(columnValue(after, key.column)))
+		alternatives = append(alternatives, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")"
+}
+
+// columnValue returns the value of the given column for a book.
+func columnValue(book *models.Book, column string) any {
+	switch column {
+	case "title":
+		return book.Title
+	case "author":
+		return book.Author
+	case "pages":
+		return book.Pages
+	default:
+		return book.Id
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in s, so that it is matched literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
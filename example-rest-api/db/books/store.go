@@ -0,0 +1,88 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/pkg/errors"
+	"github.com/tiagomelo/go-templates/example-rest-api/db"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+)
+
+// BookStore defines the operations used to manage books,
+// independently of the storage backend.
+type BookStore interface {
+	List(ctx context.Context, params *models.ListParams) (*models.BookPage, error)
+	GetById(ctx context.Context, bookId int) (*models.Book, error)
+	Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error)
+	Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error)
+	Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error)
+	DeleteById(ctx context.Context, bookId, version int) error
+	Search(ctx context.Context, query string) ([]*models.SearchResult, error)
+	Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+}
+
+// NewStore returns the BookStore matching the given database driver.
+func NewStore(driver string, sqlDb *sql.DB) (BookStore, error) {
+	switch driver {
+	case db.SqliteDriver:
+		return NewSqliteStore(sqlDb), nil
+	case db.PostgresDriver:
+		return NewPostgresStore(sqlDb), nil
+	}
+	return nil, errors.Errorf("no book store for database driver %q", driver)
+}
+
+// SqliteStore is a BookStore backed by a SQLite database.
+type SqliteStore struct {
+	db *sql.DB
+}
+
+// NewSqliteStore creates a new SqliteStore for the given database.
+func NewSqliteStore(db *sql.DB) *SqliteStore {
+	return &SqliteStore{db: db}
+}
+
+// List retrieves a page of books. See List.
+func (s *SqliteStore) List(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
+	return List(ctx, s.db, params)
+}
+
+// GetById retrieves a book by its ID. See GetById.
+func (s *SqliteStore) GetById(ctx context.Context, bookId int) (*models.Book, error) {
+	return GetById(ctx, s.db, bookId)
+}
+
+// Create adds a new book record. See Create.
+func (s *SqliteStore) Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
+	return Create(ctx, s.db, newBook)
+}
+
+// Update modifies an existing book record. See Update.
+func (s *SqliteStore) Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	return Update(ctx, s.db, book)
+}
+
+// Patch sets the changed columns of a book record. See Patch.
+func (s *SqliteStore) Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+	return Patch(ctx, s.db, patch)
+}
+
+// DeleteById removes a book record by its ID. See DeleteById.
+func (s *SqliteStore) DeleteById(ctx context.Context, bookId, version int) error {
+	return DeleteById(ctx, s.db, bookId, version)
+}
+
+// Search performs a full-text search over books. See Search.
+func (s *SqliteStore) Search(ctx context.Context, query string) ([]*models.SearchResult, error) {
+	return Search(ctx, s.db, query)
+}
+
+// Batch runs operations on books within a single transaction. See Batch.
+func (s *SqliteStore) Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	return Batch(ctx, s.db, ops, bestEffort)
+}
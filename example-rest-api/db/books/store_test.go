@@ -0,0 +1,54 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStore(t *testing.T) {
+	testCases := []struct {
+		name           string
+		driver         string
+		expectedOutput BookStore
+		expectedError  error
+	}{
+		{
+			name:           "sqlite",
+			driver:         "sqlite3",
+			expectedOutput: &SqliteStore{},
+		},
+		{
+			name:           "postgres",
+			driver:         "postgres",
+			expectedOutput: &PostgresStore{},
+		},
+		{
+			name:          "unsupported driver",
+			driver:        "mysql",
+			expectedError: errors.New(`no book store for database driver "mysql"`),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			output, err := NewStore(tc.driver, nil)
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+
+	testCases := []struct {
+		name           string
+		mockClosure    func() *sql.DB
+		call           func(ctx context.Context, db *sql.DB) error
+		expectedName   string
+		expectedStatus codes.Code
+		expectedError  error
+	}{
+		{
+			name: "query",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta("FROM books")).WithArgs(11).
+					WillReturnRows(sqlmock.NewRows([]string{"id", "title", "author", "pages"}))
+				return db
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				_, err := List(ctx, db, &models.ListParams{Limit: 10})
+				return err
+			},
+			expectedName:   "books.List",
+			expectedStatus: codes.Unset,
+		},
+		{
+			name: "exec error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 0).
+					WillReturnError(errors.New("delete error"))
+				return db
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				return DeleteById(ctx, db, 1, 0)
+			},
+			expectedName:   "books.DeleteById",
+			expectedStatus: codes.Error,
+			expectedError:  errors.New("deleting book with id 1: delete error"),
+		},
+		{
+			name: "not found is not an error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectQuery(regexp.QuoteMeta(getByIdQuery)).WithArgs(1).
+					WillReturnError(sql.ErrNoRows)
+				return db
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				_, err := GetById(ctx, db, 1)
+				return err
+			},
+			expectedName:   "books.GetById",
+			expectedStatus: codes.Unset,
+			expectedError:  errors.New("no book with id 1 found"),
+		},
+		{
+			name: "version mismatch is not an error",
+			mockClosure: func() *sql.DB {
+				db, mock, err := sqlmock.New()
+				require.NoError(t, err)
+				mock.ExpectExec(regexp.QuoteMeta(deleteByIdQuery)).WithArgs(1, 2).
+					WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(regexp.QuoteMeta(getVersionQuery)).WithArgs(1).
+					WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(3))
+				return db
+			},
+			call: func(ctx context.Context, db *sql.DB) error {
+				return DeleteById(ctx, db, 1, 2)
+			},
+			expectedName:   "books.DeleteById",
+			expectedStatus: codes.Unset,
+			expectedError:  errors.New("book with id 1 is at version 3, not 2"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			exporter.Reset()
+			ctx, parent := provider.Tracer("test").Start(context.TODO(), "parent")
+			err := tc.call(ctx, tc.mockClosure())
+			parent.End()
+			if tc.expectedError != nil {
+				require.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				require.NoError(t, err)
+			}
+
+			spans := exporter.GetSpans()
+			require.Len(t, spans, 2)
+			span := spans[0]
+			require.Equal(t, tc.expectedName, span.Name)
+			require.Equal(t, trace.SpanKindClient, span.SpanKind)
+			require.Equal(t, tc.expectedStatus, span.Status.Code)
+			require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext.TraceID())
+			require.Equal(t, parent.SpanContext().SpanID(), span.Parent.SpanID())
+			require.Contains(t, span.Attributes, attribute.String("db.sql.table", "books"))
+		})
+	}
+}
+
+func TestStartSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	})
+
+	_, span := startSpan(context.TODO(), "Update", updateQuery)
+	span.End()
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 1)
+	require.ElementsMatch(t, []attribute.KeyValue{
+		attribute.String("db.operation", "UPDATE"),
+		attribute.String("db.sql.table", "books"),
+		attribute.String("db.statement", "UPDATE books SET title = $1, author = $2, pages = $3, version = version + 1 WHERE id = $4 AND ($5 = 0 OR version = $5) RETURNING version"),
+	}, spans[0].Attributes)
+}
+
+func TestSpanErrorLogs(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil)).With(slog.String("request_id", "some-request-id"))
+	ctx := logging.WithLogger(context.TODO(), logger)
+	_, span := startSpan(ctx, "GetById", getByIdQuery)
+	err := spanError(ctx, span, errors.New("select error"))
+	span.End()
+	require.EqualError(t, err, "select error")
+	require.Contains(t, buf.String(), `level=ERROR msg="query failed" request_id=some-request-id err="select error"`)
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+)
+
+// newMemoryDb returns an empty in-memory SQLite database. The test is
+// skipped if SQLite lacks FTS5, as the migrations need it.
+func newMemoryDb(t *testing.T) *sql.DB {
+	db, err := sql.Open(SqliteDriver, ":memory:")
+	require.NoError(t, err)
+	// Every connection to :memory: opens a distinct database.
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	if err := checkFts5(context.TODO(), db); err != nil {
+		t.Skip(err)
+	}
+	return db
+}
+
+// tableExists tells whether the given table exists in a SQLite database.
+func tableExists(t *testing.T, db *sql.DB, table string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = $1", table).Scan(&count)
+	require.NoError(t, err)
+	return count > 0
+}
+
+// columnExists tells whether the given table of a SQLite database has the given column.
+func columnExists(t *testing.T, db *sql.DB, table, column string) bool {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM pragma_table_info($1) WHERE name = $2", table, column).Scan(&count)
+	require.NoError(t, err)
+	return count > 0
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.TODO()
+	db := newMemoryDb(t)
+
+	version, err := MigrationVersion(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+	// Reading the version does not write to the database.
+	require.False(t, tableExists(t, db, "schema_migrations"))
+
+	require.NoError(t, Migrate(ctx, db))
+	version, err = MigrationVersion(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 3, version)
+	require.True(t, tableExists(t, db, "books"))
+	require.True(t, tableExists(t, db, "books_fts"))
+	require.True(t, columnExists(t, db, "books", "version"))
+
+	// Applying again is a no-op.
+	require.NoError(t, Migrate(ctx, db))
+
+	require.NoError(t, MigrateDown(ctx, db))
+	version, err = MigrationVersion(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 2, version)
+	require.False(t, columnExists(t, db, "books", "version"))
+
+	require.NoError(t, MigrateDown(ctx, db))
+	version, err = MigrationVersion(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+	require.True(t, tableExists(t, db, "books"))
+	require.False(t, tableExists(t, db, "books_fts"))
+
+	require.NoError(t, MigrateDown(ctx, db))
+	version, err = MigrationVersion(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 0, version)
+	require.False(t, tableExists(t, db, "books"))
+
+	// Reverting with nothing applied is a no-op.
+	require.NoError(t, MigrateDown(ctx, db))
+}
+
+func TestMigrateDirtyDatabase(t *testing.T) {
+	ctx := context.TODO()
+	db := newMemoryDb(t)
+	_, err := db.Exec(createSchemaMigrationsQuery)
+	require.NoError(t, err)
+	_, err = db.Exec("INSERT INTO schema_migrations (version, dirty) VALUES (1, true)")
+	require.NoError(t, err)
+	err = Migrate(ctx, db)
+	require.EqualError(t, err, "database is dirty at version 1, fix it and force the version")
+}
+
+func TestMigrateFailedMigration(t *testing.T) {
+	ctx := context.TODO()
+	db := newMemoryDb(t)
+	_, err := db.Exec(createSchemaMigrationsQuery)
+	require.NoError(t, err)
+	// The books table already exists with another layout, so the
+	// backfill of the full-text index in migration 2 fails.
+	_, err = db.Exec("CREATE TABLE books (id INTEGER PRIMARY KEY)")
+	require.NoError(t, err)
+	err = Migrate(ctx, db)
+	require.ErrorContains(t, err, "applying migration 2: running migration")
+	version, err := MigrationVersion(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, 1, version)
+	require.False(t, tableExists(t, db, "books_fts"))
+}
+
+func TestMigrateConcurrently(t *testing.T) {
+	ctx := context.TODO()
+	// Processes sharing a database, such as replicas starting together,
+	// each have their own pool.
+	path := filepath.Join(t.TempDir(), "books.db")
+	const processes = 4
+	errs := make(chan error, processes)
+	for i := 0; i < processes; i++ {
+		db, err := sql.Open(SqliteDriver, path)
+		require.NoError(t, err)
+		t.Cleanup(func() { db.Close() })
+		if err := checkFts5(ctx, db); err != nil {
+			t.Skip(err)
+		}
+		go func() {
+			errs <- Migrate(ctx, db)
+		}()
+	}
+	for i := 0; i < processes; i++ {
+		require.NoError(t, <-errs)
+	}
+	db, err := sql.Open(SqliteDriver, path)
+	require.NoError(t, err)
+	defer db.Close()
+	migrations, err := loadMigrations(migrationsFS, migrationsDirs[SqliteDriver])
+	require.NoError(t, err)
+	version, err := MigrationVersion(ctx, db)
+	require.NoError(t, err)
+	require.Equal(t, migrations[len(migrations)-1].version, version)
+}
+
+func TestMigrateUnsupportedDriver(t *testing.T) {
+	db, _, err := sqlmock.New()
+	require.NoError(t, err)
+	err = Migrate(context.TODO(), db)
+	require.EqualError(t, err, "unsupported database driver *sqlmock.mockDriver")
+}
+
+func TestCheckFts5(t *testing.T) {
+	testCases := []struct {
+		name          string
+		mockClosure   func(mock sqlmock.Sqlmock)
+		expectedError error
+	}{
+		{
+			name: "happy path",
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(fts5Query)).
+					WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(1))
+			},
+		},
+		{
+			name: "fts5 missing",
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(fts5Query)).
+					WillReturnRows(sqlmock.NewRows([]string{"enabled"}).AddRow(0))
+			},
+			expectedError: errors.New("SQLite lacks the FTS5 extension needed by full-text search, build with -tags sqlite_fts5"),
+		},
+		{
+			name: "error",
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(regexp.QuoteMeta(fts5Query)).
+					WillReturnError(errors.New("random error"))
+			},
+			expectedError: errors.New("checking for FTS5: random error"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+			tc.mockClosure(mock)
+			err = checkFts5(context.TODO(), db)
+			if tc.expectedError != nil {
+				require.EqualError(t, err, tc.expectedError.Error())
+			} else {
+				require.NoError(t, err)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	testCases := []struct {
+		name           string
+		fsys           fstest.MapFS
+		expectedOutput []migration
+		expectedError  error
+	}{
+		{
+			name: "happy path",
+			fsys: fstest.MapFS{
+				"m/0002_second.up.sql":   {Data: []byte("up 2")},
+				"m/0002_second.down.sql": {Data: []byte("down 2")},
+				"m/0001_first.up.sql":    {Data: []byte("up 1")},
+				"m/0001_first.down.sql":  {Data: []byte("down 1")},
+				"m/README.md":            {Data: []byte("not a migration")},
+			},
+			expectedOutput: []migration{
+				{version: 1, up: "up 1", down: "down 1"},
+				{version: 2, up: "up 2", down: "down 2"},
+			},
+		},
+		{
+			name: "invalid version",
+			fsys: fstest.MapFS{
+				"m/first_migration.up.sql": {Data: []byte("up")},
+			},
+			expectedError: errors.New(`parsing version of first_migration.up.sql: strconv.Atoi: parsing "first": invalid syntax`),
+		},
+		{
+			name:          "missing directory",
+			fsys:          fstest.MapFS{},
+			expectedError: errors.New("reading directory m: open m: file does not exist"),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			output, err := loadMigrations(tc.fsys, "m")
+			if err != nil {
+				if tc.expectedError == nil {
+					t.Fatalf(`expected no error, got "%v"`, err)
+				}
+				require.Equal(t, tc.expectedError.Error(), err.Error())
+			} else {
+				if tc.expectedError != nil {
+					t.Fatalf(`expected error "%v", got nil`, tc.expectedError)
+				}
+				require.Equal(t, tc.expectedOutput, output)
+			}
+		})
+	}
+}
+
+func TestEmbeddedMigrations(t *testing.T) {
+	for driver, dir := range migrationsDirs {
+		migrations, err := loadMigrations(migrationsFS, dir)
+		require.NoError(t, err, driver)
+		require.NotEmpty(t, migrations, driver)
+		for i, m := range migrations {
+			require.Equal(t, i+1, m.version, driver)
+			require.NotEmpty(t, m.up, driver)
+			require.NotEmpty(t, m.down, driver)
+		}
+	}
+}
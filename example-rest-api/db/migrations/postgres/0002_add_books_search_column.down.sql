@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS books_search_idx;
+ALTER TABLE books DROP COLUMN IF EXISTS search;
\ No newline at end of file
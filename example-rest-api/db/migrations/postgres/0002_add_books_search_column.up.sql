@@ -0,0 +1,4 @@
+ALTER TABLE books ADD COLUMN IF NOT EXISTS search tsvector
+    GENERATED ALWAYS AS (to_tsvector('simple', title || ' ' || author)) STORED;
+
+CREATE INDEX IF NOT EXISTS books_search_idx ON books USING GIN (search);
\ No newline at end of file
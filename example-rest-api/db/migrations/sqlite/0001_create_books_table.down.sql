@@ -0,0 +1 @@
+DROP TABLE IF EXISTS books;
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP TRIGGER IF EXISTS books_fts_after_update;
+DROP TRIGGER IF EXISTS books_fts_after_delete;
+DROP TRIGGER IF EXISTS books_fts_after_insert;
+DROP TABLE IF EXISTS books_fts;
\ No newline at end of file
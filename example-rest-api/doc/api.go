@@ -1,18 +1,73 @@
 package doc
 
-import "github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+import (
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+	"github.com/tiagomelo/go-templates/example-rest-api/handlers/v1/books"
+	"github.com/tiagomelo/go-templates/example-rest-api/handlers/v1/health"
+	"github.com/tiagomelo/go-templates/example-rest-api/web"
+)
 
 // swagger:route GET /api/v1/books books List
-// List all books.
+// List books, one page at a time, optionally filtered and sorted.
 // ---
 // responses:
 //		200: listBooksResponse
-//		500: description: internal server error
+//		400: problemResponse
+//		500: problemResponse
+
+// swagger:parameters List
+type ListBooksParamsWrapper struct {
+	// Maximum number of books to return (1 to 1000, defaults to 100).
+	// in:query
+	Limit int `json:"limit"`
+	// Opaque cursor taken from the next_cursor field of a previous page
+	// listed with the same sort order and filters.
+	// in:query
+	After string `json:"after"`
+	// Only return books from this author.
+	// in:query
+	Author string `json:"author"`
+	// Only return books whose title contains this text.
+	// in:query
+	TitleContains string `json:"title_contains"`
+	// Only return books with at least this number of pages.
+	// in:query
+	MinPages int `json:"min_pages"`
+	// Only return books with at most this number of pages.
+	// in:query
+	MaxPages int `json:"max_pages"`
+	// Comma separated list of columns (id, title, author, pages) to sort by,
+	// each optionally prefixed with '-' for descending order.
+	// in:query
+	Sort string `json:"sort"`
+}
 
 // swagger:response listBooksResponse
 type ListBooksdResponseWrapper struct {
 	// in:body
-	Body []models.Book
+	Body models.BookPage
+}
+
+// swagger:route GET /api/v1/books/search books Search
+// Full-text search over book titles and authors, best matches first.
+// ---
+// responses:
+//		200: searchBooksResponse
+//		400: problemResponse
+//		500: problemResponse
+
+// swagger:parameters Search
+type SearchBooksParamsWrapper struct {
+	// Text to search for. Every term is matched as a prefix.
+	// in:query
+	// required: true
+	Query string `json:"q"`
+}
+
+// swagger:response searchBooksResponse
+type SearchBooksResponseWrapper struct {
+	// in:body
+	Body []models.SearchResult
 }
 
 // swagger:route GET /api/v1/book/{id} book GetById
@@ -20,9 +75,9 @@ type ListBooksdResponseWrapper struct {
 // ---
 // responses:
 //		200: getBookByIdResponse
-//		400: description: invalid id
-//		404: description: book not found
-//		500: description: internal server error
+//		400: problemResponse
+//		404: problemResponse
+//		500: problemResponse
 
 // swagger:parameters GetById
 type GetBookByIdParamWrapper struct {
@@ -32,6 +87,8 @@ type GetBookByIdParamWrapper struct {
 
 // swagger:response getBookByIdResponse
 type GetBookByIdResponseWrapper struct {
+	// ETag of the book, to be sent back in the If-Match header when changing it.
+	ETag string
 	// in:body
 	Body models.Book
 }
@@ -41,47 +98,152 @@ type GetBookByIdResponseWrapper struct {
 // ---
 // responses:
 //		201: createBookResponse
-//		400: description: missing required fields
-//		500: description: internal server error
+//		400: problemResponse
+//		409: problemResponse
+//		500: problemResponse
 
 // swagger:response createBookResponse
 type CreateBookResponseWrapper struct {
+	// ETag of the book, to be sent back in the If-Match header when changing it.
+	ETag string
 	// in:body
 	Body models.NewBook
 }
 
 // swagger:route PUT /api/v1/book/{id} book Update
-// Update a book.
+// Update a book, unless it was changed since the version given in If-Match.
 // ---
 // responses:
 //		200: updateBookResponse
-//		400: description: invalid id
-//		400: description: missing required fields
-//		404: description: book not found
-//		500: description: internal server error
+//		400: problemResponse
+//		404: problemResponse
+//		409: problemResponse
+//		412: problemResponse
+//		428: problemResponse
+//		500: problemResponse
 
 // swagger:parameters Update
 type UpdateBookByIdParamWrapper struct {
 	// in:path
 	Id int
+	// ETag of the book the update is based on, or "*" to update any version.
+	// in:header
+	// required: true
+	IfMatch string `json:"If-Match"`
 }
 
 // swagger:response updateBookResponse
 type UpdateBookResponseWrapper struct {
+	// ETag of the book, to be sent back in the If-Match header when changing it.
+	ETag string
 	// in:body
 	Body models.UpdatedBook
 }
 
+// swagger:route PATCH /api/v1/book/{id} book Patch
+// Partially update a book with a JSON merge patch (RFC 7396), unless it was changed since the version given in If-Match.
+// ---
+// consumes:
+// - application/merge-patch+json
+// responses:
+//		200: patchBookResponse
+//		400: problemResponse
+//		404: problemResponse
+//		409: problemResponse
+//		412: problemResponse
+//		415: problemResponse
+//		428: problemResponse
+//		500: problemResponse
+
+// swagger:parameters Patch
+type PatchBookByIdParamWrapper struct {
+	// in:path
+	Id int
+	// ETag of the book the patch is based on, or "*" to patch any version.
+	// in:header
+	// required: true
+	IfMatch string `json:"If-Match"`
+	// Fields to change, those set to null being cleared.
+	// in:body
+	Body map[string]any
+}
+
+// swagger:response patchBookResponse
+type PatchBookResponseWrapper struct {
+	// ETag of the book, to be sent back in the If-Match header when changing it.
+	ETag string
+	// in:body
+	Body models.Book
+}
+
 // swagger:route DELETE /api/v1/book/{id} book DeleteById
-// Delete a book by its id.
+// Delete a book by its id, unless it was changed since the version given in If-Match.
 // ---
 // responses:
 //		204: description: success
-//		400: description: invalid id
-//		500: description: internal server error
+//		400: problemResponse
+//		404: problemResponse
+//		412: problemResponse
+//		428: problemResponse
+//		500: problemResponse
 
 // swagger:parameters DeleteById
 type DeleteBookByIdParamWrapper struct {
 	// in:path
 	Id int
+	// ETag of the book the deletion is based on, or "*" to delete any version.
+	// in:header
+	// required: true
+	IfMatch string `json:"If-Match"`
+}
+
+// swagger:route POST /api/v1/books:batch books Batch
+// Create, update and delete books within a single transaction, which is rolled back if any operation fails, unless mode is best_effort.
+// ---
+// responses:
+//		200: batchResponse
+//		400: problemResponse
+//		500: problemResponse
+
+// swagger:parameters Batch
+type BatchParamsWrapper struct {
+	// in:body
+	Body models.BatchRequest
+}
+
+// swagger:response batchResponse
+type BatchResponseWrapper struct {
+	// in:body
+	Body books.BatchResponse
+}
+
+// swagger:response problemResponse
+type ProblemResponseWrapper struct {
+	// in:body
+	Body web.Problem
+}
+
+// swagger:route GET /healthz health Liveness
+// Tell whether the process is alive. It does not check the database.
+// ---
+// responses:
+//		200: livenessResponse
+
+// swagger:response livenessResponse
+type LivenessResponseWrapper struct {
+	// in:body
+	Body health.Liveness
+}
+
+// swagger:route GET /readyz health Readiness
+// Tell whether the API can serve traffic, which requires the database to be reachable.
+// ---
+// responses:
+//		200: readinessResponse
+//		503: readinessResponse
+
+// swagger:response readinessResponse
+type ReadinessResponseWrapper struct {
+	// in:body
+	Body health.Readiness
 }
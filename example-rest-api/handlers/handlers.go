@@ -7,21 +7,35 @@ package handlers
 import (
 	"database/sql"
 	"log/slog"
+	"sync/atomic"
 
 	"github.com/gorilla/mux"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books"
 	v1 "github.com/tiagomelo/go-templates/example-rest-api/handlers/v1"
+	"github.com/tiagomelo/go-templates/example-rest-api/middleware"
 )
 
 // ApiMuxConfig struct holds the configuration for the API.
 type ApiMuxConfig struct {
-	Db  *sql.DB
-	Log *slog.Logger
+	Store books.BookStore
+	// Db is checked by the readiness probe.
+	Db *sql.DB
+	// ShuttingDown is set once shutdown begins, which fails readiness.
+	ShuttingDown *atomic.Bool
+	Log          *slog.Logger
+	Metrics      *middleware.HttpMetrics
+	// VerboseErrors sends the text of internal errors to clients.
+	VerboseErrors bool
 }
 
 // NewApiMux creates and returns a new mux.Router configured with version 1 (v1) routes.
 func NewApiMux(c *ApiMuxConfig) *mux.Router {
 	return v1.Routes(&v1.Config{
-		Db:  c.Db,
-		Log: c.Log,
+		Store:         c.Store,
+		Db:            c.Db,
+		ShuttingDown:  c.ShuttingDown,
+		Log:           c.Log,
+		Metrics:       c.Metrics,
+		VerboseErrors: c.VerboseErrors,
 	})
 }
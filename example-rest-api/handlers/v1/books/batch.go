@@ -0,0 +1,166 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"net/http"
+
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+	"github.com/tiagomelo/go-templates/example-rest-api/validate"
+	"github.com/tiagomelo/go-templates/example-rest-api/web"
+)
+
+// BatchResponse holds the results of the operations of a batch request,
+// in the same order.
+type BatchResponse struct {
+	Results []*BatchItemResult `json:"results"`
+}
+
+// BatchItemResult is the outcome of an operation of a batch request. Status
+// is the HTTP status code the operation would have had on its own. The
+// book it created or updated comes along with its ETag, while an operation
+// that failed, or was rolled back as others failed, comes with the problem.
+type BatchItemResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	ETag   string       `json:"etag,omitempty"`
+	Book   *models.Book `json:"book,omitempty"`
+	Error  *web.Problem `json:"error,omitempty"`
+}
+
+// Batch handles the HTTP request to create, update and delete books in
+// bulk, within a single transaction. Invalid operations, and those that
+// fail, are reported by index along with the outcome of the others. In
+// all-or-nothing mode, the default, the batch is only run if all of its
+// operations are valid, and it is rolled back if any of them fails.
+func (h *handlers) Batch(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+	var req models.BatchRequest
+	if err := web.DecodeJson(r, &req); err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	if err := validate.Check(req); err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	bestEffort := req.Mode == models.BestEffort
+	results := make([]*BatchItemResult, len(req.Operations))
+	var (
+		ops     []*models.BatchOperation
+		indexes []int
+	)
+	failed := false
+	for i, item := range req.Operations {
+		op, err := batchOperation(item)
+		if err != nil {
+			results[i] = failedBatchItem(r, i, err)
+			failed = true
+			continue
+		}
+		ops = append(ops, op)
+		indexes = append(indexes, i)
+	}
+	if len(ops) > 0 && (bestEffort || !failed) {
+		opResults, err := h.store.Batch(r.Context(), ops, bestEffort)
+		if err != nil {
+			web.RespondWithError(w, r, err, h.verboseErrors)
+			return
+		}
+		for j, opResult := range opResults {
+			i := indexes[j]
+			if opResult.Err != nil {
+				results[i] = failedBatchItem(r, i, ifMatchError(opResult.Err, req.Operations[i].IfMatch == "*"))
+				failed = true
+				continue
+			}
+			results[i] = succeededBatchItem(i, ops[j], opResult.Book)
+		}
+	}
+	if failed && !bestEffort {
+		for i, result := range results {
+			if result == nil || result.Error == nil {
+				results[i] = failedBatchItem(r, i, web.ErrRolledBack)
+			}
+		}
+	}
+	web.RespondWithJson(w, http.StatusOK, &BatchResponse{Results: results})
+}
+
+// batchOperation turns an operation of a batch request into the
+// operation to be run, provided that it is valid.
+func batchOperation(item *models.BatchItem) (*models.BatchOperation, error) {
+	if err := validate.Check(item); err != nil {
+		return nil, err
+	}
+	if item.Op == models.CreateOp {
+		newBook := &models.NewBook{
+			Title:  item.Title,
+			Author: item.Author,
+			Pages:  item.Pages,
+		}
+		if err := validate.Check(newBook); err != nil {
+			return nil, err
+		}
+		return &models.BatchOperation{Create: newBook}, nil
+	}
+	if item.IfMatch == "" {
+		return nil, validate.FieldErrors{{Field: "if_match", Error: "if_match is a required field"}}
+	}
+	version, err := web.ParseETag(item.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	if item.Op == models.UpdateOp {
+		updatedBook := &models.UpdatedBook{
+			Id:      item.Id,
+			Title:   item.Title,
+			Author:  item.Author,
+			Pages:   item.Pages,
+			Version: version,
+		}
+		if err := validate.Check(updatedBook); err != nil {
+			return nil, err
+		}
+		return &models.BatchOperation{Update: updatedBook}, nil
+	}
+	deletedBook := &models.DeletedBook{
+		Id:      item.Id,
+		Version: version,
+	}
+	if err := validate.Check(deletedBook); err != nil {
+		return nil, err
+	}
+	return &models.BatchOperation{Delete: deletedBook}, nil
+}
+
+// succeededBatchItem returns the result of an operation of a batch
+// that succeeded, with the book it created or updated, if any.
+func succeededBatchItem(index int, op *models.BatchOperation, book *models.Book) *BatchItemResult {
+	result := &BatchItemResult{Index: index, Book: book}
+	switch {
+	case op.Create != nil:
+		result.Status = http.StatusCreated
+	case op.Update != nil:
+		result.Status = http.StatusOK
+	default:
+		result.Status = http.StatusNoContent
+	}
+	if book != nil {
+		result.ETag = web.ETag(book.Version)
+	}
+	return result
+}
+
+// failedBatchItem returns the result of an operation of a batch that
+// failed with err, which happened while serving r.
+func failedBatchItem(r *http.Request, index int, err error) *BatchItemResult {
+	p := web.NewProblem(r, err)
+	return &BatchItemResult{
+		Index:  index,
+		Status: p.Status,
+		Error:  p,
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package books
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
+)
+
+// Problems of the operations of a batch.
+const (
+	rolledBackProblem = `{"type":"/problems/rolled-back","title":"Rolled back","status":424,"detail":"rolled back as other operations of the batch failed","instance":"/books:batch"}`
+	duplicateProblem  = `{"type":"/problems/duplicate-book","title":"Duplicate book","status":409,"detail":"book with title \"some title\" from author \"some author\" already exists","instance":"/books:batch"}`
+)
+
+func TestBatch(t *testing.T) {
+	input := `{"operations":[` +
+		`{"op":"create","title":"some title","author":"some author","pages":100},` +
+		`{"op":"update","id":2,"title":"new title","author":"new author","pages":150,"if_match":"\"2\""},` +
+		`{"op":"delete","id":3,"if_match":"*"}]}`
+	testCases := []struct {
+		name               string
+		input              string
+		mockBatch          func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+		expectedOutput     string
+		expectedStatusCode int
+	}{
+		{
+			name:  "happy path",
+			input: input,
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				if len(ops) != 3 || ops[0].Create == nil || ops[1].Update == nil || ops[1].Update.Version != 2 || ops[2].Delete == nil || ops[2].Delete.Version != 0 || bestEffort {
+					return nil, errors.New("unexpected batch")
+				}
+				return []*models.BatchResult{
+					{Book: &models.Book{Id: 1, Title: "some title", Author: "some author", Pages: 100, Version: 1}},
+					{Book: &models.Book{Id: 2, Title: "new title", Author: "new author", Pages: 150, Version: 3}},
+					{},
+				}, nil
+			},
+			expectedOutput: `{"results":[` +
+				`{"index":0,"status":201,"etag":"\"1\"","book":{"id":1,"title":"some title","author":"some author","pages":100}},` +
+				`{"index":1,"status":200,"etag":"\"3\"","book":{"id":2,"title":"new title","author":"new author","pages":150}},` +
+				`{"index":2,"status":204}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:  "all or nothing, operation failed",
+			input: input,
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return []*models.BatchResult{
+					{Err: &books.ErrDuplicateBook{Title: "some title", Author: "some author"}},
+					{Book: &models.Book{Id: 2, Title: "new title", Author: "new author", Pages: 150, Version: 3}},
+					{},
+				}, nil
+			},
+			expectedOutput: `{"results":[` +
+				`{"index":0,"status":409,"error":` + duplicateProblem + `},` +
+				`{"index":1,"status":424,"error":` + rolledBackProblem + `},` +
+				`{"index":2,"status":424,"error":` + rolledBackProblem + `}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "best effort, book not found",
+			input: `{"mode":"best_effort","operations":[` +
+				`{"op":"delete","id":3,"if_match":"\"1\""},` +
+				`{"op":"delete","id":3,"if_match":"*"}]}`,
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return []*models.BatchResult{
+					{Err: &books.ErrBookNotFound{Id: 3}},
+					{Err: &books.ErrBookNotFound{Id: 3}},
+				}, nil
+			},
+			expectedOutput: `{"results":[` +
+				`{"index":0,"status":404,"error":{"type":"/problems/book-not-found","title":"Book not found","status":404,"detail":"no book with id 3 found","instance":"/books:batch"}},` +
+				`{"index":1,"status":412,"error":{"type":"/problems/version-mismatch","title":"Version mismatch","status":412,"detail":"the book does not exist, so \"*\" matches no version of it","instance":"/books:batch"}}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "all or nothing, invalid operations",
+			input: `{"mode":"all_or_nothing","operations":[` +
+				`{"op":"create","title":"some title","author":"some author","pages":100},` +
+				`{"op":"create","author":"some author","pages":100},` +
+				`{"op":"update","id":2,"title":"new title","author":"new author","pages":150},` +
+				`{"op":"delete","id":3,"if_match":"W/\"1\""},` +
+				`{"op":"rename"}]}`,
+			expectedOutput: `{"results":[` +
+				`{"index":0,"status":424,"error":` + rolledBackProblem + `},` +
+				`{"index":1,"status":400,"error":{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"/books:batch","errors":[{"field":"title","error":"title is a required field"}]}},` +
+				`{"index":2,"status":400,"error":{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"/books:batch","errors":[{"field":"if_match","error":"if_match is a required field"}]}},` +
+				`{"index":3,"status":400,"error":{"type":"/problems/invalid-etag","title":"Invalid entity tag","status":400,"detail":"invalid entity tag","instance":"/books:batch"}},` +
+				`{"index":4,"status":400,"error":{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"/books:batch","errors":[{"field":"op","error":"op must be one of [create update delete]"}]}}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name: "best effort",
+			input: `{"mode":"best_effort","operations":[` +
+				`{"op":"create","title":"some title","author":"some author","pages":100},` +
+				`{"op":"delete","if_match":"*"},` +
+				`{"op":"create","title":"another title","author":"another author","pages":150}]}`,
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				if len(ops) != 2 || ops[1].Create.Title != "another title" || !bestEffort {
+					return nil, errors.New("unexpected batch")
+				}
+				return []*models.BatchResult{
+					{Err: &books.ErrDuplicateBook{Title: "some title", Author: "some author"}},
+					{Book: &models.Book{Id: 2, Title: "another title", Author: "another author", Pages: 150, Version: 1}},
+				}, nil
+			},
+			expectedOutput: `{"results":[` +
+				`{"index":0,"status":409,"error":` + duplicateProblem + `},` +
+				`{"index":1,"status":400,"error":{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"/books:batch","errors":[{"field":"id","error":"id is a required field"}]}},` +
+				`{"index":2,"status":201,"etag":"\"1\"","book":{"id":2,"title":"another title","author":"another author","pages":150}}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "error on decoding payload",
+			input:              `{"operations":`,
+			expectedOutput:     `{"type":"/problems/malformed-body","title":"Malformed request body","status":400,"detail":"malformed request body: unexpected EOF","instance":"/books:batch"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid request",
+			input:              `{"mode":"eventually","operations":[]}`,
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"/books:batch","errors":[{"field":"mode","error":"mode must be one of [all_or_nothing best_effort]"},{"field":"operations","error":"operations must contain at least 1 item"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:  "error",
+			input: input,
+			mockBatch: func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+				return nil, errors.New("batch error")
+			},
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"/books:batch","incident":"<incident>"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest(http.MethodPost, "/books:batch", bytes.NewBuffer([]byte(tc.input)))
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+			h := New(&mockStore{batch: tc.mockBatch}, false)
+			handler := http.HandlerFunc((h).Batch)
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.expectedStatusCode, rr.Code)
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
+		})
+	}
+}
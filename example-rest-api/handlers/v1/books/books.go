@@ -5,11 +5,10 @@
 package books
 
 import (
-	"database/sql"
-	"encoding/json"
 	"errors"
-	"io"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"github.com/tiagomelo/go-templates/example-rest-api/db/books"
 	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
@@ -17,59 +16,63 @@ import (
 	"github.com/tiagomelo/go-templates/example-rest-api/web"
 )
 
-// handlers struct holds a database connection.
+// handlers struct holds the store where books are kept and whether
+// the text of internal errors is sent to clients.
 type handlers struct {
-	db *sql.DB
+	store         books.BookStore
+	verboseErrors bool
 }
 
-// New initializes a new instance of handlers with a database connection.
-func New(db *sql.DB) *handlers {
+// New initializes a new instance of handlers with a book store.
+func New(store books.BookStore, verboseErrors bool) *handlers {
 	return &handlers{
-		db: db,
+		store:         store,
+		verboseErrors: verboseErrors,
 	}
 }
 
-// For ease of unit testing.
-var (
-	listBooks   = books.List
-	getBookById = books.GetById
-	createBook  = books.Create
-	updateBook  = books.Update
-	deleteBook  = books.DeleteById
+// defaultPageSize is the number of books returned when the client
+// does not specify a limit.
+const defaultPageSize = 100
 
-	// jsonDecode decodes a JSON request body into a given struct.
-	jsonDecode = func(r io.Reader, v any) error {
-		return json.NewDecoder(r).Decode(v)
-	}
-)
-
-// List handles the HTTP request to list all books.
+// List handles the HTTP request to list books, one page at a time.
 func (h *handlers) List(w http.ResponseWriter, r *http.Request) {
-	books, err := listBooks(r.Context(), h.db)
+	params, err := listParams(r)
+	if err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	page, err := h.store.List(r.Context(), params)
 	if err != nil {
-		web.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
-	web.RespondWithJson(w, http.StatusOK, books)
+	if page.HasMore {
+		lastBook := page.Books[len(page.Books)-1]
+		page.NextCursor = web.EncodeCursor(&web.Cursor{
+			Id:      lastBook.Id,
+			Title:   lastBook.Title,
+			Author:  lastBook.Author,
+			Pages:   lastBook.Pages,
+			Listing: listing(params),
+		})
+	}
+	web.RespondWithJson(w, http.StatusOK, page)
 }
 
 // GetById handles the HTTP request to retrieve a book by its ID.
 func (h *handlers) GetById(w http.ResponseWriter, r *http.Request) {
 	bookId, err := web.BookIdPathParam(r)
 	if err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
-	book, err := getBookById(r.Context(), h.db, bookId)
+	book, err := h.store.GetById(r.Context(), bookId)
 	if err != nil {
-		var bookNotFoundErr *books.ErrBookNotFound
-		if errors.As(err, &bookNotFoundErr) {
-			web.RespondWithError(w, http.StatusNotFound, err.Error())
-			return
-		}
-		web.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
+	w.Header().Set("ETag", web.ETag(book.Version))
 	web.RespondWithJson(w, http.StatusOK, book)
 }
 
@@ -77,68 +80,246 @@ func (h *handlers) GetById(w http.ResponseWriter, r *http.Request) {
 func (h *handlers) Create(w http.ResponseWriter, r *http.Request) {
 	defer r.Body.Close()
 	var newBook models.NewBook
-	if err := jsonDecode(r.Body, &newBook); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, err.Error())
+	if err := web.DecodeJson(r, &newBook); err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
 	if err := validate.Check(newBook); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
-	book, err := createBook(r.Context(), h.db, &newBook)
+	book, err := h.store.Create(r.Context(), &newBook)
 	if err != nil {
-		var duplicateBookErr *books.ErrDuplicateBook
-		if errors.As(err, &duplicateBookErr) {
-			web.RespondWithError(w, http.StatusConflict, err.Error())
-			return
-		}
-		web.RespondWithError(w, http.StatusInternalServerError, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
+	w.Header().Set("ETag", web.ETag(book.Version))
 	web.RespondWithJson(w, http.StatusCreated, book)
 }
 
-// Update handles the HTTP request to update an existing book.
+// Update handles the HTTP request to update an existing book, which
+// must still be at the version given in the 'If-Match' header.
 func (h *handlers) Update(w http.ResponseWriter, r *http.Request) {
 	bookId, err := web.BookIdPathParam(r)
 	if err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	version, err := web.IfMatchVersion(r)
+	if err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
 	defer r.Body.Close()
 	var updatedBook models.UpdatedBook
-	if err := jsonDecode(r.Body, &updatedBook); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, err.Error())
+	if err := web.DecodeJson(r, &updatedBook); err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
 	updatedBook.Id = bookId
+	updatedBook.Version = version
 	if err := validate.Check(updatedBook); err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	book, err := h.store.Update(r.Context(), &updatedBook)
+	if err != nil {
+		web.RespondWithError(w, r, ifMatchError(err, version == web.AnyVersion), h.verboseErrors)
+		return
+	}
+	w.Header().Set("ETag", web.ETag(book.Version))
+	web.RespondWithJson(w, http.StatusOK, book)
+}
+
+// Patch handles the HTTP request to partially update an existing book
+// with a JSON merge patch (RFC 7396). The patch is applied to the stored
+// book, which must still be at the version given in the 'If-Match'
+// header, and the result is validated as a whole before persisting the
+// fields that changed.
+func (h *handlers) Patch(w http.ResponseWriter, r *http.Request) {
+	bookId, err := web.BookIdPathParam(r)
+	if err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
-	book, err := updateBook(r.Context(), h.db, &updatedBook)
+	version, err := web.IfMatchVersion(r)
 	if err != nil {
-		var bookNotFoundErr *books.ErrBookNotFound
-		if errors.As(err, &bookNotFoundErr) {
-			web.RespondWithError(w, http.StatusNotFound, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	defer r.Body.Close()
+	mergePatch, err := web.ReadMergePatch(r)
+	if err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	book, err := h.store.GetById(r.Context(), bookId)
+	if err != nil {
+		web.RespondWithError(w, r, ifMatchError(err, version == web.AnyVersion), h.verboseErrors)
+		return
+	}
+	if version != web.AnyVersion && version != book.Version {
+		web.RespondWithError(w, r, &books.ErrVersionMismatch{Id: bookId, Version: book.Version, Expected: version}, h.verboseErrors)
+		return
+	}
+	var patchedBook models.UpdatedBook
+	if err := web.ApplyMergePatch(book, mergePatch, &patchedBook); err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	patchedBook.Id = bookId
+	if err := validate.Check(patchedBook); err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	if patch := bookPatch(book, &patchedBook); patch != nil {
+		if book, err = h.store.Patch(r.Context(), patch); err != nil {
+			web.RespondWithError(w, r, ifMatchError(err, version == web.AnyVersion), h.verboseErrors)
 			return
 		}
-		web.RespondWithError(w, http.StatusInternalServerError, err.Error())
-		return
 	}
+	w.Header().Set("ETag", web.ETag(book.Version))
 	web.RespondWithJson(w, http.StatusOK, book)
 }
 
-// DeleteById handles the HTTP request to delete a book by its ID.
+// DeleteById handles the HTTP request to delete a book by its ID, which
+// must still be at the version given in the 'If-Match' header.
 func (h *handlers) DeleteById(w http.ResponseWriter, r *http.Request) {
 	bookId, err := web.BookIdPathParam(r)
 	if err != nil {
-		web.RespondWithError(w, http.StatusBadRequest, err.Error())
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	version, err := web.IfMatchVersion(r)
+	if err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
 		return
 	}
-	if err := deleteBook(r.Context(), h.db, bookId); err != nil {
-		web.RespondWithError(w, http.StatusInternalServerError, err.Error())
+	if err := h.store.DeleteById(r.Context(), bookId, version); err != nil {
+		web.RespondWithError(w, r, ifMatchError(err, version == web.AnyVersion), h.verboseErrors)
 		return
 	}
 	web.RespondWithStatus(w, http.StatusNoContent)
 }
+
+// Search handles the HTTP request to perform a full-text search over
+// book titles and authors.
+func (h *handlers) Search(w http.ResponseWriter, r *http.Request) {
+	params := models.SearchParams{Query: r.URL.Query().Get("q")}
+	if err := validate.Check(params); err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	results, err := h.store.Search(r.Context(), params.Query)
+	if err != nil {
+		web.RespondWithError(w, r, err, h.verboseErrors)
+		return
+	}
+	web.RespondWithJson(w, http.StatusOK, results)
+}
+
+// bookPatch returns the fields that differ between a stored book and
+// its patched version, to be applied to the version the book is at,
+// or nil if there are none.
+func bookPatch(book *models.Book, patchedBook *models.UpdatedBook) *models.BookPatch {
+	patch := &models.BookPatch{Id: book.Id, Version: book.Version}
+	changed := false
+	if patchedBook.Title != book.Title {
+		patch.Title, changed = &patchedBook.Title, true
+	}
+	if patchedBook.Author != book.Author {
+		patch.Author, changed = &patchedBook.Author, true
+	}
+	if patchedBook.Pages != book.Pages {
+		patch.Pages, changed = &patchedBook.Pages, true
+	}
+	if !changed {
+		return nil
+	}
+	return patch
+}
+
+// ifMatchError returns the error to report for err, which happened while
+// changing a book conditioned on an 'If-Match' header: a book that does
+// not exist fails the precondition if the header was "*", as there is
+// no version for it to match, instead of not being found.
+func ifMatchError(err error, anyVersion bool) error {
+	var notFoundErr *books.ErrBookNotFound
+	if anyVersion && errors.As(err, &notFoundErr) {
+		return web.ErrNoVersionToMatch
+	}
+	return err
+}
+
+// listParams builds the parameters for listing books from the request's
+// query string: 'limit', 'after', 'author', 'title_contains', 'min_pages',
+// 'max_pages' and 'sort', the latter being a comma separated list of
+// columns, each optionally prefixed with '-' for descending order. A
+// cursor given in 'after' must have been issued for the same sort order
+// and filters.
+func listParams(r *http.Request) (*models.ListParams, error) {
+	query := r.URL.Query()
+	params := &models.ListParams{
+		Limit:         defaultPageSize,
+		Author:        query.Get("author"),
+		TitleContains: query.Get("title_contains"),
+	}
+	intParams := []struct {
+		name string
+		dest *int
+	}{
+		{name: "limit", dest: &params.Limit},
+		{name: "min_pages", dest: &params.MinPages},
+		{name: "max_pages", dest: &params.MaxPages},
+	}
+	var fieldErrors validate.FieldErrors
+	for _, p := range intParams {
+		value := query.Get(p.name)
+		if value == "" {
+			continue
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			fieldErrors = append(fieldErrors, validate.FieldError{Field: p.name, Error: p.name + " must be a number"})
+			continue
+		}
+		*p.dest = v
+	}
+	if len(fieldErrors) > 0 {
+		return nil, fieldErrors
+	}
+	if sort := query.Get("sort"); sort != "" {
+		params.Sort = strings.Split(sort, ",")
+	}
+	if after := query.Get("after"); after != "" {
+		cursor, err := web.DecodeCursor(after)
+		if err != nil {
+			return nil, err
+		}
+		if !cursor.Listing.Equal(listing(params)) {
+			return nil, web.ErrCursorMismatch
+		}
+		params.After = &models.Book{
+			Id:     cursor.Id,
+			Title:  cursor.Title,
+			Author: cursor.Author,
+			Pages:  cursor.Pages,
+		}
+	}
+	if err := validate.Check(params); err != nil {
+		return nil, err
+	}
+	return params, nil
+}
+
+// listing returns the sort order and filters of the listing described by
+// params, which the cursors handed to clients are tied to.
+func listing(params *models.ListParams) web.Listing {
+	return web.Listing{
+		Sort:          params.Sort,
+		Author:        params.Author,
+		TitleContains: params.TitleContains,
+		MinPages:      params.MinPages,
+		MaxPages:      params.MaxPages,
+	}
+}
@@ -7,12 +7,12 @@ package books
 import (
 	"bytes"
 	"context"
-	"database/sql"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
 	"testing"
 
 	"github.com/gorilla/mux"
@@ -21,54 +21,225 @@ import (
 	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
 )
 
+// mockStore is a books.BookStore whose behavior is set per test case.
+type mockStore struct {
+	list       func(ctx context.Context, params *models.ListParams) (*models.BookPage, error)
+	getById    func(ctx context.Context, bookId int) (*models.Book, error)
+	create     func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error)
+	update     func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error)
+	patch      func(ctx context.Context, patch *models.BookPatch) (*models.Book, error)
+	deleteById func(ctx context.Context, bookId, version int) error
+	search     func(ctx context.Context, query string) ([]*models.SearchResult, error)
+	batch      func(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error)
+}
+
+func (m *mockStore) List(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
+	return m.list(ctx, params)
+}
+
+func (m *mockStore) GetById(ctx context.Context, bookId int) (*models.Book, error) {
+	return m.getById(ctx, bookId)
+}
+
+func (m *mockStore) Create(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
+	return m.create(ctx, newBook)
+}
+
+func (m *mockStore) Update(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+	return m.update(ctx, book)
+}
+
+func (m *mockStore) Patch(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+	return m.patch(ctx, patch)
+}
+
+func (m *mockStore) DeleteById(ctx context.Context, bookId, version int) error {
+	return m.deleteById(ctx, bookId, version)
+}
+
+func (m *mockStore) Search(ctx context.Context, query string) ([]*models.SearchResult, error) {
+	return m.search(ctx, query)
+}
+
+func (m *mockStore) Batch(ctx context.Context, ops []*models.BatchOperation, bestEffort bool) ([]*models.BatchResult, error) {
+	return m.batch(ctx, ops, bestEffort)
+}
+
+// incidentRegexp matches the random incident id of internal errors.
+var incidentRegexp = regexp.MustCompile(`"incident":"[0-9a-f]{16}"`)
+
+// withoutIncident replaces the incident id in body with a placeholder,
+// so that it can be compared against the expected output.
+func withoutIncident(body string) string {
+	return incidentRegexp.ReplaceAllString(body, `"incident":"<incident>"`)
+}
+
 func TestList(t *testing.T) {
 	testCases := []struct {
 		name               string
-		mockListBooks      func(ctx context.Context, db *sql.DB) ([]*models.Book, error)
+		query              string
+		mockListBooks      func(ctx context.Context, params *models.ListParams) (*models.BookPage, error)
 		expectedOutput     string
 		expectedStatusCode int
 	}{
 		{
 			name: "happy path",
-			mockListBooks: func(ctx context.Context, db *sql.DB) ([]*models.Book, error) {
-				return []*models.Book{
-					{
-						Id:     1,
-						Title:  "some title",
-						Author: "some author",
-						Pages:  100,
+			mockListBooks: func(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
+				return &models.BookPage{
+					Books: []*models.Book{
+						{
+							Id:     1,
+							Title:  "some title",
+							Author: "some author",
+							Pages:  100,
+						},
+						{
+							Id:     2,
+							Title:  "another title",
+							Author: "another author",
+							Pages:  150,
+						},
 					},
-					{
-						Id:     2,
-						Title:  "another title",
-						Author: "another author",
-						Pages:  150,
+				}, nil
+			},
+			expectedOutput:     `{"books":[{"id":1,"title":"some title","author":"some author","pages":100},{"id":2,"title":"another title","author":"another author","pages":150}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:  "happy path, with next cursor",
+			query: "?limit=1&after=eyJpZCI6MX0",
+			mockListBooks: func(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
+				if params.Limit != 1 || params.After.Id != 1 {
+					return nil, fmt.Errorf("unexpected params %+v", params)
+				}
+				return &models.BookPage{
+					Books: []*models.Book{
+						{
+							Id:     2,
+							Title:  "another title",
+							Author: "another author",
+							Pages:  150,
+						},
 					},
+					HasMore: true,
 				}, nil
 			},
-			expectedOutput:     `[{"id":1,"title":"some title","author":"some author","pages":100},{"id":2,"title":"another title","author":"another author","pages":150}]`,
+			expectedOutput:     `{"books":[{"id":2,"title":"another title","author":"another author","pages":150}],"next_cursor":"eyJpZCI6MiwidGl0bGUiOiJhbm90aGVyIHRpdGxlIiwiYXV0aG9yIjoiYW5vdGhlciBhdXRob3IiLCJwYWdlcyI6MTUwLCJsaXN0aW5nIjp7fX0"}`,
 			expectedStatusCode: http.StatusOK,
 		},
+		{
+			name:  "happy path, with cursor of the same sort order and filters",
+			query: "?author=some+author&sort=-pages&limit=1&after=eyJpZCI6MSwidGl0bGUiOiJzb21lIHRpdGxlIiwiYXV0aG9yIjoic29tZSBhdXRob3IiLCJwYWdlcyI6MTAwLCJsaXN0aW5nIjp7InNvcnQiOlsiLXBhZ2VzIl0sImF1dGhvciI6InNvbWUgYXV0aG9yIn19",
+			mockListBooks: func(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
+				if params.After.Id != 1 || params.After.Pages != 100 {
+					return nil, fmt.Errorf("unexpected params %+v", params)
+				}
+				return &models.BookPage{
+					Books: []*models.Book{
+						{
+							Id:     2,
+							Title:  "another title",
+							Author: "some author",
+							Pages:  50,
+						},
+					},
+					HasMore: true,
+				}, nil
+			},
+			expectedOutput:     `{"books":[{"id":2,"title":"another title","author":"some author","pages":50}],"next_cursor":"eyJpZCI6MiwidGl0bGUiOiJhbm90aGVyIHRpdGxlIiwiYXV0aG9yIjoic29tZSBhdXRob3IiLCJwYWdlcyI6NTAsImxpc3RpbmciOnsic29ydCI6WyItcGFnZXMiXSwiYXV0aG9yIjoic29tZSBhdXRob3IifX0"}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:  "happy path, with filters and sort",
+			query: "?author=some+author&title_contains=title&min_pages=50&max_pages=200&sort=title,-pages",
+			mockListBooks: func(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
+				expectedParams := &models.ListParams{
+					Limit:         100,
+					Author:        "some author",
+					TitleContains: "title",
+					MinPages:      50,
+					MaxPages:      200,
+					Sort:          []string{"title", "-pages"},
+				}
+				if !reflect.DeepEqual(expectedParams, params) {
+					return nil, fmt.Errorf("unexpected params %+v", params)
+				}
+				return &models.BookPage{
+					Books: []*models.Book{
+						{
+							Id:     1,
+							Title:  "some title",
+							Author: "some author",
+							Pages:  100,
+						},
+					},
+				}, nil
+			},
+			expectedOutput:     `{"books":[{"id":1,"title":"some title","author":"some author","pages":100}]}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "invalid numeric parameters",
+			query:              "?min_pages=abc&max_pages=def",
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"books","errors":[{"field":"min_pages","error":"min_pages must be a number"},{"field":"max_pages","error":"max_pages must be a number"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid sort column",
+			query:              "?sort=title,isbn",
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"books","errors":[{"field":"sort[1]","error":"sort[1] must be one of [id -id title -title author -author pages -pages]"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid limit",
+			query:              "?limit=abc",
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"books","errors":[{"field":"limit","error":"limit must be a number"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "limit out of range",
+			query:              "?limit=0",
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"books","errors":[{"field":"limit","error":"limit must be greater than 0"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "invalid cursor",
+			query:              "?after=invalid!",
+			expectedOutput:     `{"type":"/problems/invalid-cursor","title":"Invalid cursor","status":400,"detail":"invalid cursor","instance":"books"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "cursor of another sort order",
+			query:              "?sort=-pages&after=eyJpZCI6MX0",
+			expectedOutput:     `{"type":"/problems/invalid-cursor","title":"Invalid cursor","status":400,"detail":"the cursor was issued for another sort order or other filters","instance":"books"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "cursor of other filters",
+			query:              "?author=another+author&sort=-pages&after=eyJpZCI6MSwidGl0bGUiOiJzb21lIHRpdGxlIiwiYXV0aG9yIjoic29tZSBhdXRob3IiLCJwYWdlcyI6MTAwLCJsaXN0aW5nIjp7InNvcnQiOlsiLXBhZ2VzIl0sImF1dGhvciI6InNvbWUgYXV0aG9yIn19",
+			expectedOutput:     `{"type":"/problems/invalid-cursor","title":"Invalid cursor","status":400,"detail":"the cursor was issued for another sort order or other filters","instance":"books"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
 		{
 			name: "error",
-			mockListBooks: func(ctx context.Context, db *sql.DB) ([]*models.Book, error) {
+			mockListBooks: func(ctx context.Context, params *models.ListParams) (*models.BookPage, error) {
 				return nil, errors.New("list error")
 			},
-			expectedOutput:     `{"error":"list error"}`,
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"books","incident":"<incident>"}`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			listBooks = tc.mockListBooks
-			req, err := http.NewRequest(http.MethodGet, "books", nil)
+			t.Parallel()
+			req, err := http.NewRequest(http.MethodGet, "books"+tc.query, nil)
 			require.NoError(t, err)
 			rr := httptest.NewRecorder()
-			h := New(nil)
+			h := New(&mockStore{list: tc.mockListBooks}, false)
 			handler := http.HandlerFunc((h).List)
 			handler.ServeHTTP(rr, req)
 			require.Equal(t, tc.expectedStatusCode, rr.Code)
-			require.Equal(t, tc.expectedOutput, rr.Body.String())
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
 		})
 	}
 }
@@ -77,55 +248,69 @@ func TestGetById(t *testing.T) {
 	testCases := []struct {
 		name               string
 		bookId             string
-		mockGetBookById    func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error)
+		mockGetBookById    func(ctx context.Context, bookId int) (*models.Book, error)
+		verboseErrors      bool
 		expectedOutput     string
+		expectedETag       string
 		expectedStatusCode int
 	}{
 		{
 			name:   "happy path",
 			bookId: "1",
-			mockGetBookById: func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
 				return &models.Book{
-					Id:     1,
-					Title:  "some title",
-					Author: "some author",
-					Pages:  100,
+					Id:      1,
+					Title:   "some title",
+					Author:  "some author",
+					Pages:   100,
+					Version: 2,
 				}, nil
 			},
 			expectedOutput:     `{"id":1,"title":"some title","author":"some author","pages":100}`,
+			expectedETag:       `"2"`,
 			expectedStatusCode: http.StatusOK,
 		},
 		{
 			name:   "invalid book id",
 			bookId: "invalidId",
-			mockGetBookById: func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
 				return nil, nil
 			},
-			expectedOutput:     `{"error":"invalid book id"}`,
+			expectedOutput:     `{"type":"/problems/invalid-book-id","title":"Invalid book id","status":400,"detail":"invalid book id","instance":"/book/invalidId"}`,
 			expectedStatusCode: http.StatusBadRequest,
 		},
 		{
 			name:   "book not found",
 			bookId: "1",
-			mockGetBookById: func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
 				return nil, &books.ErrBookNotFound{Id: 1}
 			},
-			expectedOutput:     `{"error":"no book with id 1 found"}`,
+			expectedOutput:     `{"type":"/problems/book-not-found","title":"Book not found","status":404,"detail":"no book with id 1 found","instance":"/book/1"}`,
 			expectedStatusCode: http.StatusNotFound,
 		},
 		{
 			name:   "error",
 			bookId: "1",
-			mockGetBookById: func(ctx context.Context, db *sql.DB, bookId int) (*models.Book, error) {
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
+				return nil, errors.New("GetById error")
+			},
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"/book/1","incident":"<incident>"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+		{
+			name:   "verbose error",
+			bookId: "1",
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
 				return nil, errors.New("GetById error")
 			},
-			expectedOutput:     `{"error":"GetById error"}`,
+			verboseErrors:      true,
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"GetById error","instance":"/book/1","incident":"<incident>"}`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
 	}
 	for _, tc := range testCases {
-		getBookById = tc.mockGetBookById
 		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
 			req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("/book/%s", tc.bookId), nil)
 			require.NoError(t, err)
 			vars := map[string]string{
@@ -133,11 +318,12 @@ func TestGetById(t *testing.T) {
 			}
 			req = mux.SetURLVars(req, vars)
 			rr := httptest.NewRecorder()
-			h := New(nil)
+			h := New(&mockStore{getById: tc.mockGetBookById}, tc.verboseErrors)
 			handler := http.HandlerFunc((h).GetById)
 			handler.ServeHTTP(rr, req)
 			require.Equal(t, tc.expectedStatusCode, rr.Code)
-			require.Equal(t, tc.expectedOutput, rr.Body.String())
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
+			require.Equal(t, tc.expectedETag, rr.Header().Get("ETag"))
 		})
 	}
 }
@@ -146,84 +332,77 @@ func TestCreate(t *testing.T) {
 	testCases := []struct {
 		name               string
 		input              string
-		mockJsonDecode     func(r io.Reader, v any) error
-		mockCreateBook     func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error)
+		mockCreateBook     func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error)
 		expectedOutput     string
+		expectedETag       string
 		expectedStatusCode int
 	}{
 		{
 			name:  "happy path",
 			input: `{"title":"some title","author":"some author","pages":100}`,
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return &models.NewBook{
-					Id:     1,
-					Title:  "some title",
-					Author: "some author",
-					Pages:  100,
+					Id:      1,
+					Title:   "some title",
+					Author:  "some author",
+					Pages:   100,
+					Version: 1,
 				}, nil
 			},
 			expectedOutput:     `{"id":1,"title":"some title","author":"some author","pages":100}`,
+			expectedETag:       `"1"`,
 			expectedStatusCode: http.StatusCreated,
 		},
 		{
 			name:  "error on decoding payload",
 			input: ``,
-			mockJsonDecode: func(r io.Reader, v any) error {
-				return errors.New("decode error")
-			},
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return nil, nil
 			},
-			expectedOutput:     `{"error":"decode error"}`,
+			expectedOutput:     `{"type":"/problems/malformed-body","title":"Malformed request body","status":400,"detail":"malformed request body: EOF","instance":"book"}`,
 			expectedStatusCode: http.StatusBadRequest,
 		},
 		{
 			name:  "validation error",
 			input: `{}`,
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return nil, nil
 			},
-			expectedOutput:     `{"error":"[{\"field\":\"title\",\"error\":\"title is a required field\"},{\"field\":\"author\",\"error\":\"author is a required field\"},{\"field\":\"pages\",\"error\":\"pages is a required field\"}]"}`,
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"book","errors":[{"field":"title","error":"title is a required field"},{"field":"author","error":"author is a required field"},{"field":"pages","error":"pages is a required field"}]}`,
 			expectedStatusCode: http.StatusBadRequest,
 		},
 		{
 			name:  "duplicate book",
 			input: `{"title":"some title","author":"some author","pages":100}`,
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return nil, &books.ErrDuplicateBook{Title: "some title", Author: "some author"}
 			},
-			expectedOutput:     `{"error":"book with title \"some title\" from author \"some author\" already exists"}`,
+			expectedOutput:     `{"type":"/problems/duplicate-book","title":"Duplicate book","status":409,"detail":"book with title \"some title\" from author \"some author\" already exists","instance":"book"}`,
 			expectedStatusCode: http.StatusConflict,
 		},
 		{
 			name:  "error",
 			input: `{"title":"some title","author":"some author","pages":100}`,
-			mockCreateBook: func(ctx context.Context, db *sql.DB, newBook *models.NewBook) (*models.NewBook, error) {
+			mockCreateBook: func(ctx context.Context, newBook *models.NewBook) (*models.NewBook, error) {
 				return nil, errors.New("create error")
 			},
-			expectedOutput:     `{"error":"create error"}`,
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"book","incident":"<incident>"}`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
 	}
-	originalJsonDecode := jsonDecode
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			createBook = tc.mockCreateBook
-			if tc.mockJsonDecode != nil {
-				jsonDecode = tc.mockJsonDecode
-			}
-			defer func() {
-				jsonDecode = originalJsonDecode
-			}()
+			t.Parallel()
 			req, err := http.NewRequest(http.MethodPost, "book", bytes.NewBuffer([]byte(tc.input)))
 			req.Header.Set("Content-Type", "application/json")
 			require.NoError(t, err)
 			rr := httptest.NewRecorder()
-			h := New(nil)
+			h := New(&mockStore{create: tc.mockCreateBook}, false)
 			handler := http.HandlerFunc((h).Create)
 			handler.ServeHTTP(rr, req)
 			require.Equal(t, tc.expectedStatusCode, rr.Code)
-			require.Equal(t, tc.expectedOutput, rr.Body.String())
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
+			require.Equal(t, tc.expectedETag, rr.Header().Get("ETag"))
 		})
 	}
 }
@@ -232,102 +411,340 @@ func TestUpdate(t *testing.T) {
 	testCases := []struct {
 		name               string
 		bookId             string
+		ifMatch            string
 		input              string
-		mockJsonDecode     func(r io.Reader, v any) error
-		mockUpdateBook     func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error)
+		mockUpdateBook     func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error)
 		expectedOutput     string
+		expectedETag       string
 		expectedStatusCode int
 	}{
 		{
-			name:   "happy path",
-			bookId: "1",
-			input:  `{"title":"some title","author":"some author","pages":100}`,
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			name:    "happy path",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			input:   `{"title":"some title","author":"some author","pages":100}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return &models.UpdatedBook{
-					Id:     1,
-					Title:  "some title",
-					Author: "some author",
-					Pages:  100,
+					Id:      1,
+					Title:   "some title",
+					Author:  "some author",
+					Pages:   100,
+					Version: book.Version + 1,
 				}, nil
 			},
 			expectedOutput:     `{"id":1,"title":"some title","author":"some author","pages":100}`,
+			expectedETag:       `"3"`,
 			expectedStatusCode: http.StatusOK,
 		},
 		{
 			name:   "invalid book id",
 			bookId: "invalidId",
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return nil, nil
 			},
-			expectedOutput:     `{"error":"invalid book id"}`,
+			expectedOutput:     `{"type":"/problems/invalid-book-id","title":"Invalid book id","status":400,"detail":"invalid book id","instance":"/book/invalidId"}`,
 			expectedStatusCode: http.StatusBadRequest,
 		},
 		{
-			name:   "error on decoding payload",
+			name:   "missing If-Match header",
 			bookId: "1",
-			input:  ``,
-			mockJsonDecode: func(r io.Reader, v any) error {
-				return errors.New("decode error")
+			input:  `{"title":"some title","author":"some author","pages":100}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+				return nil, nil
 			},
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			expectedOutput:     `{"type":"/problems/precondition-required","title":"Precondition required","status":428,"detail":"the If-Match header is required","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionRequired,
+		},
+		{
+			name:    "invalid entity tag",
+			bookId:  "1",
+			ifMatch: `W/"2"`,
+			input:   `{"title":"some title","author":"some author","pages":100}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return nil, nil
 			},
-			expectedOutput:     `{"error":"decode error"}`,
+			expectedOutput:     `{"type":"/problems/invalid-etag","title":"Invalid entity tag","status":400,"detail":"invalid entity tag","instance":"/book/1"}`,
 			expectedStatusCode: http.StatusBadRequest,
 		},
 		{
-			name:   "validation error",
-			bookId: "1",
-			input:  `{}`,
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			name:    "error on decoding payload",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			input:   ``,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return nil, nil
 			},
-			expectedOutput:     `{"error":"[{\"field\":\"title\",\"error\":\"title is a required field\"},{\"field\":\"author\",\"error\":\"author is a required field\"},{\"field\":\"pages\",\"error\":\"pages is a required field\"}]"}`,
+			expectedOutput:     `{"type":"/problems/malformed-body","title":"Malformed request body","status":400,"detail":"malformed request body: EOF","instance":"/book/1"}`,
 			expectedStatusCode: http.StatusBadRequest,
 		},
 		{
-			name:   "book not found",
-			bookId: "1",
-			input:  `{"title":"some title","author":"some author","pages":100}`,
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			name:    "validation error",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			input:   `{}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+				return nil, nil
+			},
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"/book/1","errors":[{"field":"title","error":"title is a required field"},{"field":"author","error":"author is a required field"},{"field":"pages","error":"pages is a required field"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:    "book not found",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			input:   `{"title":"some title","author":"some author","pages":100}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return nil, &books.ErrBookNotFound{Id: 1}
 			},
-			expectedOutput:     `{"error":"no book with id 1 found"}`,
+			expectedOutput:     `{"type":"/problems/book-not-found","title":"Book not found","status":404,"detail":"no book with id 1 found","instance":"/book/1"}`,
 			expectedStatusCode: http.StatusNotFound,
 		},
 		{
-			name:   "error",
-			bookId: "1",
-			input:  `{"title":"some title","author":"some author","pages":100}`,
-			mockUpdateBook: func(ctx context.Context, db *sql.DB, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+			name:    "duplicate book",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			input:   `{"title":"some title","author":"some author","pages":100}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+				return nil, &books.ErrDuplicateBook{Title: "some title", Author: "some author"}
+			},
+			expectedOutput:     `{"type":"/problems/duplicate-book","title":"Duplicate book","status":409,"detail":"book with title \"some title\" from author \"some author\" already exists","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:    "book not found, any version",
+			bookId:  "1",
+			ifMatch: "*",
+			input:   `{"title":"some title","author":"some author","pages":100}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
+				return nil, &books.ErrBookNotFound{Id: 1}
+			},
+			expectedOutput:     `{"type":"/problems/version-mismatch","title":"Version mismatch","status":412,"detail":"the book does not exist, so \"*\" matches no version of it","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:    "error",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			input:   `{"title":"some title","author":"some author","pages":100}`,
+			mockUpdateBook: func(ctx context.Context, book *models.UpdatedBook) (*models.UpdatedBook, error) {
 				return nil, errors.New("update error")
 			},
-			expectedOutput:     `{"error":"update error"}`,
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"/book/1","incident":"<incident>"}`,
 			expectedStatusCode: http.StatusInternalServerError,
 		},
 	}
-	originalJsonDecode := jsonDecode
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			updateBook = tc.mockUpdateBook
-			if tc.mockJsonDecode != nil {
-				jsonDecode = tc.mockJsonDecode
-			}
-			defer func() {
-				jsonDecode = originalJsonDecode
-			}()
+			t.Parallel()
 			req, err := http.NewRequest(http.MethodPut, fmt.Sprintf("/book/%s", tc.bookId), bytes.NewBuffer([]byte(tc.input)))
 			require.NoError(t, err)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
 			vars := map[string]string{
 				"id": tc.bookId,
 			}
 			req = mux.SetURLVars(req, vars)
 			rr := httptest.NewRecorder()
-			h := New(nil)
+			h := New(&mockStore{update: tc.mockUpdateBook}, false)
 			handler := http.HandlerFunc((h).Update)
 			handler.ServeHTTP(rr, req)
 			require.Equal(t, tc.expectedStatusCode, rr.Code)
-			require.Equal(t, tc.expectedOutput, rr.Body.String())
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
+			require.Equal(t, tc.expectedETag, rr.Header().Get("ETag"))
+		})
+	}
+}
+
+func TestPatch(t *testing.T) {
+	storedBook := func(ctx context.Context, bookId int) (*models.Book, error) {
+		return &models.Book{
+			Id:      1,
+			Title:   "some title",
+			Author:  "some author",
+			Pages:   100,
+			Version: 2,
+		}, nil
+	}
+	testCases := []struct {
+		name               string
+		bookId             string
+		ifMatch            string
+		contentType        string
+		input              string
+		mockGetBookById    func(ctx context.Context, bookId int) (*models.Book, error)
+		mockPatchBook      func(ctx context.Context, patch *models.BookPatch) (*models.Book, error)
+		expectedOutput     string
+		expectedETag       string
+		expectedStatusCode int
+	}{
+		{
+			name:            "happy path",
+			bookId:          "1",
+			ifMatch:         `"2"`,
+			contentType:     "application/merge-patch+json",
+			input:           `{"pages":120}`,
+			mockGetBookById: storedBook,
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				if patch.Id != 1 || patch.Title != nil || patch.Author != nil || patch.Pages == nil || *patch.Pages != 120 || patch.Version != 2 {
+					return nil, errors.New("unexpected patch")
+				}
+				return &models.Book{
+					Id:      1,
+					Title:   "some title",
+					Author:  "some author",
+					Pages:   120,
+					Version: 3,
+				}, nil
+			},
+			expectedOutput:     `{"id":1,"title":"some title","author":"some author","pages":120}`,
+			expectedETag:       `"3"`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "nothing changed",
+			bookId:             "1",
+			ifMatch:            "*",
+			contentType:        "application/merge-patch+json; charset=utf-8",
+			input:              `{"id":7,"pages":100}`,
+			mockGetBookById:    storedBook,
+			expectedOutput:     `{"id":1,"title":"some title","author":"some author","pages":100}`,
+			expectedETag:       `"2"`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "invalid book id",
+			bookId:             "invalidId",
+			expectedOutput:     `{"type":"/problems/invalid-book-id","title":"Invalid book id","status":400,"detail":"invalid book id","instance":"/book/invalidId"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "missing If-Match header",
+			bookId:             "1",
+			contentType:        "application/merge-patch+json",
+			input:              `{"pages":120}`,
+			expectedOutput:     `{"type":"/problems/precondition-required","title":"Precondition required","status":428,"detail":"the If-Match header is required","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionRequired,
+		},
+		{
+			name:               "unsupported media type",
+			bookId:             "1",
+			ifMatch:            `"2"`,
+			contentType:        "application/json",
+			input:              `{"pages":120}`,
+			expectedOutput:     `{"type":"/problems/unsupported-media-type","title":"Unsupported media type","status":415,"detail":"unsupported media type, expected application/merge-patch+json","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:               "malformed patch",
+			bookId:             "1",
+			ifMatch:            `"2"`,
+			contentType:        "application/merge-patch+json",
+			input:              `{"pages":`,
+			expectedOutput:     `{"type":"/problems/malformed-body","title":"Malformed request body","status":400,"detail":"malformed request body: unexpected EOF","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:        "book not found",
+			bookId:      "1",
+			ifMatch:     `"2"`,
+			contentType: "application/merge-patch+json",
+			input:       `{"pages":120}`,
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
+				return nil, &books.ErrBookNotFound{Id: 1}
+			},
+			expectedOutput:     `{"type":"/problems/book-not-found","title":"Book not found","status":404,"detail":"no book with id 1 found","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:        "book not found, any version",
+			bookId:      "1",
+			ifMatch:     "*",
+			contentType: "application/merge-patch+json",
+			input:       `{"pages":120}`,
+			mockGetBookById: func(ctx context.Context, bookId int) (*models.Book, error) {
+				return nil, &books.ErrBookNotFound{Id: 1}
+			},
+			expectedOutput:     `{"type":"/problems/version-mismatch","title":"Version mismatch","status":412,"detail":"the book does not exist, so \"*\" matches no version of it","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:               "version mismatch",
+			bookId:             "1",
+			ifMatch:            `"1"`,
+			contentType:        "application/merge-patch+json",
+			input:              `{"pages":120}`,
+			mockGetBookById:    storedBook,
+			expectedOutput:     `{"type":"/problems/version-mismatch","title":"Version mismatch","status":412,"detail":"book with id 1 is at version 2, not 1","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:               "patched book of the wrong type",
+			bookId:             "1",
+			ifMatch:            `"2"`,
+			contentType:        "application/merge-patch+json",
+			input:              `{"pages":"many"}`,
+			mockGetBookById:    storedBook,
+			expectedOutput:     `{"type":"/problems/malformed-body","title":"Malformed request body","status":400,"detail":"malformed request body: json: cannot unmarshal string into Go struct field UpdatedBook.pages of type int","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:               "validation error",
+			bookId:             "1",
+			ifMatch:            `"2"`,
+			contentType:        "application/merge-patch+json",
+			input:              `{"title":null,"pages":-1}`,
+			mockGetBookById:    storedBook,
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"/book/1","errors":[{"field":"title","error":"title is a required field"},{"field":"pages","error":"pages must be greater than 0"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:            "duplicate book",
+			bookId:          "1",
+			ifMatch:         `"2"`,
+			contentType:     "application/merge-patch+json",
+			input:           `{"title":"another title"}`,
+			mockGetBookById: storedBook,
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				return nil, &books.ErrDuplicateBook{Title: "another title", Author: "some author"}
+			},
+			expectedOutput:     `{"type":"/problems/duplicate-book","title":"Duplicate book","status":409,"detail":"book with title \"another title\" from author \"some author\" already exists","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusConflict,
+		},
+		{
+			name:            "error",
+			bookId:          "1",
+			ifMatch:         `"2"`,
+			contentType:     "application/merge-patch+json",
+			input:           `{"pages":120}`,
+			mockGetBookById: storedBook,
+			mockPatchBook: func(ctx context.Context, patch *models.BookPatch) (*models.Book, error) {
+				return nil, errors.New("patch error")
+			},
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"/book/1","incident":"<incident>"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest(http.MethodPatch, fmt.Sprintf("/book/%s", tc.bookId), bytes.NewBuffer([]byte(tc.input)))
+			require.NoError(t, err)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
+			req.Header.Set("Content-Type", tc.contentType)
+			vars := map[string]string{
+				"id": tc.bookId,
+			}
+			req = mux.SetURLVars(req, vars)
+			rr := httptest.NewRecorder()
+			h := New(&mockStore{getById: tc.mockGetBookById, patch: tc.mockPatchBook}, false)
+			handler := http.HandlerFunc((h).Patch)
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.expectedStatusCode, rr.Code)
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
+			require.Equal(t, tc.expectedETag, rr.Header().Get("ETag"))
 		})
 	}
 }
@@ -336,52 +753,170 @@ func TestDeleteById(t *testing.T) {
 	testCases := []struct {
 		name               string
 		bookId             string
-		mockDeleteBook     func(ctx context.Context, db *sql.DB, bookId int) error
+		ifMatch            string
+		mockDeleteBook     func(ctx context.Context, bookId, version int) error
 		expectedOutput     string
 		expectedStatusCode int
 	}{
 		{
-			name:   "happy path",
-			bookId: "1",
-			mockDeleteBook: func(ctx context.Context, db *sql.DB, bookId int) error {
+			name:    "happy path",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
+				if version != 2 {
+					return errors.New("unexpected version")
+				}
+				return nil
+			},
+			expectedStatusCode: http.StatusNoContent,
+		},
+		{
+			name:    "happy path, any version",
+			bookId:  "1",
+			ifMatch: "*",
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
+				if version != 0 {
+					return errors.New("unexpected version")
+				}
 				return nil
 			},
 			expectedStatusCode: http.StatusNoContent,
 		},
+		{
+			name:    "book not found",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
+				return &books.ErrBookNotFound{Id: 1}
+			},
+			expectedOutput:     `{"type":"/problems/book-not-found","title":"Book not found","status":404,"detail":"no book with id 1 found","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusNotFound,
+		},
+		{
+			name:    "book not found, any version",
+			bookId:  "1",
+			ifMatch: "*",
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
+				return &books.ErrBookNotFound{Id: 1}
+			},
+			expectedOutput:     `{"type":"/problems/version-mismatch","title":"Version mismatch","status":412,"detail":"the book does not exist, so \"*\" matches no version of it","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
 		{
 			name:   "invalid book id",
 			bookId: "invalidId",
-			mockDeleteBook: func(ctx context.Context, db *sql.DB, bookId int) error {
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
 				return nil
 			},
-			expectedOutput:     `{"error":"invalid book id"}`,
+			expectedOutput:     `{"type":"/problems/invalid-book-id","title":"Invalid book id","status":400,"detail":"invalid book id","instance":"/book/invalidId"}`,
 			expectedStatusCode: http.StatusBadRequest,
 		},
 		{
-			name:   "error",
+			name:   "missing If-Match header",
 			bookId: "1",
-			mockDeleteBook: func(ctx context.Context, db *sql.DB, bookId int) error {
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
+				return nil
+			},
+			expectedOutput:     `{"type":"/problems/precondition-required","title":"Precondition required","status":428,"detail":"the If-Match header is required","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionRequired,
+		},
+		{
+			name:    "version mismatch",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
+				return &books.ErrVersionMismatch{Id: 1, Version: 3, Expected: version}
+			},
+			expectedOutput:     `{"type":"/problems/version-mismatch","title":"Version mismatch","status":412,"detail":"book with id 1 is at version 3, not 2","instance":"/book/1"}`,
+			expectedStatusCode: http.StatusPreconditionFailed,
+		},
+		{
+			name:    "error",
+			bookId:  "1",
+			ifMatch: `"2"`,
+			mockDeleteBook: func(ctx context.Context, bookId, version int) error {
 				return errors.New("delete error")
 			},
 			expectedStatusCode: http.StatusInternalServerError,
-			expectedOutput:     `{"error":"delete error"}`,
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"/book/1","incident":"<incident>"}`,
 		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			deleteBook = tc.mockDeleteBook
+			t.Parallel()
 			req, err := http.NewRequest(http.MethodDelete, fmt.Sprintf("/book/%s", tc.bookId), nil)
 			require.NoError(t, err)
+			if tc.ifMatch != "" {
+				req.Header.Set("If-Match", tc.ifMatch)
+			}
 			vars := map[string]string{
 				"id": tc.bookId,
 			}
 			req = mux.SetURLVars(req, vars)
 			rr := httptest.NewRecorder()
-			h := New(nil)
+			h := New(&mockStore{deleteById: tc.mockDeleteBook}, false)
 			handler := http.HandlerFunc((h).DeleteById)
 			handler.ServeHTTP(rr, req)
 			require.Equal(t, tc.expectedStatusCode, rr.Code)
-			require.Equal(t, tc.expectedOutput, rr.Body.String())
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	testCases := []struct {
+		name               string
+		query              string
+		mockSearchBooks    func(ctx context.Context, query string) ([]*models.SearchResult, error)
+		expectedOutput     string
+		expectedStatusCode int
+	}{
+		{
+			name:  "happy path",
+			query: "?q=lord",
+			mockSearchBooks: func(ctx context.Context, query string) ([]*models.SearchResult, error) {
+				return []*models.SearchResult{
+					{
+						Book: models.Book{
+							Id:     1,
+							Title:  "the lord of the rings",
+							Author: "tolkien",
+							Pages:  1000,
+						},
+						Snippet: "the [lord] of the rings",
+						Rank:    -1.5,
+					},
+				}, nil
+			},
+			expectedOutput:     `[{"id":1,"title":"the lord of the rings","author":"tolkien","pages":1000,"snippet":"the [lord] of the rings","rank":-1.5}]`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "missing query",
+			expectedOutput:     `{"type":"/problems/validation-error","title":"Validation failed","status":400,"detail":"the request has invalid fields","instance":"books/search","errors":[{"field":"q","error":"q is a required field"}]}`,
+			expectedStatusCode: http.StatusBadRequest,
+		},
+		{
+			name:  "error",
+			query: "?q=lord",
+			mockSearchBooks: func(ctx context.Context, query string) ([]*models.SearchResult, error) {
+				return nil, errors.New("search error")
+			},
+			expectedOutput:     `{"type":"about:blank","title":"Internal Server Error","status":500,"detail":"an internal error occurred","instance":"books/search","incident":"<incident>"}`,
+			expectedStatusCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			req, err := http.NewRequest(http.MethodGet, "books/search"+tc.query, nil)
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+			h := New(&mockStore{search: tc.mockSearchBooks}, false)
+			handler := http.HandlerFunc((h).Search)
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.expectedStatusCode, rr.Code)
+			require.Equal(t, tc.expectedOutput, withoutIncident(rr.Body.String()))
 		})
 	}
 }
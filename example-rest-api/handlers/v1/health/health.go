@@ -0,0 +1,154 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package health
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/tiagomelo/go-templates/example-rest-api/db"
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
+	"github.com/tiagomelo/go-templates/example-rest-api/web"
+)
+
+// Values of the status field.
+const (
+	statusOk           = "ok"
+	statusReady        = "ready"
+	statusNotReady     = "not ready"
+	statusShuttingDown = "shutting down"
+)
+
+// Values of the error field, which tell what failed without disclosing
+// the error itself, as the probes are public. The error is logged.
+const (
+	errDatabaseUnreachable    = "database unreachable"
+	errMigrationVersionUnread = "migration version could not be read"
+)
+
+// checkTimeout bounds the time spent checking the database, so that
+// probes get an answer before they time out themselves.
+const checkTimeout = 2 * time.Second
+
+// BuildInfo identifies the running binary.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	GoVersion string `json:"go_version"`
+}
+
+// Liveness is the body of the /healthz response.
+type Liveness struct {
+	Status string     `json:"status"`
+	Build  *BuildInfo `json:"build"`
+}
+
+// Readiness is the body of the /readyz response.
+type Readiness struct {
+	Status           string     `json:"status"`
+	Error            string     `json:"error,omitempty"`
+	MigrationVersion int        `json:"migration_version"`
+	Build            *BuildInfo `json:"build"`
+}
+
+// handlers struct holds the database being checked, whether the
+// API is shutting down, and the functions reading the build info
+// and the migration version of the database.
+type handlers struct {
+	db               *sql.DB
+	shuttingDown     *atomic.Bool
+	readBuildInfo    func() (*debug.BuildInfo, bool)
+	migrationVersion func(ctx context.Context, db *sql.DB) (int, error)
+}
+
+// New initializes a new instance of handlers. Readiness fails as soon
+// as shuttingDown is set.
+func New(sqlDb *sql.DB, shuttingDown *atomic.Bool) *handlers {
+	return &handlers{
+		db:               sqlDb,
+		shuttingDown:     shuttingDown,
+		readBuildInfo:    debug.ReadBuildInfo,
+		migrationVersion: db.MigrationVersion,
+	}
+}
+
+// Liveness handles the HTTP request telling whether the process is alive.
+// It does not depend on the database, so that an outage does not get
+// the process restarted.
+func (h *handlers) Liveness(w http.ResponseWriter, r *http.Request) {
+	web.RespondWithJson(w, http.StatusOK, &Liveness{
+		Status: statusOk,
+		Build:  h.buildInfo(),
+	})
+}
+
+// Readiness handles the HTTP request telling whether the API can serve
+// traffic, which requires the database to be reachable and the API not
+// to be shutting down. The migration version is read, not created, so
+// that probes do not write to the database.
+func (h *handlers) Readiness(w http.ResponseWriter, r *http.Request) {
+	readiness := &Readiness{
+		Status: statusReady,
+		Build:  h.buildInfo(),
+	}
+	if h.shuttingDown.Load() {
+		readiness.Status = statusShuttingDown
+		web.RespondWithJson(w, http.StatusServiceUnavailable, readiness)
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), checkTimeout)
+	defer cancel()
+	if err := h.db.PingContext(ctx); err != nil {
+		notReady(w, r, readiness, errDatabaseUnreachable, err)
+		return
+	}
+	version, err := h.migrationVersion(ctx, h.db)
+	if err != nil {
+		notReady(w, r, readiness, errMigrationVersionUnread, err)
+		return
+	}
+	readiness.MigrationVersion = version
+	web.RespondWithJson(w, http.StatusOK, readiness)
+}
+
+// notReady logs err and responds that the API is not ready because of
+// the given reason.
+func notReady(w http.ResponseWriter, r *http.Request, readiness *Readiness, reason string, err error) {
+	logging.FromContext(r.Context()).ErrorContext(r.Context(), "readiness check failed",
+		slog.String("reason", reason),
+		slog.Any("err", err),
+	)
+	readiness.Status = statusNotReady
+	readiness.Error = reason
+	web.RespondWithJson(w, http.StatusServiceUnavailable, readiness)
+}
+
+// buildInfo reads the module version, VCS revision and Go version
+// embedded in the binary.
+func (h *handlers) buildInfo() *BuildInfo {
+	info, ok := h.readBuildInfo()
+	if !ok {
+		return &BuildInfo{Version: "unknown"}
+	}
+	build := &BuildInfo{
+		Version:   info.Main.Version,
+		GoVersion: info.GoVersion,
+	}
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			build.Revision = setting.Value
+		case "vcs.time":
+			build.Time = setting.Value
+		}
+	}
+	return build
+}
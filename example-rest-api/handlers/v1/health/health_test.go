@@ -0,0 +1,146 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package health
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"runtime/debug"
+	"sync/atomic"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
+)
+
+func mockReadBuildInfo() (*debug.BuildInfo, bool) {
+	return &debug.BuildInfo{
+		GoVersion: "go1.22.0",
+		Main:      debug.Module{Version: "v1.0.0"},
+		Settings: []debug.BuildSetting{
+			{Key: "vcs.revision", Value: "abc123"},
+			{Key: "vcs.time", Value: "2023-12-01T10:00:00Z"},
+		},
+	}, true
+}
+
+func TestLiveness(t *testing.T) {
+	testCases := []struct {
+		name              string
+		mockReadBuildInfo func() (*debug.BuildInfo, bool)
+		expectedOutput    string
+	}{
+		{
+			name:              "happy path",
+			mockReadBuildInfo: mockReadBuildInfo,
+			expectedOutput:    `{"status":"ok","build":{"version":"v1.0.0","revision":"abc123","time":"2023-12-01T10:00:00Z","go_version":"go1.22.0"}}`,
+		},
+		{
+			name: "no build info",
+			mockReadBuildInfo: func() (*debug.BuildInfo, bool) {
+				return nil, false
+			},
+			expectedOutput: `{"status":"ok","build":{"version":"unknown","go_version":""}}`,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, "/healthz", nil)
+			require.NoError(t, err)
+			rr := httptest.NewRecorder()
+			h := New(nil, new(atomic.Bool))
+			h.readBuildInfo = tc.mockReadBuildInfo
+			handler := http.HandlerFunc(h.Liveness)
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, http.StatusOK, rr.Code)
+			require.Equal(t, tc.expectedOutput, rr.Body.String())
+		})
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	const build = `"build":{"version":"v1.0.0","revision":"abc123","time":"2023-12-01T10:00:00Z","go_version":"go1.22.0"}`
+	testCases := []struct {
+		name                 string
+		shuttingDown         bool
+		mockClosure          func(mock sqlmock.Sqlmock)
+		mockMigrationVersion func(ctx context.Context, db *sql.DB) (int, error)
+		expectedOutput       string
+		expectedLoggedError  string
+		expectedStatusCode   int
+	}{
+		{
+			name: "happy path",
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+			},
+			mockMigrationVersion: func(ctx context.Context, db *sql.DB) (int, error) {
+				return 2, nil
+			},
+			expectedOutput:     `{"status":"ready","migration_version":2,` + build + `}`,
+			expectedStatusCode: http.StatusOK,
+		},
+		{
+			name:               "shutting down",
+			shuttingDown:       true,
+			mockClosure:        func(mock sqlmock.Sqlmock) {},
+			expectedOutput:     `{"status":"shutting down","migration_version":0,` + build + `}`,
+			expectedStatusCode: http.StatusServiceUnavailable,
+		},
+		{
+			name: "database unreachable",
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing().WillReturnError(errors.New("ping error"))
+			},
+			expectedOutput:      `{"status":"not ready","error":"database unreachable","migration_version":0,` + build + `}`,
+			expectedLoggedError: "ping error",
+			expectedStatusCode:  http.StatusServiceUnavailable,
+		},
+		{
+			name: "error getting migration version",
+			mockClosure: func(mock sqlmock.Sqlmock) {
+				mock.ExpectPing()
+			},
+			mockMigrationVersion: func(ctx context.Context, db *sql.DB) (int, error) {
+				return 0, errors.New("database is dirty at version 2, fix it and force the version")
+			},
+			expectedOutput:      `{"status":"not ready","error":"migration version could not be read","migration_version":0,` + build + `}`,
+			expectedLoggedError: "database is dirty at version 2, fix it and force the version",
+			expectedStatusCode:  http.StatusServiceUnavailable,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New(sqlmock.MonitorPingsOption(true))
+			require.NoError(t, err)
+			defer db.Close()
+			tc.mockClosure(mock)
+			shuttingDown := new(atomic.Bool)
+			shuttingDown.Store(tc.shuttingDown)
+			req, err := http.NewRequest(http.MethodGet, "/readyz", nil)
+			require.NoError(t, err)
+			var logs bytes.Buffer
+			req = req.WithContext(logging.WithLogger(req.Context(), slog.New(slog.NewTextHandler(&logs, nil))))
+			rr := httptest.NewRecorder()
+			h := New(db, shuttingDown)
+			h.readBuildInfo = mockReadBuildInfo
+			h.migrationVersion = tc.mockMigrationVersion
+			handler := http.HandlerFunc(h.Readiness)
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.expectedStatusCode, rr.Code)
+			require.Equal(t, tc.expectedOutput, rr.Body.String())
+			if tc.expectedLoggedError != "" {
+				require.Contains(t, logs.String(), tc.expectedLoggedError)
+			}
+			require.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
@@ -8,39 +8,84 @@ import (
 	"database/sql"
 	"log/slog"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/gorilla/mux"
+	dbBooks "github.com/tiagomelo/go-templates/example-rest-api/db/books"
 	"github.com/tiagomelo/go-templates/example-rest-api/handlers/v1/books"
+	"github.com/tiagomelo/go-templates/example-rest-api/handlers/v1/health"
 	"github.com/tiagomelo/go-templates/example-rest-api/middleware"
 )
 
-// Config struct holds the database connection and logger.
+// Config struct holds the book store, the database checked by the
+// health probes, the shutdown flag, logger, HTTP metrics and whether
+// the text of internal errors is sent to clients.
 type Config struct {
-	Db  *sql.DB
-	Log *slog.Logger
+	Store         dbBooks.BookStore
+	Db            *sql.DB
+	ShuttingDown  *atomic.Bool
+	Log           *slog.Logger
+	Metrics       *middleware.HttpMetrics
+	VerboseErrors bool
 }
 
 // Routes initializes and returns a new router with configured routes.
+// Every request goes through the middlewares, including those matching
+// no route, which gorilla/mux would otherwise serve without them.
 func Routes(c *Config) *mux.Router {
 	router := mux.NewRouter()
-	initializeRoutes(c.Db, router)
-	router.Use(
+	initializeRoutes(c.Store, c.VerboseErrors, router)
+	initializeHealthRoutes(c.Db, c.ShuttingDown, router)
+	middlewares := []mux.MiddlewareFunc{
+		middleware.Tracing,
 		func(h http.Handler) http.Handler {
-			return middleware.Logger(c.Log, h)
+			return middleware.RequestId(c.Log, h)
 		},
+		func(h http.Handler) http.Handler {
+			return middleware.Metrics(c.Metrics, h)
+		},
+		middleware.Logger,
 		middleware.Compress,
 		middleware.PanicRecovery,
-	)
+	}
+	router.Use(middlewares...)
+	router.NotFoundHandler = chain(middlewares, http.NotFoundHandler())
+	router.MethodNotAllowedHandler = chain(middlewares, http.HandlerFunc(methodNotAllowed))
 	return router
 }
 
+// chain wraps h with the given middlewares, the first one being
+// the outermost, as router.Use does.
+func chain(middlewares []mux.MiddlewareFunc, h http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+	return h
+}
+
+// methodNotAllowed responds to requests whose path matches a route
+// but not its method, as gorilla/mux does by default.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusMethodNotAllowed)
+}
+
 // initializeRoutes sets up the routes for book operations.
-func initializeRoutes(db *sql.DB, router *mux.Router) {
-	booksHandlers := books.New(db)
+func initializeRoutes(store dbBooks.BookStore, verboseErrors bool, router *mux.Router) {
+	booksHandlers := books.New(store, verboseErrors)
 	apiRouter := router.PathPrefix("/api").Subrouter()
 	apiRouter.HandleFunc("/v1/book", booksHandlers.Create).Methods(http.MethodPost)
 	apiRouter.HandleFunc("/v1/book/{id}", booksHandlers.Update).Methods(http.MethodPut)
+	apiRouter.HandleFunc("/v1/book/{id}", booksHandlers.Patch).Methods(http.MethodPatch)
 	apiRouter.HandleFunc("/v1/book/{id}", booksHandlers.GetById).Methods(http.MethodGet)
 	apiRouter.HandleFunc("/v1/book/{id}", booksHandlers.DeleteById).Methods(http.MethodDelete)
 	apiRouter.HandleFunc("/v1/books", booksHandlers.List).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/v1/books/search", booksHandlers.Search).Methods(http.MethodGet)
+	apiRouter.HandleFunc("/v1/books:batch", booksHandlers.Batch).Methods(http.MethodPost)
+}
+
+// initializeHealthRoutes sets up the liveness and readiness probes.
+func initializeHealthRoutes(db *sql.DB, shuttingDown *atomic.Bool, router *mux.Router) {
+	healthHandlers := health.New(db, shuttingDown)
+	router.HandleFunc("/healthz", healthHandlers.Liveness).Methods(http.MethodGet)
+	router.HandleFunc("/readyz", healthHandlers.Readiness).Methods(http.MethodGet)
 }
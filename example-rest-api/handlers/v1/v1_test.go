@@ -6,25 +6,43 @@ package v1_test
 
 import (
 	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/tiagomelo/go-templates/example-rest-api/db"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books"
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books/models"
 	"github.com/tiagomelo/go-templates/example-rest-api/handlers"
+	v1 "github.com/tiagomelo/go-templates/example-rest-api/handlers/v1"
+	bookHandlers "github.com/tiagomelo/go-templates/example-rest-api/handlers/v1/books"
+	"github.com/tiagomelo/go-templates/example-rest-api/middleware"
+	"github.com/tiagomelo/go-templates/example-rest-api/web"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	testDb     *sql.DB
-	testServer *httptest.Server
+	testDb           *sql.DB
+	testServer       *httptest.Server
+	testShuttingDown atomic.Bool
 )
 
 func TestMain(m *testing.M) {
@@ -35,10 +53,17 @@ func TestMain(m *testing.M) {
 		fmt.Println("error when connecting to the test database:", err)
 		os.Exit(1)
 	}
+	if err := db.Migrate(context.Background(), testDb); err != nil {
+		fmt.Println("error when migrating the test database:", err)
+		os.Exit(1)
+	}
 	log := slog.New(slog.NewJSONHandler(os.Stdout, nil))
 	apiMux := handlers.NewApiMux(&handlers.ApiMuxConfig{
-		Db:  testDb,
-		Log: log,
+		Store:        books.NewSqliteStore(testDb),
+		Db:           testDb,
+		ShuttingDown: &testShuttingDown,
+		Log:          log,
+		Metrics:      middleware.NewHttpMetrics(prometheus.NewRegistry()),
 	})
 	testServer = httptest.NewServer(apiMux)
 	defer testServer.Close()
@@ -69,13 +94,14 @@ func TestV1GetById(t *testing.T) {
 	require.NoError(t, err)
 	defer resp.Body.Close()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"1"`, resp.Header.Get("ETag"))
 	b, err := io.ReadAll(resp.Body)
 	require.NoError(t, err)
 	assert.Equal(t, expectedOutput, string(b))
 }
 
 func TestV1List(t *testing.T) {
-	expectedOutput := `[{"id":1,"title":"some title","author":"some author","pages":100}]`
+	expectedOutput := `{"books":[{"id":1,"title":"some title","author":"some author","pages":100}]}`
 	resp, err := http.Get(testServer.URL + "/api/v1/books")
 	require.NoError(t, err)
 	defer resp.Body.Close()
@@ -85,6 +111,44 @@ func TestV1List(t *testing.T) {
 	assert.Equal(t, expectedOutput, string(b))
 }
 
+func TestV1RequestId(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/v1/books", nil)
+	require.NoError(t, err)
+	req.Header.Set(middleware.RequestIdHeader, "client-request-id")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "client-request-id", resp.Header.Get(middleware.RequestIdHeader))
+
+	resp, err = http.Get(testServer.URL + "/api/v1/books")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Len(t, resp.Header.Get(middleware.RequestIdHeader), 32)
+}
+
+func TestV1ListWithFilters(t *testing.T) {
+	expectedOutput := `{"books":[]}`
+	resp, err := http.Get(testServer.URL + "/api/v1/books?author=another+author&sort=-pages")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, expectedOutput, string(b))
+}
+
+func TestV1Search(t *testing.T) {
+	resp, err := http.Get(testServer.URL + "/api/v1/books/search?q=some+tit")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var results []*models.SearchResult
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&results))
+	require.Len(t, results, 1)
+	assert.Equal(t, models.Book{Id: 1, Title: "some title", Author: "some author", Pages: 100}, results[0].Book)
+	assert.Equal(t, "<mark>some</mark> <mark>title</mark>", results[0].Snippet)
+}
+
 func TestV1Update(t *testing.T) {
 	bookId := "1"
 	input := `{"title":"new title","author":"new author","pages":150}`
@@ -95,10 +159,49 @@ func TestV1Update(t *testing.T) {
 		"id": bookId,
 	}
 	req = mux.SetURLVars(req, vars)
+	req.Header.Set("If-Match", `"1"`)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"2"`, resp.Header.Get("ETag"))
+	b, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, expectedOutput, string(b))
+}
+
+func TestV1UpdateVersionMismatch(t *testing.T) {
+	input := `{"title":"stale title","author":"stale author","pages":50}`
+	req, err := http.NewRequest(http.MethodPut, testServer.URL+"/api/v1/book/1", bytes.NewBuffer([]byte(input)))
+	require.NoError(t, err)
+	req.Header.Set("If-Match", `"1"`)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusPreconditionFailed, resp.StatusCode)
+	var problem web.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, web.Problem{
+		Type:     web.VersionMismatchProblem,
+		Title:    "Version mismatch",
+		Status:   http.StatusPreconditionFailed,
+		Detail:   "book with id 1 is at version 2, not 1",
+		Instance: "/api/v1/book/1",
+	}, problem)
+}
+
+func TestV1Patch(t *testing.T) {
+	input := `{"pages":300}`
+	expectedOutput := `{"id":1,"title":"new title","author":"new author","pages":300}`
+	req, err := http.NewRequest(http.MethodPatch, testServer.URL+"/api/v1/book/1", bytes.NewBuffer([]byte(input)))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", web.MergePatchContentType)
+	req.Header.Set("If-Match", `"2"`)
 	resp, err := http.DefaultClient.Do(req)
 	require.NoError(t, err)
 	defer resp.Body.Close()
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, `"3"`, resp.Header.Get("ETag"))
 	b, err := io.ReadAll(resp.Body)
 	require.NoError(t, err)
 	assert.Equal(t, expectedOutput, string(b))
@@ -112,7 +215,161 @@ func TestV1DeleteById(t *testing.T) {
 		"id": bookId,
 	}
 	req = mux.SetURLVars(req, vars)
+	req.Header.Set("If-Match", `"3"`)
 	resp, err := http.DefaultClient.Do(req)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusNoContent, resp.StatusCode)
 }
+
+func TestV1GetByIdNotFound(t *testing.T) {
+	resp, err := http.Get(testServer.URL + "/api/v1/book/1")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, web.ProblemContentType, resp.Header.Get("Content-Type"))
+	var problem web.Problem
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.Equal(t, web.Problem{
+		Type:     web.BookNotFoundProblem,
+		Title:    "Book not found",
+		Status:   http.StatusNotFound,
+		Detail:   "no book with id 1 found",
+		Instance: "/api/v1/book/1",
+	}, problem)
+}
+
+func TestV1Batch(t *testing.T) {
+	// batch posts a batch request, returning the status of each operation.
+	batch := func(input string) []int {
+		resp, err := http.Post(testServer.URL+"/api/v1/books:batch", "application/json", bytes.NewBuffer([]byte(input)))
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body bookHandlers.BatchResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		var statuses []int
+		for _, result := range body.Results {
+			statuses = append(statuses, result.Status)
+		}
+		return statuses
+	}
+	assert.Equal(t, []int{http.StatusCreated, http.StatusCreated}, batch(`{"operations":[`+
+		`{"op":"create","title":"first title","author":"some author","pages":100},`+
+		`{"op":"create","title":"second title","author":"some author","pages":100}]}`))
+	assert.Equal(t, []int{http.StatusConflict, http.StatusCreated}, batch(`{"mode":"best_effort","operations":[`+
+		`{"op":"create","title":"first title","author":"some author","pages":100},`+
+		`{"op":"create","title":"third title","author":"some author","pages":100}]}`))
+	assert.Equal(t, []int{http.StatusFailedDependency, http.StatusConflict}, batch(`{"operations":[`+
+		`{"op":"create","title":"fourth title","author":"some author","pages":100},`+
+		`{"op":"create","title":"first title","author":"some author","pages":100}]}`))
+
+	resp, err := http.Get(testServer.URL + "/api/v1/books?author=some+author&sort=title")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	var page models.BookPage
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&page))
+	var titles []string
+	for _, book := range page.Books {
+		titles = append(titles, book.Title)
+	}
+	assert.Equal(t, []string{"first title", "second title", "third title"}, titles)
+}
+
+func TestV1Healthz(t *testing.T) {
+	resp, err := http.Get(testServer.URL + "/healthz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "ok", body["status"])
+	assert.NotEmpty(t, body["build"])
+}
+
+func TestV1Readyz(t *testing.T) {
+	resp, err := http.Get(testServer.URL + "/readyz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var body map[string]any
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "ready", body["status"])
+	assert.Equal(t, float64(3), body["migration_version"])
+
+	testShuttingDown.Store(true)
+	defer testShuttingDown.Store(false)
+	resp, err = http.Get(testServer.URL + "/readyz")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+}
+
+func TestV1Tracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(trace.NewNoopTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	req, err := http.NewRequest(http.MethodGet, testServer.URL+"/api/v1/books", nil)
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	dbSpan, httpSpan := spans[0], spans[1]
+	require.Equal(t, "books.List", dbSpan.Name)
+	require.Equal(t, "GET /api/v1/books", httpSpan.Name)
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", httpSpan.SpanContext.TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", httpSpan.Parent.SpanID().String())
+	require.Equal(t, httpSpan.SpanContext.TraceID(), dbSpan.SpanContext.TraceID())
+	require.Equal(t, httpSpan.SpanContext.SpanID(), dbSpan.Parent.SpanID())
+}
+
+func TestV1UnmatchedRequests(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	router := v1.Routes(&v1.Config{
+		Log:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+		Metrics: middleware.NewHttpMetrics(reg),
+	})
+	testCases := []struct {
+		name           string
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{
+			name:           "not found",
+			method:         http.MethodGet,
+			path:           "/api/v1/authors",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "method not allowed",
+			method:         http.MethodPost,
+			path:           "/healthz",
+			expectedStatus: http.StatusMethodNotAllowed,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tc.method, tc.path, nil))
+			require.Equal(t, tc.expectedStatus, rec.Code)
+			require.NotEmpty(t, rec.Header().Get(middleware.RequestIdHeader))
+		})
+	}
+	expectedMetrics := `
+# HELP books_http_requests_total Number of HTTP requests handled, by route, method and status code.
+# TYPE books_http_requests_total counter
+books_http_requests_total{code="404",method="GET",route="unknown"} 1
+books_http_requests_total{code="405",method="POST",route="unknown"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(reg, strings.NewReader(expectedMetrics), "books_http_requests_total"))
+}
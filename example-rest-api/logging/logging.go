@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+// Package logging carries the request id and the request-scoped logger
+// in the context, so that handlers and the database layer can log
+// entries that are correlated with the request that caused them.
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+// ctxKey is the type of the keys of the values stored in the context.
+type ctxKey int
+
+const (
+	requestIdKey ctxKey = iota
+	loggerKey
+)
+
+// WithRequestId returns a copy of ctx holding the given request id.
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey, requestId)
+}
+
+// RequestId returns the request id held by ctx, or an empty
+// string if there is none.
+func RequestId(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey).(string)
+	return requestId
+}
+
+// WithLogger returns a copy of ctx holding the given logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, logger)
+}
+
+// FromContext returns the logger held by ctx, or slog's default
+// logger if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,27 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestId(t *testing.T) {
+	require.Equal(t, "", RequestId(context.TODO()))
+	ctx := WithRequestId(context.TODO(), "some-request-id")
+	require.Equal(t, "some-request-id", RequestId(ctx))
+}
+
+func TestFromContext(t *testing.T) {
+	require.Equal(t, slog.Default(), FromContext(context.TODO()))
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	ctx := WithLogger(context.TODO(), logger)
+	require.Equal(t, logger, FromContext(ctx))
+}
@@ -0,0 +1,50 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	m := NewHttpMetrics(prometheus.NewRegistry())
+	router := mux.NewRouter()
+	router.HandleFunc("/api/v1/book/{id}", func(w http.ResponseWriter, r *http.Request) {
+		inFlight := testutil.ToFloat64(m.inFlight.WithLabelValues("/api/v1/book/{id}", r.Method))
+		require.Equal(t, float64(1), inFlight)
+		if mux.Vars(r)["id"] == "0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	}).Methods(http.MethodGet)
+	router.Use(func(h http.Handler) http.Handler {
+		return Metrics(m, h)
+	})
+
+	for _, path := range []string{"/api/v1/book/1", "/api/v1/book/2", "/api/v1/book/0"} {
+		req, err := http.NewRequest(http.MethodGet, path, nil)
+		require.NoError(t, err)
+		router.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	require.Equal(t, float64(2), testutil.ToFloat64(m.requests.WithLabelValues("/api/v1/book/{id}", http.MethodGet, "200")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.requests.WithLabelValues("/api/v1/book/{id}", http.MethodGet, "404")))
+	require.Equal(t, 2, testutil.CollectAndCount(m.duration))
+	require.Equal(t, float64(0), testutil.ToFloat64(m.inFlight.WithLabelValues("/api/v1/book/{id}", http.MethodGet)))
+}
+
+func TestRouteTemplate(t *testing.T) {
+	req, err := http.NewRequest(http.MethodGet, "/api/v1/book/1", nil)
+	require.NoError(t, err)
+	require.Equal(t, unknownRoute, routeTemplate(req))
+}
@@ -10,23 +10,30 @@ import (
 	"time"
 
 	"github.com/gorilla/handlers"
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
 )
 
 // Logger is a middleware that logs the start and end of each HTTP request along with
-// some additional information.
-func Logger(log *slog.Logger, next http.Handler) http.Handler {
+// some additional information, such as the status code and the number of bytes
+// written, using the request-scoped logger set by RequestId.
+func Logger(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now().UTC()
+		log := logging.FromContext(r.Context())
 		log.Info("request started",
 			slog.String("method", r.Method),
 			slog.String("path", r.URL.Path),
 			slog.String("remoteaddr", r.RemoteAddr),
 		)
-		next.ServeHTTP(w, r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
 		log.Info("request completed",
 			slog.String("method", r.Method),
 			slog.String("path", r.URL.Path),
 			slog.String("remoteaddr", r.RemoteAddr),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.String("useragent", r.UserAgent()),
 			slog.Duration("since", time.Since(start)),
 		)
 	})
@@ -0,0 +1,70 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
+)
+
+func TestLogger(t *testing.T) {
+	testCases := []struct {
+		name           string
+		handler        http.HandlerFunc
+		expectedStatus float64
+		expectedBytes  float64
+	}{
+		{
+			name: "happy path",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte(`{"id":1}`))
+			},
+			expectedStatus: http.StatusOK,
+			expectedBytes:  8,
+		},
+		{
+			name: "error",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNotFound)
+				w.Write([]byte(`{"error":"not found"}`))
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBytes:  21,
+		},
+		{
+			name:           "no body",
+			handler:        func(w http.ResponseWriter, r *http.Request) {},
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log := slog.New(slog.NewJSONHandler(&out, nil))
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/books", nil)
+			req.Header.Set("User-Agent", "books-client/1.0")
+			req = req.WithContext(logging.WithLogger(req.Context(), log))
+			Logger(tc.handler).ServeHTTP(httptest.NewRecorder(), req)
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			require.Len(t, lines, 2)
+			var entry map[string]any
+			require.NoError(t, json.Unmarshal([]byte(lines[1]), &entry))
+			require.Equal(t, "request completed", entry["msg"])
+			require.Equal(t, "/api/v1/books", entry["path"])
+			require.Equal(t, tc.expectedStatus, entry["status"])
+			require.Equal(t, tc.expectedBytes, entry["bytes"])
+			require.Equal(t, "books-client/1.0", entry["useragent"])
+		})
+	}
+}
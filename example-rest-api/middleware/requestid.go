@@ -0,0 +1,67 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
+)
+
+// RequestIdHeader is the header carrying the id of a request.
+const RequestIdHeader = "X-Request-ID"
+
+// maxRequestIdLength is the length of the longest request id accepted
+// from clients.
+const maxRequestIdLength = 128
+
+// RequestId is a middleware that identifies each request with the id sent
+// by the client in the X-Request-ID header, or with a newly generated one
+// if there is none or it is not valid, and echoes it in the response.
+// The id is stored in the request's context along with a logger that
+// includes it in every entry, which can be retrieved with logging.FromContext.
+func RequestId(log *slog.Logger, next http.Handler) http.Handler {
+	return requestIdHandler(log, newRequestId, next)
+}
+
+// requestIdHandler is RequestId with the function generating
+// request ids given.
+func requestIdHandler(log *slog.Logger, newRequestId func() string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestId := r.Header.Get(RequestIdHeader)
+		if !validRequestId(requestId) {
+			requestId = newRequestId()
+		}
+		w.Header().Set(RequestIdHeader, requestId)
+		ctx := logging.WithRequestId(r.Context(), requestId)
+		ctx = logging.WithLogger(ctx, log.With(slog.String("requestid", requestId)))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// newRequestId generates a random request id.
+func newRequestId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// validRequestId tells whether a request id sent by a client is short
+// and made of printable ASCII characters only, so that it cannot be
+// used to forge log entries.
+func validRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLength {
+		return false
+	}
+	for i := 0; i < len(requestId); i++ {
+		if requestId[i] < '!' || requestId[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
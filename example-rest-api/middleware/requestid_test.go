@@ -0,0 +1,78 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
+)
+
+func TestRequestId(t *testing.T) {
+	testCases := []struct {
+		name              string
+		requestId         string
+		expectedRequestId string
+	}{
+		{
+			name:              "id sent by client",
+			requestId:         "3f1c2a9e-client",
+			expectedRequestId: "3f1c2a9e-client",
+		},
+		{
+			name:              "no id",
+			expectedRequestId: "generated",
+		},
+		{
+			name:              "id with spaces",
+			requestId:         "forged entry",
+			expectedRequestId: "generated",
+		},
+		{
+			name:              "id with control characters",
+			requestId:         "id\x1b[31m",
+			expectedRequestId: "generated",
+		},
+		{
+			name:              "id too long",
+			requestId:         strings.Repeat("a", maxRequestIdLength+1),
+			expectedRequestId: "generated",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			log := slog.New(slog.NewTextHandler(&out, nil))
+			newRequestId := func() string {
+				return "generated"
+			}
+			handler := requestIdHandler(log, newRequestId, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				require.Equal(t, tc.expectedRequestId, logging.RequestId(r.Context()))
+				logging.FromContext(r.Context()).Info("handling request")
+			}))
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/books", nil)
+			if tc.requestId != "" {
+				req.Header.Set(RequestIdHeader, tc.requestId)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			require.Equal(t, tc.expectedRequestId, rr.Header().Get(RequestIdHeader))
+			require.Contains(t, out.String(), "requestid="+tc.expectedRequestId)
+		})
+	}
+}
+
+func TestNewRequestId(t *testing.T) {
+	id := newRequestId()
+	require.Len(t, id, 32)
+	require.True(t, validRequestId(id))
+	require.NotEqual(t, id, newRequestId())
+}
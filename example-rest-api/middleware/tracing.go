@@ -0,0 +1,30 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package middleware
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing is a middleware that records each HTTP request as a server span,
+// continuing the W3C trace context sent by the client, if any. Spans are
+// named after the method and route template, such as GET /api/v1/book/{id},
+// so that requests for different books are grouped together.
+func Tracing(next http.Handler) http.Handler {
+	withRoute := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		trace.SpanFromContext(r.Context()).SetAttributes(semconv.HTTPRoute(routeTemplate(r)))
+		next.ServeHTTP(w, r)
+	})
+	return otelhttp.NewHandler(withRoute, "", otelhttp.WithSpanNameFormatter(spanName))
+}
+
+// spanName names the span of a request after its method and route template.
+func spanName(_ string, r *http.Request) string {
+	return r.Method + " " + routeTemplate(r)
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+)
+
+// Cursor holds the position from which a paginated listing is resumed,
+// that is, the values of the last book of the previous page, along with
+// the listing it was issued for, as the position means nothing under
+// another sort order or other filters.
+type Cursor struct {
+	Id      int     `json:"id"`
+	Title   string  `json:"title,omitempty"`
+	Author  string  `json:"author,omitempty"`
+	Pages   int     `json:"pages,omitempty"`
+	Listing Listing `json:"listing"`
+}
+
+// Listing holds the sort order and filters of a paginated listing.
+type Listing struct {
+	Sort          []string `json:"sort,omitempty"`
+	Author        string   `json:"author,omitempty"`
+	TitleContains string   `json:"title_contains,omitempty"`
+	MinPages      int      `json:"min_pages,omitempty"`
+	MaxPages      int      `json:"max_pages,omitempty"`
+}
+
+// Equal reports whether l and other have the same sort order and filters.
+func (l Listing) Equal(other Listing) bool {
+	return slices.Equal(l.Sort, other.Sort) &&
+		l.Author == other.Author &&
+		l.TitleContains == other.TitleContains &&
+		l.MinPages == other.MinPages &&
+		l.MaxPages == other.MaxPages
+}
+
+// EncodeCursor encodes a cursor into an opaque token that can be
+// handed to clients.
+func EncodeCursor(c *Cursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor decodes an opaque token previously created by EncodeCursor.
+func DecodeCursor(token string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
@@ -6,5 +6,31 @@ package web
 
 import "errors"
 
-// ErrInvalidBookId is an error representing an invalid or malformed book ID.
-var ErrInvalidBookId = errors.New("invalid book id")
+var (
+	// ErrInvalidBookId is an error representing an invalid or malformed book ID.
+	ErrInvalidBookId = errors.New("invalid book id")
+
+	// ErrInvalidCursor is an error representing an invalid or malformed pagination cursor.
+	ErrInvalidCursor = errors.New("invalid cursor")
+
+	// ErrCursorMismatch is an error representing a pagination cursor issued for another sort order or other filters.
+	ErrCursorMismatch = errors.New("the cursor was issued for another sort order or other filters")
+
+	// ErrMalformedBody is an error representing a request body that is not valid JSON.
+	ErrMalformedBody = errors.New("malformed request body")
+
+	// ErrUnsupportedMediaType is an error representing a request body of a media type that is not supported.
+	ErrUnsupportedMediaType = errors.New("unsupported media type, expected " + MergePatchContentType)
+
+	// ErrPreconditionRequired is an error representing a missing 'If-Match' header.
+	ErrPreconditionRequired = errors.New("the If-Match header is required")
+
+	// ErrRolledBack is an error representing an operation of a batch that was undone as others failed.
+	ErrRolledBack = errors.New("rolled back as other operations of the batch failed")
+
+	// ErrNoVersionToMatch is an error representing an 'If-Match: *' header sent for a book that does not exist.
+	ErrNoVersionToMatch = errors.New(`the book does not exist, so "*" matches no version of it`)
+
+	// ErrInvalidETag is an error representing an 'If-Match' header that is not a book's ETag.
+	ErrInvalidETag = errors.New("invalid entity tag")
+)
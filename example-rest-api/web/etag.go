@@ -0,0 +1,47 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// AnyVersion is the version IfMatchVersion and ParseETag return for "*",
+// which matches any version of a book, as long as the book exists.
+const AnyVersion = 0
+
+// ETag returns the entity tag of a book at the given version.
+func ETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// IfMatchVersion extracts the book version from the 'If-Match' header
+// of an HTTP request, which is required, so that a missing header is
+// told apart from "*", for which AnyVersion is returned.
+func IfMatchVersion(r *http.Request) (int, error) {
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	if ifMatch == "" {
+		return 0, ErrPreconditionRequired
+	}
+	return ParseETag(ifMatch)
+}
+
+// ParseETag extracts the book version from an entity tag as returned by
+// ETag. "*" matches any version, in which case AnyVersion is returned.
+func ParseETag(etag string) (int, error) {
+	if etag == "*" {
+		return AnyVersion, nil
+	}
+	if len(etag) < 2 || !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) {
+		return 0, ErrInvalidETag
+	}
+	version, err := strconv.Atoi(etag[1 : len(etag)-1])
+	if err != nil || version <= 0 {
+		return 0, ErrInvalidETag
+	}
+	return version, nil
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+)
+
+// MergePatchContentType is the media type of JSON merge patches.
+const MergePatchContentType = "application/merge-patch+json"
+
+// ReadMergePatch reads the JSON merge patch, as defined by RFC 7396,
+// sent in the body of an HTTP request as application/merge-patch+json.
+func ReadMergePatch(r *http.Request) (any, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != MergePatchContentType {
+		return nil, ErrUnsupportedMediaType
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+	patch, err := decodeJsonValue(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+	return patch, nil
+}
+
+// ApplyMergePatch applies a JSON merge patch, as read by ReadMergePatch,
+// to the JSON representation of v, decoding the result into dst.
+func ApplyMergePatch(v, patch, dst any) error {
+	doc, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	target, err := decodeJsonValue(doc)
+	if err != nil {
+		return err
+	}
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(merged, dst); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+	return nil
+}
+
+// mergePatch implements the MergePatch function of RFC 7396: members of
+// an object patch are merged into target recursively, members set to
+// null being removed, while any other patch replaces target altogether.
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = make(map[string]any)
+	}
+	for name, value := range patchObj {
+		if value == nil {
+			delete(targetObj, name)
+			continue
+		}
+		targetObj[name] = mergePatch(targetObj[name], value)
+	}
+	return targetObj
+}
+
+// decodeJsonValue decodes a JSON document keeping its numbers as they
+// are written, so that they survive being encoded again.
+func decodeJsonValue(data []byte) (any, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	return v, nil
+}
@@ -0,0 +1,113 @@
+// Copyright (c) 2023 Tiago Melo. All rights reserved.
+// Use of this source code is governed by the MIT License that can be found in
+// the LICENSE file.
+
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/tiagomelo/go-templates/example-rest-api/db/books"
+	"github.com/tiagomelo/go-templates/example-rest-api/validate"
+)
+
+// ProblemContentType is the media type of problem details responses.
+const ProblemContentType = "application/problem+json"
+
+// Problem types, which identify the kind of problem regardless of the
+// request it happened in. "about:blank" means that the problem has no
+// other semantics than its status code.
+const (
+	BlankProblem                = "about:blank"
+	ValidationProblem           = "/problems/validation-error"
+	MalformedBodyProblem        = "/problems/malformed-body"
+	InvalidBookIdProblem        = "/problems/invalid-book-id"
+	InvalidCursorProblem        = "/problems/invalid-cursor"
+	InvalidETagProblem          = "/problems/invalid-etag"
+	BookNotFoundProblem         = "/problems/book-not-found"
+	DuplicateBookProblem        = "/problems/duplicate-book"
+	VersionMismatchProblem      = "/problems/version-mismatch"
+	UnsupportedMediaTypeProblem = "/problems/unsupported-media-type"
+	PreconditionRequiredProblem = "/problems/precondition-required"
+	RolledBackProblem           = "/problems/rolled-back"
+)
+
+// Problem describes an error as defined by RFC 7807, problem details
+// for HTTP APIs.
+type Problem struct {
+	// URI reference identifying the problem type.
+	Type string `json:"type"`
+	// Short summary of the problem type.
+	Title string `json:"title"`
+	// HTTP status code.
+	Status int `json:"status"`
+	// Explanation specific to this occurrence of the problem.
+	Detail string `json:"detail,omitempty"`
+	// Path of the request in which the problem occurred.
+	Instance string `json:"instance,omitempty"`
+	// Invalid fields, for validation errors.
+	Errors validate.FieldErrors `json:"errors,omitempty"`
+	// Id under which an internal error was logged.
+	Incident string `json:"incident,omitempty"`
+}
+
+// NewProblem describes err, which happened while serving r, as a problem.
+// Validation errors, invalid ids, cursors, entity tags and request bodies
+// are mapped to 400, missing books to 404, duplicate books to 409, books
+// changed since the version a request was based on to 412, request bodies
+// of unsupported media types to 415, operations of a batch undone as
+// others failed to 424 and missing 'If-Match' headers to 428. Any other
+// error is an internal error, whose text is not disclosed.
+func NewProblem(r *http.Request, err error) *Problem {
+	p := &Problem{
+		Detail:   err.Error(),
+		Instance: r.URL.Path,
+	}
+	var (
+		fieldErrors   validate.FieldErrors
+		notFoundErr   *books.ErrBookNotFound
+		duplicatedErr *books.ErrDuplicateBook
+		mismatchErr   *books.ErrVersionMismatch
+	)
+	switch {
+	case errors.As(err, &fieldErrors):
+		p.Type, p.Title, p.Status = ValidationProblem, "Validation failed", http.StatusBadRequest
+		p.Detail = "the request has invalid fields"
+		p.Errors = fieldErrors
+	case errors.Is(err, ErrMalformedBody):
+		p.Type, p.Title, p.Status = MalformedBodyProblem, "Malformed request body", http.StatusBadRequest
+	case errors.Is(err, ErrInvalidBookId):
+		p.Type, p.Title, p.Status = InvalidBookIdProblem, "Invalid book id", http.StatusBadRequest
+	case errors.Is(err, ErrInvalidCursor), errors.Is(err, ErrCursorMismatch):
+		p.Type, p.Title, p.Status = InvalidCursorProblem, "Invalid cursor", http.StatusBadRequest
+	case errors.Is(err, ErrInvalidETag):
+		p.Type, p.Title, p.Status = InvalidETagProblem, "Invalid entity tag", http.StatusBadRequest
+	case errors.As(err, &notFoundErr):
+		p.Type, p.Title, p.Status = BookNotFoundProblem, "Book not found", http.StatusNotFound
+	case errors.As(err, &duplicatedErr):
+		p.Type, p.Title, p.Status = DuplicateBookProblem, "Duplicate book", http.StatusConflict
+	case errors.As(err, &mismatchErr), errors.Is(err, ErrNoVersionToMatch):
+		p.Type, p.Title, p.Status = VersionMismatchProblem, "Version mismatch", http.StatusPreconditionFailed
+	case errors.Is(err, ErrUnsupportedMediaType):
+		p.Type, p.Title, p.Status = UnsupportedMediaTypeProblem, "Unsupported media type", http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrRolledBack):
+		p.Type, p.Title, p.Status = RolledBackProblem, "Rolled back", http.StatusFailedDependency
+	case errors.Is(err, ErrPreconditionRequired):
+		p.Type, p.Title, p.Status = PreconditionRequiredProblem, "Precondition required", http.StatusPreconditionRequired
+	default:
+		p.Type, p.Title, p.Status = BlankProblem, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError
+		p.Detail = "an internal error occurred"
+	}
+	return p
+}
+
+// RespondWithProblem responds with the given problem details.
+func RespondWithProblem(w http.ResponseWriter, p *Problem) {
+	response, _ := json.Marshal(p)
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(p.Status)
+	w.Write(response)
+}
@@ -5,6 +5,8 @@
 package web
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"strconv"
 
@@ -27,3 +29,11 @@ func BookIdPathParam(r *http.Request) (int, error) {
 	}
 	return id, nil
 }
+
+// DecodeJson decodes the JSON body of an HTTP request into v.
+func DecodeJson(r *http.Request, v any) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return fmt.Errorf("%w: %v", ErrMalformedBody, err)
+	}
+	return nil
+}
@@ -5,13 +5,33 @@
 package web
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"log/slog"
 	"net/http"
+
+	"github.com/tiagomelo/go-templates/example-rest-api/logging"
 )
 
-// RespondWithError responds a json with an error message
-func RespondWithError(w http.ResponseWriter, code int, message string) {
-	RespondWithJson(w, code, map[string]string{"error": message})
+// RespondWithError responds with the problem details describing err,
+// which happened while serving r. Internal errors are logged with the
+// request-scoped logger under a new incident id, which is the only
+// thing clients get to see about them, unless verbose is set, in which
+// case the text of the error is sent as the detail.
+func RespondWithError(w http.ResponseWriter, r *http.Request, err error, verbose bool) {
+	p := NewProblem(r, err)
+	if p.Status == http.StatusInternalServerError {
+		p.Incident = newIncidentId()
+		logging.FromContext(r.Context()).ErrorContext(r.Context(), "internal error",
+			slog.String("incident", p.Incident),
+			slog.Any("err", err),
+		)
+		if verbose {
+			p.Detail = err.Error()
+		}
+	}
+	RespondWithProblem(w, p)
 }
 
 // RespondWithJson responds a json with an error message
@@ -27,3 +47,10 @@ func RespondWithJson(w http.ResponseWriter, code int, payload interface{}) {
 func RespondWithStatus(w http.ResponseWriter, code int) {
 	w.WriteHeader(code)
 }
+
+// newIncidentId generates a random id for an internal error.
+func newIncidentId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}